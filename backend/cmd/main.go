@@ -4,8 +4,10 @@ import (
 	"file_manager/database"
 	"file_manager/database/models"
 	"file_manager/handlers"
+	"file_manager/storage"
 	"file_manager/webserver"
 	"fmt"
+	"time"
 )
 
 func main() {
@@ -16,11 +18,19 @@ func main() {
 
 	newModels := models.New(db)
 
-	handler, err := handlers.New(newModels)
+	storageBackend, err := storage.New()
+	if err != nil {
+		panic(fmt.Errorf("ERROR initializing storage backend: %s", err))
+	}
+
+	handler, err := handlers.New(newModels, storageBackend)
 	if err != nil {
 		panic(fmt.Errorf("ERROR creating the handler: %s", err))
 	}
 
+	handler.StartUploadSessionsCleaner(time.Hour)
+	handler.StartExpirationSweeper(10 * time.Minute)
+
 	srv, err := webserver.New(handler, "8000")
 	if err != nil {
 		panic(fmt.Errorf("ERROR creating the server: %s", err))
@@ -0,0 +1,345 @@
+// mockidp -> A minimal OpenID Connect provider for trying single sign-on locally. Never use it in production:
+// it logs in whoever types an email. It supports the authorization code flow with PKCE (S256) only and signs the
+// ID tokens (RS256) with a key generated on start. Configure the backend with:
+//
+//	OIDC_PROVIDERS=mock
+//	OIDC_MOCK_ISSUER=http://localhost:9400
+//	OIDC_MOCK_CLIENT_ID=file-manager
+//	OIDC_MOCK_CLIENT_SECRET=mock-secret
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	codeLifetime    = time.Minute
+	idTokenLifetime = 5 * time.Minute
+)
+
+type authorizationCode struct {
+	clientId      string
+	redirectUri   string
+	codeChallenge string
+	nonce         string
+	subject       string
+	email         string
+	emailVerified bool
+	expiryAt      time.Time
+}
+
+type provider struct {
+	issuer       string
+	clientId     string
+	clientSecret string
+	key          *rsa.PrivateKey
+	keyId        string
+
+	mu    sync.Mutex
+	codes map[string]*authorizationCode
+}
+
+var loginPage = template.Must(template.New("login").Parse(`<!doctype html>
+<html><head><title>Mock IdP</title></head>
+<body style="font-family: sans-serif; max-width: 24rem; margin: 4rem auto">
+	<h2>Mock IdP login</h2>
+	<p>Logging in to <b>{{.ClientId}}</b></p>
+	<form method="post" action="/authorize">
+		{{range $name, $value := .Params}}<input type="hidden" name="{{$name}}" value="{{$value}}">{{end}}
+		<p><label>Email <input type="email" name="email" value="{{.LoginHint}}" required autofocus></label></p>
+		<p><label>Subject <input type="text" name="sub" placeholder="derived from the email"></label></p>
+		<p><label><input type="checkbox" name="email_verified" value="true" checked> email verified</label></p>
+		<button type="submit" name="action" value="allow">Log in</button>
+		<button type="submit" name="action" value="deny" formnovalidate>Deny</button>
+	</form>
+</body></html>`))
+
+func main() {
+	port := getEnvOrDefault("MOCK_IDP_PORT", "9400")
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(fmt.Errorf("ERROR generating the signing key: %s", err))
+	}
+
+	idp := &provider{
+		issuer:       getEnvOrDefault("MOCK_IDP_ISSUER", "http://localhost:"+port),
+		clientId:     getEnvOrDefault("MOCK_IDP_CLIENT_ID", "file-manager"),
+		clientSecret: getEnvOrDefault("MOCK_IDP_CLIENT_SECRET", "mock-secret"),
+		key:          key,
+		keyId:        rand.Text()[:8],
+		codes:        make(map[string]*authorizationCode),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", idp.discovery)
+	mux.HandleFunc("GET /jwks", idp.jwks)
+	mux.HandleFunc("GET /authorize", idp.authorizePage)
+	mux.HandleFunc("POST /authorize", idp.authorize)
+	mux.HandleFunc("POST /token", idp.token)
+
+	fmt.Printf("mock IdP %s (client %q, secret %q)\n", idp.issuer, idp.clientId, idp.clientSecret)
+	if err := http.ListenAndServe(":"+port, mux); err != nil {
+		panic(err)
+	}
+}
+
+func (idp *provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                idp.issuer,
+		"authorization_endpoint":                idp.issuer + "/authorize",
+		"token_endpoint":                        idp.issuer + "/token",
+		"jwks_uri":                              idp.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+		"scopes_supported":                      []string{"openid", "email", "profile"},
+	})
+}
+
+func (idp *provider) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": idp.keyId,
+			"n":   base64.RawURLEncoding.EncodeToString(idp.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(idp.key.E)).Bytes()),
+		}},
+	})
+}
+
+// authorizePage -> Checks the authorization request and shows the login form, the request is carried by the form
+func (idp *provider) authorizePage(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if err := idp.checkAuthorizationRequest(query); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	params := make(map[string]string)
+	for _, name := range []string{"client_id", "redirect_uri", "state", "nonce", "code_challenge", "scope"} {
+		params[name] = query.Get(name)
+	}
+
+	data := map[string]any{
+		"ClientId":  query.Get("client_id"),
+		"LoginHint": query.Get("login_hint"),
+		"Params":    params,
+	}
+
+	if err := loginPage.Execute(w, data); err != nil {
+		slog.Error("rendering login page", "error", err)
+	}
+}
+
+// authorize -> Issues a code for the typed email (or the access_denied error) and redirects back to the client
+func (idp *provider) authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	form := r.PostForm
+	form.Set("response_type", "code")
+	form.Set("code_challenge_method", "S256")
+
+	if err := idp.checkAuthorizationRequest(form); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	redirect := url.Values{}
+	redirect.Set("state", form.Get("state"))
+
+	if form.Get("action") == "deny" {
+		redirect.Set("error", "access_denied")
+		redirect.Set("error_description", "the user denied the login")
+		http.Redirect(w, r, form.Get("redirect_uri")+"?"+redirect.Encode(), http.StatusFound)
+		return
+	}
+
+	email := strings.TrimSpace(form.Get("email"))
+	if email == "" {
+		http.Error(w, "email is required", http.StatusBadRequest)
+		return
+	}
+
+	subject := strings.TrimSpace(form.Get("sub"))
+	if subject == "" {
+		hash := sha256.Sum256([]byte(strings.ToLower(email)))
+		subject = base64.RawURLEncoding.EncodeToString(hash[:12])
+	}
+
+	code := rand.Text()
+
+	idp.mu.Lock()
+	idp.codes[code] = &authorizationCode{
+		clientId:      form.Get("client_id"),
+		redirectUri:   form.Get("redirect_uri"),
+		codeChallenge: form.Get("code_challenge"),
+		nonce:         form.Get("nonce"),
+		subject:       subject,
+		email:         email,
+		emailVerified: form.Get("email_verified") == "true",
+		expiryAt:      time.Now().Add(codeLifetime),
+	}
+	idp.mu.Unlock()
+
+	redirect.Set("code", code)
+	http.Redirect(w, r, form.Get("redirect_uri")+"?"+redirect.Encode(), http.StatusFound)
+}
+
+// token -> Redeems a code (once) for the ID token, after checking the client, the redirect uri and the PKCE verifier
+func (idp *provider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeTokenError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		writeTokenError(w, http.StatusBadRequest, "unsupported_grant_type", "only authorization_code is supported")
+		return
+	}
+
+	clientId, clientSecret, ok := r.BasicAuth()
+	if ok {
+		clientId, _ = url.QueryUnescape(clientId)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientId, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	if clientId != idp.clientId || subtle.ConstantTimeCompare([]byte(clientSecret), []byte(idp.clientSecret)) != 1 {
+		writeTokenError(w, http.StatusUnauthorized, "invalid_client", "unknown client or wrong secret")
+		return
+	}
+
+	idp.mu.Lock()
+	code, ok := idp.codes[r.PostForm.Get("code")]
+	delete(idp.codes, r.PostForm.Get("code"))
+	idp.mu.Unlock()
+
+	if !ok || time.Now().After(code.expiryAt) || code.clientId != clientId {
+		writeTokenError(w, http.StatusBadRequest, "invalid_grant", "unknown or expired code")
+		return
+	}
+
+	if code.redirectUri != r.PostForm.Get("redirect_uri") {
+		writeTokenError(w, http.StatusBadRequest, "invalid_grant", "redirect_uri does not match")
+		return
+	}
+
+	challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(challenge[:]) != code.codeChallenge {
+		writeTokenError(w, http.StatusBadRequest, "invalid_grant", "code_verifier does not match")
+		return
+	}
+
+	now := time.Now()
+	claims := map[string]any{
+		"iss":                idp.issuer,
+		"sub":                code.subject,
+		"aud":                clientId,
+		"iat":                now.Unix(),
+		"exp":                now.Add(idTokenLifetime).Unix(),
+		"nonce":              code.nonce,
+		"email":              code.email,
+		"email_verified":     code.emailVerified,
+		"preferred_username": code.email,
+	}
+
+	idToken, err := idp.sign(claims)
+	if err != nil {
+		writeTokenError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": rand.Text(),
+		"token_type":   "Bearer",
+		"expires_in":   int(idTokenLifetime.Seconds()),
+		"id_token":     idToken,
+	})
+}
+
+func (idp *provider) checkAuthorizationRequest(params url.Values) error {
+	switch {
+	case params.Get("response_type") != "code":
+		return fmt.Errorf("response_type must be code")
+	case params.Get("client_id") != idp.clientId:
+		return fmt.Errorf("unknown client_id %q", params.Get("client_id"))
+	case params.Get("redirect_uri") == "":
+		return fmt.Errorf("redirect_uri is required")
+	case params.Get("code_challenge") == "" || params.Get("code_challenge_method") != "S256":
+		return fmt.Errorf("PKCE with S256 is required")
+	case !strings.Contains(" "+params.Get("scope")+" ", " openid "):
+		return fmt.Errorf("the openid scope is required")
+	}
+
+	return nil
+}
+
+// sign -> A compact RS256 JWS of the claims
+func (idp *provider) sign(claims map[string]any) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": idp.keyId})
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func writeTokenError(w http.ResponseWriter, status int, code, description string) {
+	writeJSON(w, status, map[string]string{"error": code, "error_description": description})
+}
+
+func writeJSON(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		slog.Error("writing response", "error", err)
+	}
+}
+
+func getEnvOrDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+
+	return defaultValue
+}
@@ -0,0 +1,39 @@
+// reconcilestorage -> Recomputes the used storage of every user and team from their stored files and versions,
+// fixing the usage which drifted (e.g. uploads interrupted before their storage was returned). Run it while the server is stopped
+package main
+
+import (
+	"context"
+	"file_manager/database"
+	"file_manager/database/models"
+	"file_manager/handlers"
+	"file_manager/storage"
+	"fmt"
+)
+
+func main() {
+	db, err := database.New()
+	if err != nil {
+		panic(fmt.Errorf("ERROR initializing database client: %s", err))
+	}
+
+	newModels := models.New(db)
+
+	// files uploaded before the size was stored are measured in the storage
+	storageBackend, err := storage.New()
+	if err != nil {
+		panic(fmt.Errorf("ERROR initializing storage backend: %s", err))
+	}
+
+	handler, err := handlers.New(newModels, storageBackend)
+	if err != nil {
+		panic(fmt.Errorf("ERROR creating the handler: %s", err))
+	}
+
+	fixed, err := handler.ReconcileStorage(context.Background())
+	if err != nil {
+		panic(fmt.Errorf("ERROR reconciling the storage (%d accounts fixed so far): %s", fixed, err))
+	}
+
+	fmt.Printf("reconciled the storage, %d accounts fixed\n", fixed)
+}
@@ -0,0 +1,160 @@
+// rotatekeys -> Re-wraps the data keys of every blob and upload session, and the TOTP secrets of the users with the
+// active master key (the first one of ENCRYPTION_MASTER_KEYS). The stored objects are not rewritten, only the wrapped keys change.
+// Once it finishes without failures, the old master keys can be removed
+package main
+
+import (
+	"file_manager/database"
+	"file_manager/database/models"
+	"file_manager/encryption"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"log/slog"
+)
+
+func main() {
+	db, err := database.New()
+	if err != nil {
+		panic(fmt.Errorf("ERROR initializing database client: %s", err))
+	}
+
+	newModels := models.New(db)
+
+	keyRing, err := encryption.NewKeyRing()
+	if err != nil {
+		panic(fmt.Errorf("ERROR loading the master keys: %s", err))
+	}
+
+	rotated, failed := rotateBlobKeys(newModels, keyRing)
+	fmt.Printf("re-wrapped %d data keys with %q, %d failed\n", rotated, keyRing.ActiveKeyId(), failed)
+
+	rotated, failed = rotateUploadSessionKeys(newModels, keyRing)
+	fmt.Printf("re-wrapped %d upload session keys with %q, %d failed\n", rotated, keyRing.ActiveKeyId(), failed)
+
+	rotated, failed = rotateTotpSecrets(newModels, keyRing)
+	fmt.Printf("re-wrapped %d TOTP secrets with %q, %d failed\n", rotated, keyRing.ActiveKeyId(), failed)
+}
+
+func rotateBlobKeys(newModels *models.Models, keyRing *encryption.KeyRing) (int, int) {
+	// blobs stored before encryption have no key
+	filter := bson.M{
+		"wrapped_key": bson.M{"$exists": true, "$ne": ""},
+		"key_id":      bson.M{"$ne": keyRing.ActiveKeyId()},
+	}
+
+	projection := bson.M{
+		"key_id":      1,
+		"wrapped_key": 1,
+	}
+
+	blobs, err := newModels.Blob.GetAll(filter, projection)
+	if err != nil {
+		panic(fmt.Errorf("ERROR getting the blobs: %s", err))
+	}
+
+	var rotated, failed int
+	for _, blob := range blobs {
+		wrappedKey, err := rewrapKey(keyRing, blob.KeyId, blob.WrappedKey)
+		if err != nil {
+			slog.Error("re-wrapping data key", "blob", blob.Id, "key_id", blob.KeyId, "error", err)
+			failed++
+			continue
+		}
+
+		if err := newModels.Blob.UpdateKey(blob.Id, blob.KeyId, keyRing.ActiveKeyId(), wrappedKey); err != nil {
+			slog.Error("updating data key", "blob", blob.Id, "error", err)
+			failed++
+			continue
+		}
+
+		rotated++
+	}
+
+	return rotated, failed
+}
+
+// rotateUploadSessionKeys -> The unfinished uploads, their chunks are encrypted with the session`s data key
+func rotateUploadSessionKeys(newModels *models.Models, keyRing *encryption.KeyRing) (int, int) {
+	// sessions created before the chunks were encrypted have no key
+	filter := bson.M{
+		"wrapped_key": bson.M{"$exists": true, "$ne": ""},
+		"key_id":      bson.M{"$ne": keyRing.ActiveKeyId()},
+	}
+
+	projection := bson.M{
+		"key_id":      1,
+		"wrapped_key": 1,
+	}
+
+	sessions, err := newModels.UploadSession.GetAll(filter, projection)
+	if err != nil {
+		panic(fmt.Errorf("ERROR getting the upload sessions: %s", err))
+	}
+
+	var rotated, failed int
+	for _, session := range sessions {
+		wrappedKey, err := rewrapKey(keyRing, session.KeyId, session.WrappedKey)
+		if err != nil {
+			slog.Error("re-wrapping upload session key", "session", session.Id.Hex(), "key_id", session.KeyId, "error", err)
+			failed++
+			continue
+		}
+
+		if err := newModels.UploadSession.UpdateKey(session.Id, session.KeyId, keyRing.ActiveKeyId(), wrappedKey); err != nil {
+			slog.Error("updating upload session key", "session", session.Id.Hex(), "error", err)
+			failed++
+			continue
+		}
+
+		rotated++
+	}
+
+	return rotated, failed
+}
+
+// rotateTotpSecrets -> The enrolled secrets too, the ones not enabled yet included
+func rotateTotpSecrets(newModels *models.Models, keyRing *encryption.KeyRing) (int, int) {
+	filter := bson.M{
+		"totp_secret": bson.M{"$exists": true, "$ne": ""},
+		"totp_key_id": bson.M{"$ne": keyRing.ActiveKeyId()},
+	}
+
+	projection := bson.M{
+		"totp_secret": 1,
+		"totp_key_id": 1,
+	}
+
+	users, err := newModels.User.Find(filter, projection)
+	if err != nil {
+		panic(fmt.Errorf("ERROR getting the users: %s", err))
+	}
+
+	var rotated, failed int
+	for _, user := range users {
+		wrappedSecret, err := rewrapKey(keyRing, user.TotpKeyId, user.TotpSecret)
+		if err != nil {
+			slog.Error("re-wrapping TOTP secret", "user", user.Id.Hex(), "key_id", user.TotpKeyId, "error", err)
+			failed++
+			continue
+		}
+
+		if err := newModels.User.UpdateTotpKey(user.Id, user.TotpKeyId, user.TotpSecret, keyRing.ActiveKeyId(), wrappedSecret); err != nil {
+			slog.Error("updating TOTP secret", "user", user.Id.Hex(), "error", err)
+			failed++
+			continue
+		}
+
+		rotated++
+	}
+
+	return rotated, failed
+}
+
+func rewrapKey(keyRing *encryption.KeyRing, keyId, wrappedKey string) (string, error) {
+	key, err := keyRing.UnwrapKey(keyId, wrappedKey)
+	if err != nil {
+		return "", err
+	}
+
+	return keyRing.WrapKey(key)
+}
@@ -0,0 +1,128 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+type ApiTokenModel struct {
+	db *mongo.Database
+}
+
+// ApiToken -> A personal access token for the scripts, limited to its scopes. Only the hash of the token is stored
+type ApiToken struct {
+	Id         primitive.ObjectID `json:"id" bson:"_id"`
+	UserId     primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Name       string             `json:"name" bson:"name"`
+	Scopes     []string           `json:"scopes" bson:"scopes"`
+	TokenHash  string             `json:"-" bson:"token_hash"`
+	ExpireAt   time.Time          `json:"expire_at" bson:"expire_at"`
+	LastUsedAt time.Time          `json:"last_used_at,omitzero" bson:"last_used_at,omitempty"`
+	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
+}
+
+const ApiTokensCollectionName = "api_tokens"
+
+// Create -> The id is generated by the caller, the token carries it
+func (apiToken *ApiTokenModel) Create(id, userId primitive.ObjectID, name string, scopes []string, tokenHash string,
+	expireAt time.Time) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	newToken := &ApiToken{
+		Id:        id,
+		UserId:    userId,
+		Name:      name,
+		Scopes:    scopes,
+		TokenHash: tokenHash,
+		ExpireAt:  expireAt,
+		CreatedAt: time.Now(),
+	}
+
+	_, err := apiToken.db.Collection(ApiTokensCollectionName).InsertOne(ctx, newToken)
+	return err
+}
+
+// Get -> Returns One
+func (apiToken *ApiTokenModel) Get(filter, projection bson.M) (*ApiToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	findOptions := options.FindOne()
+	findOptions.SetProjection(projection)
+
+	var tokenInstance ApiToken
+	if err := apiToken.db.Collection(ApiTokensCollectionName).FindOne(ctx, filter, findOptions).Decode(&tokenInstance); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, errors.New("api token does not exist")
+		}
+
+		return nil, err
+	}
+
+	return &tokenInstance, nil
+}
+
+// GetAll -> Returns List, newest first
+func (apiToken *ApiTokenModel) GetAll(filter, projection bson.M) ([]ApiToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	findOptions := options.Find()
+	findOptions.SetProjection(projection)
+	findOptions.SetSort(bson.M{"created_at": -1})
+
+	cursor, err := apiToken.db.Collection(ApiTokensCollectionName).Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := []ApiToken{}
+	if err := cursor.All(ctx, &tokens); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// Touch -> Sets the last use, at most once per interval so that busy scripts do not write on every request
+func (apiToken *ApiTokenModel) Touch(id primitive.ObjectID, interval time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+
+	filter := bson.M{
+		"_id": id,
+		"$or": bson.A{
+			bson.M{"last_used_at": bson.M{"$exists": false}},
+			bson.M{"last_used_at": bson.M{"$lt": now.Add(-interval)}},
+		},
+	}
+
+	update := bson.M{
+		"$set": bson.M{"last_used_at": now},
+	}
+
+	_, err := apiToken.db.Collection(ApiTokensCollectionName).UpdateOne(ctx, filter, update)
+	return err
+}
+
+// DeleteAll -> Returns the amount of deleted tokens
+func (apiToken *ApiTokenModel) DeleteAll(filter bson.M) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := apiToken.db.Collection(ApiTokensCollectionName).DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.DeletedCount, nil
+}
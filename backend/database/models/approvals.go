@@ -17,6 +17,7 @@ type ApprovalModel struct {
 type Approval struct {
 	Id         primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
 	FileId     primitive.ObjectID `json:"file_id" bson:"file_id"`
+	FolderId   primitive.ObjectID `json:"folder_id,omitzero" bson:"folder_id,omitempty"` // set instead of the file id (and name) for the folder links
 	FileName   string             `json:"file_name" bson:"file_name"`
 	OwnerId    primitive.ObjectID `json:"owner_id" bson:"owner_id"`   // the file owner id
 	SenderId   primitive.ObjectID `json:"sender_id" bson:"sender_id"` // the Requester id (user-id)
@@ -26,12 +27,13 @@ type Approval struct {
 	ReviewedAt *time.Time         `json:"reviewed_at,omitempty" bson:"reviewed_at,omitempty"`
 }
 
-func (approval *ApprovalModel) Create(fileId, ownerId, senderId primitive.ObjectID, fileName, reason string) (primitive.ObjectID, error) {
+func (approval *ApprovalModel) Create(fileId, folderId, ownerId, senderId primitive.ObjectID, fileName, reason string) (primitive.ObjectID, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	var newApproval = &Approval{
 		FileId:    fileId,
+		FolderId:  folderId,
 		OwnerId:   ownerId,
 		SenderId:  senderId,
 		Status:    "pending", // default
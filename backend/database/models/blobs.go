@@ -0,0 +1,198 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+type BlobModel struct {
+	db *mongo.Database
+}
+
+// Blob -> Content addressed file bytes. Identical uploads share one stored object,
+// RefCount is the amount of files (documents) which point to it.
+// The stored object is encrypted with its own data key, which is kept wrapped by the master key KeyId
+type Blob struct {
+	Id         string    `json:"id" bson:"_id"` // sha256 of the content (hex)
+	Address    string    `json:"address" bson:"address"`
+	Size       int64     `json:"size" bson:"size"` // plain text size
+	RefCount   int64     `json:"ref_count" bson:"ref_count"`
+	KeyId      string    `json:"-" bson:"key_id"`
+	WrappedKey string    `json:"-" bson:"wrapped_key"`
+	CreatedAt  time.Time `json:"created_at" bson:"created_at"`
+}
+
+const BlobsCollectionName = "blobs"
+
+// AddReference -> Creates the blob with the given address if it does not exist yet, returns the blob after the update.
+// If the returned address differs from the given one, the content is stored already
+func (blob *BlobModel) AddReference(hash, address string, size int64, keyId, wrappedKey string) (*Blob, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"_id": hash,
+	}
+
+	update := bson.M{
+		"$inc": bson.M{"ref_count": 1},
+		"$setOnInsert": bson.M{
+			"address":     address,
+			"size":        size,
+			"key_id":      keyId,
+			"wrapped_key": wrappedKey,
+			"created_at":  time.Now(),
+		},
+	}
+
+	updateOptions := options.FindOneAndUpdate()
+	updateOptions.SetUpsert(true)
+	updateOptions.SetReturnDocument(options.After)
+
+	var blobInstance Blob
+	if err := blob.db.Collection(BlobsCollectionName).FindOneAndUpdate(ctx, filter, update, updateOptions).Decode(&blobInstance); err != nil {
+		return nil, err
+	}
+
+	return &blobInstance, nil
+}
+
+func (blob *BlobModel) Get(filter, projection bson.M) (*Blob, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	findOptions := options.FindOne()
+	findOptions.SetProjection(projection)
+
+	var blobInstance Blob
+	if err := blob.db.Collection(BlobsCollectionName).FindOne(ctx, filter, findOptions).Decode(&blobInstance); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, errors.New("blob with this hash does not exist")
+		}
+
+		return nil, err
+	}
+
+	return &blobInstance, nil
+}
+
+// GetAll -> Returns List
+func (blob *BlobModel) GetAll(filter, projection bson.M) ([]Blob, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	findOptions := options.Find()
+	findOptions.SetProjection(projection)
+
+	cursor, err := blob.db.Collection(BlobsCollectionName).Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	var blobs []Blob
+	if err := cursor.All(ctx, &blobs); err != nil {
+		return nil, err
+	}
+
+	return blobs, nil
+}
+
+// UpdateKey -> Replaces the wrapped data key, only if it is still wrapped by oldKeyId
+func (blob *BlobModel) UpdateKey(hash, oldKeyId, keyId, wrappedKey string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"_id":    hash,
+		"key_id": oldKeyId,
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"key_id":      keyId,
+			"wrapped_key": wrappedKey,
+		},
+	}
+
+	_, err := blob.db.Collection(BlobsCollectionName).UpdateOne(ctx, filter, update)
+	return err
+}
+
+// AddExistingReference -> Adds a reference to a blob which is stored already, returns the blob after the update
+func (blob *BlobModel) AddExistingReference(hash string) (*Blob, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"_id":       hash,
+		"ref_count": bson.M{"$gt": 0}, // an unreferenced blob is being deleted
+	}
+
+	update := bson.M{
+		"$inc": bson.M{"ref_count": 1},
+	}
+
+	updateOptions := options.FindOneAndUpdate()
+	updateOptions.SetReturnDocument(options.After)
+
+	var blobInstance Blob
+	if err := blob.db.Collection(BlobsCollectionName).FindOneAndUpdate(ctx, filter, update, updateOptions).Decode(&blobInstance); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, errors.New("blob with this hash does not exist")
+		}
+
+		return nil, err
+	}
+
+	return &blobInstance, nil
+}
+
+// RemoveReference -> Returns the blob after the update
+func (blob *BlobModel) RemoveReference(hash string) (*Blob, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"_id": hash,
+	}
+
+	update := bson.M{
+		"$inc": bson.M{"ref_count": -1},
+	}
+
+	updateOptions := options.FindOneAndUpdate()
+	updateOptions.SetReturnDocument(options.After)
+
+	var blobInstance Blob
+	if err := blob.db.Collection(BlobsCollectionName).FindOneAndUpdate(ctx, filter, update, updateOptions).Decode(&blobInstance); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, errors.New("blob with this hash does not exist")
+		}
+
+		return nil, err
+	}
+
+	return &blobInstance, nil
+}
+
+// DeleteUnreferenced -> Deletes the blob only if nothing references it (a new reference may be added in the meantime)
+func (blob *BlobModel) DeleteUnreferenced(hash string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"_id":       hash,
+		"ref_count": bson.M{"$lte": 0},
+	}
+
+	result, err := blob.db.Collection(BlobsCollectionName).DeleteOne(ctx, filter)
+	if err != nil {
+		return false, err
+	}
+
+	return result.DeletedCount == 1, nil
+}
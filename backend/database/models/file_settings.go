@@ -15,23 +15,38 @@ type FileSettingModel struct {
 }
 
 type FileSettings struct {
-	Id                    primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	UserId                primitive.ObjectID `json:"user_id" bson:"user_id"`
-	FileId                primitive.ObjectID `json:"file_id" bson:"file_id"` // 1 to 1 relationship
-	ShortUrl              string             `json:"short_url" bson:"short_url"`
-	Salt                  string             `json:"salt" bson:"salt"`
-	HashedPassword        string             `json:"hashed_password" bson:"hashed_password"`
-	MaxDownloads          int64              `json:"max_downloads" bson:"max_downloads"`
-	CurrentDownloadAmount int64              `json:"current_download_amount" bson:"current_download_amount"`
-	ViewOnly              bool               `json:"view_only" bson:"view_only"`
-	Approvable            bool               `json:"approvable" bson:"approvable"`
-	ExpireAt              time.Time          `json:"expiration_at" bson:"expiration_at"`
-	CreatedAt             time.Time          `json:"created_at" bson:"created_at"`
+	Id                    primitive.ObjectID    `json:"id" bson:"_id,omitempty"`
+	UserId                primitive.ObjectID    `json:"user_id" bson:"user_id"`
+	FileId                primitive.ObjectID    `json:"file_id" bson:"file_id"` // a file can have many share links
+	ShortUrl              string                `json:"short_url" bson:"short_url"`
+	Label                 string                `json:"label" bson:"label"` // tells the links of a file apart, e.g. by audience
+	Salt                  string                `json:"-" bson:"salt"`
+	HashedPassword        string                `json:"-" bson:"hashed_password"`
+	HasPassword           bool                  `json:"has_password" bson:"has_password,omitempty"` // only set by projections, never stored
+	MaxDownloads          int64                 `json:"max_downloads" bson:"max_downloads"`
+	CurrentDownloadAmount int64                 `json:"current_download_amount" bson:"current_download_amount"`
+	DownloadedBytes       int64                 `json:"downloaded_bytes" bson:"downloaded_bytes"`
+	DownloadReservations  []DownloadReservation `json:"-" bson:"download_reservations,omitempty"` // bytes of the downloads in progress
+	ViewOnly              bool                  `json:"view_only" bson:"view_only"`
+	Approvable            bool                  `json:"approvable" bson:"approvable"`
+	ExpireAt              time.Time             `json:"expiration_at" bson:"expiration_at"`
+	CreatedAt             time.Time             `json:"created_at" bson:"created_at"`
 }
 
+// DownloadReservation -> Bytes reserved by a download in progress. A reservation left by a crashed server stops
+// counting once it expires
+type DownloadReservation struct {
+	Id       primitive.ObjectID `bson:"id"`
+	Bytes    int64              `bson:"bytes"`
+	ExpireAt time.Time          `bson:"expire_at"`
+}
+
+// ErrDownloadLimitReached -> The link`s downloads (served and in progress) leave no room for the requested bytes
+var ErrDownloadLimitReached = errors.New("you have exceed you maximum downloads amount")
+
 const FileSettingsCollectionName = "file_settings"
 
-func (file *FileSettingModel) Create(fileId, userId primitive.ObjectID, shortUrl, salt, hashedPassword string, maxDownloads int64,
+func (file *FileSettingModel) Create(fileId, userId primitive.ObjectID, shortUrl, label, salt, hashedPassword string, maxDownloads int64,
 	viewOnly, approvable bool, expireAt time.Time) error {
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -41,6 +56,7 @@ func (file *FileSettingModel) Create(fileId, userId primitive.ObjectID, shortUrl
 		FileId:         fileId,
 		UserId:         userId,
 		ShortUrl:       shortUrl,
+		Label:          label,
 		Salt:           salt,
 		HashedPassword: hashedPassword,
 		MaxDownloads:   maxDownloads,
@@ -73,16 +89,18 @@ func (file *FileSettingModel) Get(filter, projection bson.M) (*FileSettings, err
 	return &fileInstance, nil
 }
 
-func (file *FileSettingModel) GetAll(filter bson.M) ([]FileSettings, error) {
+func (file *FileSettingModel) GetAll(filter, projection bson.M) ([]FileSettings, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	findOptions := options.Find()
+	findOptions.SetProjection(projection)
+	findOptions.SetSort(bson.M{"created_at": 1})
+
 	var settings []FileSettings
-	cursor, err := file.db.Collection(FileSettingsCollectionName).Find(ctx, filter)
+	cursor, err := file.db.Collection(FileSettingsCollectionName).Find(ctx, filter, findOptions)
 	if err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
-			return nil, nil
-		}
+		return nil, err
 	}
 
 	if err := cursor.All(ctx, &settings); err != nil {
@@ -103,6 +121,19 @@ func (file *FileSettingModel) Delete(filter bson.M) error {
 	return nil
 }
 
+// DeleteAll -> Returns the amount of deleted settings
+func (file *FileSettingModel) DeleteAll(filter bson.M) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := file.db.Collection(FileSettingsCollectionName).DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.DeletedCount, nil
+}
+
 func (file *FileSettingModel) Update(id primitive.ObjectID, updates bson.M) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -126,3 +157,105 @@ func (file *FileSettingModel) Update(id primitive.ObjectID, updates bson.M) erro
 
 	return nil
 }
+
+// ReserveDownload -> Reserves bytes of the link`s allowance (max_downloads * fileSize bytes) before they are served,
+// in a single findOneAndUpdate, so parallel downloads can`t go past the limit together. The reservation must be
+// released with FinishDownload
+func (file *FileSettingModel) ReserveDownload(id, reservationId primitive.ObjectID, bytes, fileSize int64, expireAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+
+	activeReservations := bson.M{
+		"$filter": bson.M{
+			"input": bson.M{"$ifNull": bson.A{"$download_reservations", bson.A{}}},
+			"cond":  bson.M{"$gt": bson.A{"$$this.expire_at", now}},
+		},
+	}
+
+	// links created before the bytes were counted have only current_download_amount. Otherwise it is rounded up
+	// (see FinishDownload), counting it would turn an aborted download into a whole one and refuse its resume
+	consumedBytes := bson.M{
+		"$ifNull": bson.A{
+			"$downloaded_bytes",
+			bson.M{"$multiply": bson.A{bson.M{"$ifNull": bson.A{"$current_download_amount", 0}}, fileSize}},
+		},
+	}
+
+	filter := bson.M{
+		"_id": id,
+		"$expr": bson.M{"$lte": bson.A{
+			bson.M{"$add": bson.A{consumedBytes, bson.M{"$sum": bson.M{"$map": bson.M{
+				"input": activeReservations,
+				"in":    "$$this.bytes",
+			}}}, bytes}},
+			bson.M{"$multiply": bson.A{"$max_downloads", max(fileSize, 1)}},
+		}},
+	}
+
+	reservation := DownloadReservation{
+		Id:       reservationId,
+		Bytes:    bytes,
+		ExpireAt: expireAt,
+	}
+
+	// the expired reservations are dropped on the way
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"download_reservations": bson.M{
+			"$concatArrays": bson.A{activeReservations, bson.A{reservation}},
+		}}}},
+	}
+
+	findOptions := options.FindOneAndUpdate()
+	findOptions.SetProjection(bson.M{"_id": 1})
+
+	err := file.db.Collection(FileSettingsCollectionName).FindOneAndUpdate(ctx, filter, update, findOptions).Err()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrDownloadLimitReached
+	}
+
+	return err
+}
+
+// FinishDownload -> Releases the reservation and counts the bytes which were actually served.
+// current_download_amount is the amount of whole downloads the served bytes amount to (rounded up)
+func (file *FileSettingModel) FinishDownload(id, reservationId primitive.ObjectID, servedBytes, fileSize int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	downloadedBytes := bson.M{
+		"$add": bson.A{bson.M{"$ifNull": bson.A{"$downloaded_bytes", 0}}, servedBytes},
+	}
+
+	reservations := bson.M{
+		"$filter": bson.M{
+			"input": bson.M{"$ifNull": bson.A{"$download_reservations", bson.A{}}},
+			"cond":  bson.M{"$ne": bson.A{"$$this.id", reservationId}},
+		},
+	}
+
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"downloaded_bytes":      downloadedBytes,
+			"download_reservations": reservations,
+		}}},
+		{{Key: "$set", Value: bson.M{"current_download_amount": bson.M{
+			"$max": bson.A{
+				bson.M{"$ifNull": bson.A{"$current_download_amount", 0}},
+				bson.M{"$ceil": bson.M{"$divide": bson.A{"$downloaded_bytes", max(fileSize, 1)}}},
+			},
+		}}}},
+	}
+
+	result, err := file.db.Collection(FileSettingsCollectionName).UpdateByID(ctx, id, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return errors.New("setting with this id does not exist")
+	}
+
+	return nil
+}
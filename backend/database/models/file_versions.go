@@ -0,0 +1,112 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+type FileVersionModel struct {
+	db *mongo.Database
+}
+
+// FileVersion -> A previous content of a file. The current content stays on the file itself,
+// each version holds its own blob reference
+type FileVersion struct {
+	Id          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	FileId      primitive.ObjectID `json:"file_id" bson:"file_id"`
+	Number      int64              `json:"number" bson:"number"`
+	CreatedAt   time.Time          `json:"created_at,omitzero" bson:"created_at"` // when a newer version replaced it
+	FileContent `bson:",inline"`
+}
+
+const FileVersionsCollectionName = "file_versions"
+
+func (version *FileVersionModel) Create(fileId primitive.ObjectID, number int64, content FileContent) (primitive.ObjectID, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	newVersion := &FileVersion{
+		FileId:      fileId,
+		Number:      number,
+		CreatedAt:   time.Now(),
+		FileContent: content,
+	}
+
+	result, err := version.db.Collection(FileVersionsCollectionName).InsertOne(ctx, newVersion)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	return result.InsertedID.(primitive.ObjectID), nil
+}
+
+func (version *FileVersionModel) Get(filter, projection bson.M) (*FileVersion, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	findOptions := options.FindOne()
+	findOptions.SetProjection(projection)
+
+	var versionInstance FileVersion
+	if err := version.db.Collection(FileVersionsCollectionName).FindOne(ctx, filter, findOptions).Decode(&versionInstance); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, errors.New("file version does not exist")
+		}
+
+		return nil, err
+	}
+
+	return &versionInstance, nil
+}
+
+// GetAll -> Returns List, newest version first
+func (version *FileVersionModel) GetAll(filter, projection bson.M) ([]FileVersion, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	findOptions := options.Find()
+	findOptions.SetProjection(projection)
+	findOptions.SetSort(bson.M{"number": -1})
+
+	cursor, err := version.db.Collection(FileVersionsCollectionName).Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []FileVersion
+	if err := cursor.All(ctx, &versions); err != nil {
+		return nil, err
+	}
+
+	return versions, nil
+}
+
+// GetTotalSize -> Returns the summed size of the matching versions
+func (version *FileVersionModel) GetTotalSize(filter bson.M) (int64, error) {
+	return getTotalSize(version.db.Collection(FileVersionsCollectionName), filter)
+}
+
+func (version *FileVersionModel) Delete(id primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"_id": id,
+	}
+
+	result, err := version.db.Collection(FileVersionsCollectionName).DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return errors.New("file version does not exist")
+	}
+
+	return nil
+}
@@ -15,30 +15,51 @@ type FileModel struct {
 }
 
 type File struct {
-	Id        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	OwnerId   primitive.ObjectID `json:"owner_id" bson:"owner_id"`
-	TeamId    primitive.ObjectID `json:"team_id" bson:"team_id"`
-	FolderId  primitive.ObjectID `json:"folder_id" bson:"folder_id"`
-	Name      string             `json:"name" bson:"name"`
-	Address   string             `json:"address" bson:"address"`
-	ExpireAt  time.Time          `json:"expire_at" bson:"expire_at"`
-	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	Id              primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	OwnerId         primitive.ObjectID `json:"owner_id" bson:"owner_id"`
+	TeamId          primitive.ObjectID `json:"team_id" bson:"team_id"`
+	FolderId        primitive.ObjectID `json:"folder_id" bson:"folder_id"`
+	Name            string             `json:"name" bson:"name"`
+	Version         int64              `json:"version" bson:"version"` // number of the current content, 0 for files uploaded before versioning
+	ExpireAt        time.Time          `json:"expire_at" bson:"expire_at"`
+	CreatedAt       time.Time          `json:"created_at" bson:"created_at"`
+	DeletedAt       time.Time          `json:"deleted_at,omitzero" bson:"deleted_at,omitempty"` // set while the file is in the trash
+	PurgeAt         time.Time          `json:"purge_at,omitzero" bson:"purge_at,omitempty"`
+	ContentReleased bool               `json:"-" bson:"content_released,omitempty"` // set by the removal, its retries must not release the content again
+	FileContent     `bson:",inline"`
 }
 
-func (file *FileModel) Create(ownerId, teamId, folderId primitive.ObjectID, fileName, address string,
+// FileContent -> The stored bytes of a file (one version of it) and what is known about them at upload time
+type FileContent struct {
+	Address      string             `json:"-" bson:"address"`         // the storage key, never sent to the clients
+	Checksum     string             `json:"checksum" bson:"checksum"` // sha256 (hex), the blob id
+	Size         int64              `json:"size" bson:"size"`
+	ContentType  string             `json:"content_type" bson:"content_type"` // sniffed from the content
+	OriginalName string             `json:"original_name" bson:"original_name"`
+	Extension    string             `json:"extension" bson:"extension"`
+	UploaderId   primitive.ObjectID `json:"uploader_id" bson:"uploader_id"`
+	UploadedAt   time.Time          `json:"uploaded_at" bson:"uploaded_at"`
+}
+
+func (file *FileModel) Create(ownerId, teamId, folderId primitive.ObjectID, fileName string, content FileContent,
 	expireAt time.Time) (primitive.ObjectID, error) {
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	// the first version is uploaded by the owner
+	content.UploaderId = ownerId
+	content.UploadedAt = time.Now()
+
 	newFile := &File{
-		OwnerId:   ownerId,
-		TeamId:    teamId,
-		FolderId:  folderId,
-		Name:      fileName,
-		Address:   address,
-		ExpireAt:  expireAt,
-		CreatedAt: time.Now(),
+		OwnerId:     ownerId,
+		TeamId:      teamId,
+		FolderId:    folderId,
+		Name:        fileName,
+		Version:     1,
+		FileContent: content,
+		ExpireAt:    expireAt,
+		CreatedAt:   time.Now(),
 	}
 
 	id, err := file.db.Collection("files").InsertOne(ctx, newFile)
@@ -77,6 +98,32 @@ func (file *FileModel) GetAll(filter bson.M, page, pageSize int64) ([]File, erro
 	return files, nil
 }
 
+// Find -> Returns every matching file (not paginated)
+func (file *FileModel) Find(filter, projection bson.M) ([]File, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	findOptions := options.Find()
+	findOptions.SetProjection(projection)
+
+	cursor, err := file.db.Collection("files").Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []File
+	if err := cursor.All(ctx, &files); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// GetTotalSize -> Returns the summed size of the matching files
+func (file *FileModel) GetTotalSize(filter bson.M) (int64, error) {
+	return getTotalSize(file.db.Collection("files"), filter)
+}
+
 func (file *FileModel) Delete(id primitive.ObjectID) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -97,6 +144,178 @@ func (file *FileModel) Delete(id primitive.ObjectID) error {
 	return nil
 }
 
+// MarkContentReleased -> Records that the content of the file is being released, false when it was released already
+func (file *FileModel) MarkContentReleased(id primitive.ObjectID) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"_id":              id,
+		"content_released": bson.M{"$ne": true},
+	}
+
+	update := bson.M{
+		"$set": bson.M{"content_released": true},
+	}
+
+	result, err := file.db.Collection("files").UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return result.MatchedCount == 1, nil
+}
+
+// Trash -> Moves the matching files to the trash, returns the amount of trashed files
+func (file *FileModel) Trash(filter bson.M, deletedAt, purgeAt time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.M{
+		"$set": bson.M{
+			"deleted_at": deletedAt,
+			"purge_at":   purgeAt,
+		},
+	}
+
+	result, err := file.db.Collection("files").UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.ModifiedCount, nil
+}
+
+// Restore -> Takes the matching files out of the trash, returns the amount of restored files
+func (file *FileModel) Restore(filter, updates bson.M) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.M{
+		"$unset": bson.M{
+			"deleted_at": "",
+			"purge_at":   "",
+		},
+	}
+
+	if len(updates) != 0 {
+		update["$set"] = updates
+	}
+
+	result, err := file.db.Collection("files").UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.ModifiedCount, nil
+}
+
+// GetStorageUsage -> Returns the summed size of the matching files and of all their previous versions
+func (file *FileModel) GetStorageUsage(filter bson.M) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	pipeline := bson.A{
+		bson.M{"$match": filter},
+		bson.M{"$lookup": bson.M{
+			"from":         FileVersionsCollectionName,
+			"localField":   "_id",
+			"foreignField": "file_id",
+			"as":           "versions",
+		}},
+		bson.M{"$group": bson.M{
+			"_id": nil,
+			"total": bson.M{"$sum": bson.M{"$add": bson.A{
+				bson.M{"$ifNull": bson.A{"$size", 0}},
+				bson.M{"$sum": "$versions.size"},
+			}}},
+		}},
+	}
+
+	cursor, err := file.db.Collection("files").Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+
+	var results []struct {
+		Total int64 `bson:"total"`
+	}
+
+	if err := cursor.All(ctx, &results); err != nil {
+		return 0, err
+	}
+
+	if len(results) == 0 {
+		return 0, nil
+	}
+
+	return results[0].Total, nil
+}
+
+// Move -> Sets updates (folder_id, team_id) on the matching files. A non zero expireAt caps their expiration,
+// so moving a file into another space never extends its lifetime
+func (file *FileModel) Move(filter, updates bson.M, expireAt time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.M{
+		"$set": updates,
+	}
+
+	if !expireAt.IsZero() {
+		update["$min"] = bson.M{"expire_at": expireAt}
+	}
+
+	result, err := file.db.Collection("files").UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.MatchedCount, nil
+}
+
+// ReplaceContent -> Sets the new version only if the file is still at version (nobody uploaded another version in the meantime)
+func (file *FileModel) ReplaceContent(id primitive.ObjectID, version, newVersion int64, content FileContent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"_id":              id,
+		"version":          version,
+		"content_released": bson.M{"$ne": true}, // the file is being removed
+	}
+
+	// files uploaded before versioning have no version field
+	if version == 0 {
+		filter["version"] = bson.M{"$in": bson.A{0, nil}}
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"version":       newVersion,
+			"address":       content.Address,
+			"checksum":      content.Checksum,
+			"size":          content.Size,
+			"content_type":  content.ContentType,
+			"original_name": content.OriginalName,
+			"extension":     content.Extension,
+			"uploader_id":   content.UploaderId,
+			"uploaded_at":   content.UploadedAt,
+		},
+	}
+
+	result, err := file.db.Collection("files").UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return errors.New("the file has been changed in the meantime, try again")
+	}
+
+	return nil
+}
+
 func (file *FileModel) Update(id primitive.ObjectID, updates bson.M) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -140,3 +359,33 @@ func (file *FileModel) Get(filter, projection bson.M) (*File, error) {
 
 	return &fileInstance, nil
 }
+
+// getTotalSize -> Sums the size field of the matching documents
+func getTotalSize(collection *mongo.Collection, filter bson.M) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pipeline := bson.A{
+		bson.M{"$match": filter},
+		bson.M{"$group": bson.M{"_id": nil, "total": bson.M{"$sum": "$size"}}},
+	}
+
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+
+	var results []struct {
+		Total int64 `bson:"total"`
+	}
+
+	if err := cursor.All(ctx, &results); err != nil {
+		return 0, err
+	}
+
+	if len(results) == 0 {
+		return 0, nil
+	}
+
+	return results[0].Total, nil
+}
@@ -0,0 +1,229 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+type FolderSettingModel struct {
+	db *mongo.Database
+}
+
+// FolderSettings -> A share link of a folder, it exposes the folder`s contents with its subfolders.
+// The files behind a link have different sizes, so the downloads are counted in files: downloading a file
+// (or all of its ranges) counts as one download, a part of it as the matching fraction
+type FolderSettings struct {
+	Id                    primitive.ObjectID          `json:"id" bson:"_id,omitempty"`
+	UserId                primitive.ObjectID          `json:"user_id" bson:"user_id"`
+	FolderId              primitive.ObjectID          `json:"folder_id" bson:"folder_id"` // a folder can have many share links
+	ShortUrl              string                      `json:"short_url" bson:"short_url"`
+	Label                 string                      `json:"label" bson:"label"`
+	Salt                  string                      `json:"-" bson:"salt"`
+	HashedPassword        string                      `json:"-" bson:"hashed_password"`
+	HasPassword           bool                        `json:"has_password" bson:"has_password,omitempty"` // only set by projections, never stored
+	MaxDownloads          int64                       `json:"max_downloads" bson:"max_downloads"`
+	CurrentDownloadAmount int64                       `json:"current_download_amount" bson:"current_download_amount"`
+	DownloadedAmount      float64                     `json:"downloaded_amount" bson:"downloaded_amount"` // the served fractions of files
+	DownloadReservations  []FolderDownloadReservation `json:"-" bson:"download_reservations,omitempty"`
+	ViewOnly              bool                        `json:"view_only" bson:"view_only"`
+	Approvable            bool                        `json:"approvable" bson:"approvable"`
+	ExpireAt              time.Time                   `json:"expiration_at" bson:"expiration_at"`
+	CreatedAt             time.Time                   `json:"created_at" bson:"created_at"`
+}
+
+// FolderDownloadReservation -> The fraction of a file reserved by a download in progress
+type FolderDownloadReservation struct {
+	Id       primitive.ObjectID `bson:"id"`
+	Amount   float64            `bson:"amount"`
+	ExpireAt time.Time          `bson:"expire_at"`
+}
+
+const FolderSettingsCollectionName = "folder_settings"
+
+func (folder *FolderSettingModel) Create(folderId, userId primitive.ObjectID, shortUrl, label, salt, hashedPassword string, maxDownloads int64,
+	viewOnly, approvable bool, expireAt time.Time) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	newFolder := &FolderSettings{
+		FolderId:       folderId,
+		UserId:         userId,
+		ShortUrl:       shortUrl,
+		Label:          label,
+		Salt:           salt,
+		HashedPassword: hashedPassword,
+		MaxDownloads:   maxDownloads,
+		ViewOnly:       viewOnly,
+		Approvable:     approvable,
+		ExpireAt:       expireAt,
+		CreatedAt:      time.Now(),
+	}
+
+	if _, err := folder.db.Collection(FolderSettingsCollectionName).InsertOne(ctx, newFolder); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Get -> Returns one
+func (folder *FolderSettingModel) Get(filter, projection bson.M) (*FolderSettings, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	findOptions := options.FindOne()
+	findOptions.SetProjection(projection)
+
+	var folderInstance FolderSettings
+	if err := folder.db.Collection(FolderSettingsCollectionName).FindOne(ctx, filter, findOptions).Decode(&folderInstance); err != nil {
+		return nil, err
+	}
+
+	return &folderInstance, nil
+}
+
+func (folder *FolderSettingModel) GetAll(filter, projection bson.M) ([]FolderSettings, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	findOptions := options.Find()
+	findOptions.SetProjection(projection)
+	findOptions.SetSort(bson.M{"created_at": 1})
+
+	var settings []FolderSettings
+	cursor, err := folder.db.Collection(FolderSettingsCollectionName).Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cursor.All(ctx, &settings); err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
+
+func (folder *FolderSettingModel) Update(id primitive.ObjectID, updates bson.M) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.M{
+		"$set": updates,
+	}
+
+	result, err := folder.db.Collection(FolderSettingsCollectionName).UpdateByID(ctx, id, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return errors.New("setting with this id does not exist")
+	}
+
+	if result.ModifiedCount == 0 {
+		return errors.New("no change detected")
+	}
+
+	return nil
+}
+
+// DeleteAll -> Returns the amount of deleted settings
+func (folder *FolderSettingModel) DeleteAll(filter bson.M) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := folder.db.Collection(FolderSettingsCollectionName).DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.DeletedCount, nil
+}
+
+// ReserveDownload -> Reserves a fraction (requested bytes / file size) of the link`s max_downloads before the bytes
+// are served, in a single findOneAndUpdate like the file links. The reservation must be released with FinishDownload
+func (folder *FolderSettingModel) ReserveDownload(id, reservationId primitive.ObjectID, amount float64, expireAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	activeReservations := bson.M{
+		"$filter": bson.M{
+			"input": bson.M{"$ifNull": bson.A{"$download_reservations", bson.A{}}},
+			"cond":  bson.M{"$gt": bson.A{"$$this.expire_at", time.Now()}},
+		},
+	}
+
+	filter := bson.M{
+		"_id": id,
+		"$expr": bson.M{"$lte": bson.A{
+			bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$downloaded_amount", 0}}, bson.M{"$sum": bson.M{"$map": bson.M{
+				"input": activeReservations,
+				"in":    "$$this.amount",
+			}}}, amount}},
+			"$max_downloads",
+		}},
+	}
+
+	reservation := FolderDownloadReservation{
+		Id:       reservationId,
+		Amount:   amount,
+		ExpireAt: expireAt,
+	}
+
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"download_reservations": bson.M{
+			"$concatArrays": bson.A{activeReservations, bson.A{reservation}},
+		}}}},
+	}
+
+	findOptions := options.FindOneAndUpdate()
+	findOptions.SetProjection(bson.M{"_id": 1})
+
+	err := folder.db.Collection(FolderSettingsCollectionName).FindOneAndUpdate(ctx, filter, update, findOptions).Err()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrDownloadLimitReached
+	}
+
+	return err
+}
+
+// FinishDownload -> Releases the reservation and counts the served fraction of the file.
+// current_download_amount is the amount of whole downloads the served fractions amount to (rounded up)
+func (folder *FolderSettingModel) FinishDownload(id, reservationId primitive.ObjectID, servedAmount float64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	reservations := bson.M{
+		"$filter": bson.M{
+			"input": bson.M{"$ifNull": bson.A{"$download_reservations", bson.A{}}},
+			"cond":  bson.M{"$ne": bson.A{"$$this.id", reservationId}},
+		},
+	}
+
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"downloaded_amount":     bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$downloaded_amount", 0}}, servedAmount}},
+			"download_reservations": reservations,
+		}}},
+		{{Key: "$set", Value: bson.M{"current_download_amount": bson.M{
+			"$toLong": bson.M{"$ceil": "$downloaded_amount"},
+		}}}},
+	}
+
+	result, err := folder.db.Collection(FolderSettingsCollectionName).UpdateByID(ctx, id, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return errors.New("setting with this id does not exist")
+	}
+
+	return nil
+}
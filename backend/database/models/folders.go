@@ -18,18 +18,22 @@ type Folder struct {
 	Id        primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
 	OwnerId   primitive.ObjectID `json:"owner_id" bson:"owner_id"`
 	TeamId    primitive.ObjectID `json:"team_id" bson:"team_id"`
+	ParentId  primitive.ObjectID `json:"parent_id" bson:"parent_id"` // nil for the root folders
 	Name      string             `json:"name" bson:"name"`
 	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
 	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at"`
+	DeletedAt time.Time          `json:"deleted_at,omitzero" bson:"deleted_at,omitempty"` // set while the folder is in the trash
+	PurgeAt   time.Time          `json:"purge_at,omitzero" bson:"purge_at,omitempty"`
 }
 
-func (folder *FolderModel) Create(ownerId, teamId primitive.ObjectID, name string) (primitive.ObjectID, error) {
+func (folder *FolderModel) Create(ownerId, teamId, parentId primitive.ObjectID, name string) (primitive.ObjectID, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	newFolder := &Folder{
 		OwnerId:   ownerId,
 		TeamId:    teamId,
+		ParentId:  parentId,
 		Name:      name,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
@@ -63,6 +67,93 @@ func (folder *FolderModel) Delete(id primitive.ObjectID) error {
 	return nil
 }
 
+// Trash -> Moves the matching folders to the trash, returns the amount of trashed folders
+func (folder *FolderModel) Trash(filter bson.M, deletedAt, purgeAt time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.M{
+		"$set": bson.M{
+			"deleted_at": deletedAt,
+			"purge_at":   purgeAt,
+		},
+	}
+
+	result, err := folder.db.Collection("folders").UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.ModifiedCount, nil
+}
+
+// Restore -> Takes the matching folders out of the trash, returns the amount of restored folders
+func (folder *FolderModel) Restore(filter, updates bson.M) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.M{
+		"$unset": bson.M{
+			"deleted_at": "",
+			"purge_at":   "",
+		},
+	}
+
+	if len(updates) != 0 {
+		update["$set"] = updates
+	}
+
+	result, err := folder.db.Collection("folders").UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.ModifiedCount, nil
+}
+
+// Move -> Changes the parent folder
+func (folder *FolderModel) Move(id, parentId primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.M{
+		"$set": bson.M{
+			"parent_id":  parentId,
+			"updated_at": time.Now(),
+		},
+	}
+
+	result, err := folder.db.Collection("folders").UpdateByID(ctx, id, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return errors.New("no folder found with this id")
+	}
+
+	return nil
+}
+
+// UpdateAll -> Sets updates on every matching folder, returns how many matched
+func (folder *FolderModel) UpdateAll(filter, updates bson.M) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	updates["updated_at"] = time.Now()
+
+	update := bson.M{
+		"$set": updates,
+	}
+
+	result, err := folder.db.Collection("folders").UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.MatchedCount, nil
+}
+
 func (folder *FolderModel) Rename(id primitive.ObjectID, updates any) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -108,6 +199,27 @@ func (folder *FolderModel) GetAll(filter bson.M, page, pageSize int64) ([]Folder
 	return folders, nil
 }
 
+// Find -> Returns every matching folder (not paginated)
+func (folder *FolderModel) Find(filter, projection bson.M) ([]Folder, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	findOptions := options.Find()
+	findOptions.SetProjection(projection)
+
+	cursor, err := folder.db.Collection("folders").Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	var folders []Folder
+	if err := cursor.All(ctx, &folders); err != nil {
+		return nil, err
+	}
+
+	return folders, nil
+}
+
 // Get -> Returns One
 func (folder *FolderModel) Get(filter, projection bson.M) (*Folder, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
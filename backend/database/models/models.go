@@ -1,25 +1,43 @@
 package models
 
 import (
+	"errors"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// ErrStorageExceeded -> A storage reservation would exceed the quota
+var ErrStorageExceeded = errors.New("storage quota exceeded")
+
 type Models struct {
-	User         UserModel
-	File         FileModel
-	Folder       FolderModel
-	FileSettings FileSettingModel
-	Approval     ApprovalModel
-	Team         TeamModel
+	User           UserModel
+	File           FileModel
+	Folder         FolderModel
+	FileSettings   FileSettingModel
+	FolderSettings FolderSettingModel
+	Approval       ApprovalModel
+	Team           TeamModel
+	UploadSession  UploadSessionModel
+	Blob           BlobModel
+	FileVersion    FileVersionModel
+	Share          ShareModel
+	Session        SessionModel
+	ApiToken       ApiTokenModel
 }
 
 func New(db *mongo.Database) *Models {
 	return &Models{
-		User:         UserModel{db: db},
-		File:         FileModel{db: db},
-		Folder:       FolderModel{db: db},
-		FileSettings: FileSettingModel{db: db},
-		Approval:     ApprovalModel{db: db},
-		Team:         TeamModel{db: db},
+		User:           UserModel{db: db},
+		File:           FileModel{db: db},
+		Folder:         FolderModel{db: db},
+		FileSettings:   FileSettingModel{db: db},
+		FolderSettings: FolderSettingModel{db: db},
+		Approval:       ApprovalModel{db: db},
+		Team:           TeamModel{db: db},
+		UploadSession:  UploadSessionModel{db: db},
+		Blob:           BlobModel{db: db},
+		FileVersion:    FileVersionModel{db: db},
+		Share:          ShareModel{db: db},
+		Session:        SessionModel{db: db},
+		ApiToken:       ApiTokenModel{db: db},
 	}
 }
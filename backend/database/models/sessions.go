@@ -0,0 +1,138 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+type SessionModel struct {
+	db *mongo.Database
+}
+
+// Session -> A login. It holds the current refresh token (hashed) and the ID of the only access token it accepts,
+// so rotating the refresh token or deleting the session revokes the access token as well
+type Session struct {
+	Id               primitive.ObjectID `json:"id" bson:"_id"`
+	UserId           primitive.ObjectID `json:"user_id" bson:"user_id"`
+	RefreshTokenHash string             `json:"-" bson:"refresh_token_hash"`
+	UsedTokenHashes  []string           `json:"-" bson:"used_token_hashes"` // the rotated ones, presenting one again means reuse
+	AccessTokenId    string             `json:"-" bson:"access_token_id"`
+	ExpireAt         time.Time          `json:"expire_at" bson:"expire_at"`
+	CreatedAt        time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt        time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+const SessionsCollectionName = "sessions"
+
+// ErrRefreshTokenUsed -> The refresh token was rotated by someone else in the meantime
+var ErrRefreshTokenUsed = errors.New("refresh token has been used already")
+
+// Create -> The id is generated by the caller, the access token carries it
+func (session *SessionModel) Create(id, userId primitive.ObjectID, refreshTokenHash, accessTokenId string, expireAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	newSession := &Session{
+		Id:               id,
+		UserId:           userId,
+		RefreshTokenHash: refreshTokenHash,
+		UsedTokenHashes:  []string{},
+		AccessTokenId:    accessTokenId,
+		ExpireAt:         expireAt,
+		CreatedAt:        time.Now(),
+		UpdatedAt:        time.Now(),
+	}
+
+	_, err := session.db.Collection(SessionsCollectionName).InsertOne(ctx, newSession)
+	return err
+}
+
+// Get -> Returns One
+func (session *SessionModel) Get(filter, projection bson.M) (*Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	findOptions := options.FindOne()
+	findOptions.SetProjection(projection)
+
+	var sessionInstance Session
+	if err := session.db.Collection(SessionsCollectionName).FindOne(ctx, filter, findOptions).Decode(&sessionInstance); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, errors.New("session does not exist")
+		}
+
+		return nil, err
+	}
+
+	return &sessionInstance, nil
+}
+
+// Rotate -> Replaces the refresh token only if it is still the one that was presented,
+// so of two concurrent refreshes with the same token only one wins
+func (session *SessionModel) Rotate(id primitive.ObjectID, oldTokenHash, newTokenHash, accessTokenId string, expireAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"_id":                id,
+		"refresh_token_hash": oldTokenHash,
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"refresh_token_hash": newTokenHash,
+			"access_token_id":    accessTokenId,
+			"expire_at":          expireAt,
+			"updated_at":         time.Now(),
+		},
+		"$push": bson.M{"used_token_hashes": oldTokenHash},
+	}
+
+	result, err := session.db.Collection(SessionsCollectionName).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrRefreshTokenUsed
+	}
+
+	return nil
+}
+
+// UpdateAll -> Returns the amount of updated sessions
+func (session *SessionModel) UpdateAll(filter, updates bson.M) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	updates["updated_at"] = time.Now()
+
+	update := bson.M{
+		"$set": updates,
+	}
+
+	result, err := session.db.Collection(SessionsCollectionName).UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.ModifiedCount, nil
+}
+
+// DeleteAll -> Returns the amount of deleted sessions
+func (session *SessionModel) DeleteAll(filter bson.M) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := session.db.Collection(SessionsCollectionName).DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.DeletedCount, nil
+}
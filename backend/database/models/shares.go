@@ -0,0 +1,157 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+type ShareModel struct {
+	db *mongo.Database
+}
+
+// Share -> Grants a user or a team access to a file or a folder (with its subfolders and files) of the owner.
+// "read" allows listing and downloading, "write" allows uploading (files and versions) and renaming too
+type Share struct {
+	Id         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	OwnerId    primitive.ObjectID `json:"owner_id" bson:"owner_id"`
+	FileId     primitive.ObjectID `json:"file_id,omitzero" bson:"file_id,omitempty"` // either the file id or the folder id is set
+	FolderId   primitive.ObjectID `json:"folder_id,omitzero" bson:"folder_id,omitempty"`
+	UserId     primitive.ObjectID `json:"user_id,omitzero" bson:"user_id,omitempty"` // either the user id or the team id is set
+	TeamId     primitive.ObjectID `json:"team_id,omitzero" bson:"team_id,omitempty"`
+	Permission string             `json:"permission" bson:"permission"`
+	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
+}
+
+const (
+	SharePermissionRead  = "read"
+	SharePermissionWrite = "write"
+)
+
+func (share *ShareModel) Create(ownerId, fileId, folderId, userId, teamId primitive.ObjectID, permission string) (primitive.ObjectID, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	newShare := &Share{
+		OwnerId:    ownerId,
+		FileId:     fileId,
+		FolderId:   folderId,
+		UserId:     userId,
+		TeamId:     teamId,
+		Permission: permission,
+		CreatedAt:  time.Now(),
+	}
+
+	result, err := share.db.Collection("shares").InsertOne(ctx, newShare)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	return result.InsertedID.(primitive.ObjectID), nil
+}
+
+// Get -> Returns One
+func (share *ShareModel) Get(filter, projection bson.M) (*Share, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	findOptions := options.FindOne()
+	findOptions.SetProjection(projection)
+
+	var shareInstance Share
+	if err := share.db.Collection("shares").FindOne(ctx, filter, findOptions).Decode(&shareInstance); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, errors.New("share with this filter does not exist")
+		}
+
+		return nil, err
+	}
+
+	return &shareInstance, nil
+}
+
+// GetAll -> Returns a page, newest first
+func (share *ShareModel) GetAll(filter, projection bson.M, page, pageLimit int64) ([]Share, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	findOptions := options.Find()
+	findOptions.SetProjection(projection)
+	findOptions.SetSort(bson.M{"created_at": -1})
+	findOptions.SetSkip((page - 1) * pageLimit)
+	findOptions.SetLimit(pageLimit)
+
+	cursor, err := share.db.Collection("shares").Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	var shares []Share
+	if err := cursor.All(ctx, &shares); err != nil {
+		return nil, err
+	}
+
+	return shares, nil
+}
+
+// Find -> Returns every matching share (not paginated)
+func (share *ShareModel) Find(filter, projection bson.M) ([]Share, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	findOptions := options.Find()
+	findOptions.SetProjection(projection)
+
+	cursor, err := share.db.Collection("shares").Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	var shares []Share
+	if err := cursor.All(ctx, &shares); err != nil {
+		return nil, err
+	}
+
+	return shares, nil
+}
+
+func (share *ShareModel) Update(id primitive.ObjectID, updates bson.M) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.M{
+		"$set": updates,
+	}
+
+	result, err := share.db.Collection("shares").UpdateByID(ctx, id, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return errors.New("share with this id does not exist")
+	}
+
+	if result.ModifiedCount == 0 {
+		return errors.New("no change detected")
+	}
+
+	return nil
+}
+
+// DeleteAll -> Returns the amount of deleted shares
+func (share *ShareModel) DeleteAll(filter bson.M) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := share.db.Collection("shares").DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.DeletedCount, nil
+}
@@ -83,6 +83,27 @@ func (team *TeamModel) GetAll(filter, projection bson.M, page, pageLimit int64)
 	return teams, nil
 }
 
+// Find -> Returns every matching team (not paginated)
+func (team *TeamModel) Find(filter, projection bson.M) ([]Team, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	findOptions := options.Find()
+	findOptions.SetProjection(projection)
+
+	cursor, err := team.db.Collection("teams").Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	var teams []Team
+	if err := cursor.All(ctx, &teams); err != nil {
+		return nil, err
+	}
+
+	return teams, nil
+}
+
 // Get -> Returns One
 func (team *TeamModel) Get(filter, projection bson.M) (*Team, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -131,6 +152,94 @@ func (team *TeamModel) Update(id primitive.ObjectID, updates bson.M) error {
 	return nil
 }
 
+// AddStorageUsed -> Adds delta (negative when space is freed) to the team`s used storage, never going below zero
+func (team *TeamModel) AddStorageUsed(id primitive.ObjectID, delta int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.A{
+		bson.M{"$set": bson.M{
+			"storage_used": bson.M{"$max": bson.A{0, bson.M{"$add": bson.A{"$storage_used", delta}}}},
+			"updated_at":   time.Now(),
+		}},
+	}
+
+	result, err := team.db.Collection("teams").UpdateByID(ctx, id, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return errors.New("team with this Id does not exist")
+	}
+
+	return nil
+}
+
+// ReserveStorage -> Adds size to the team`s used storage only if it stays within limit, in a single update,
+// so concurrent uploads can`t exceed the quota together. Returns ErrStorageExceeded otherwise
+func (team *TeamModel) ReserveStorage(id primitive.ObjectID, size, limit int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if size > limit {
+		return ErrStorageExceeded
+	}
+
+	filter := bson.M{
+		"_id": id,
+		"$or": bson.A{
+			bson.M{"storage_used": bson.M{"$lte": limit - size}},
+			bson.M{"storage_used": bson.M{"$exists": false}},
+		},
+	}
+
+	update := bson.M{
+		"$inc": bson.M{"storage_used": size},
+		"$set": bson.M{"updated_at": time.Now()},
+	}
+
+	result, err := team.db.Collection("teams").UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		count, err := team.db.Collection("teams").CountDocuments(ctx, bson.M{"_id": id})
+		if err == nil && count == 0 {
+			return errors.New("team with this Id does not exist")
+		}
+
+		return ErrStorageExceeded
+	}
+
+	return nil
+}
+
+// SetStorageUsed -> Overwrites the team`s used storage, only for reconciling it with the stored files
+func (team *TeamModel) SetStorageUsed(id primitive.ObjectID, size int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.M{
+		"$set": bson.M{
+			"storage_used": size,
+			"updated_at":   time.Now(),
+		},
+	}
+
+	result, err := team.db.Collection("teams").UpdateByID(ctx, id, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return errors.New("team with this Id does not exist")
+	}
+
+	return nil
+}
+
 func (team *TeamModel) Delete(id primitive.ObjectID) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
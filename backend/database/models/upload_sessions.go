@@ -0,0 +1,223 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+type UploadSessionModel struct {
+	db *mongo.Database
+}
+
+// UploadSession -> A resumable (chunked) upload. Every chunk is stored as a separate object (encrypted with a key
+// derived from the session`s data key) and all of them are concatenated into the final file on finalize
+type UploadSession struct {
+	Id          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	OwnerId     primitive.ObjectID `json:"owner_id" bson:"owner_id"`
+	TeamId      primitive.ObjectID `json:"team_id" bson:"team_id"`
+	FolderId    primitive.ObjectID `json:"folder_id" bson:"folder_id"`
+	FileName    string             `json:"file_name" bson:"file_name"`
+	ContentType string             `json:"content_type" bson:"content_type"` // sniffed from the first chunk
+	TotalSize   int64              `json:"total_size" bson:"total_size"`
+	Offset      int64              `json:"offset" bson:"offset"`
+	Chunks      []string           `json:"-" bson:"chunks"` // storage keys, in order
+	KeyId       string             `json:"-" bson:"key_id,omitempty"`
+	WrappedKey  string             `json:"-" bson:"wrapped_key,omitempty"` // empty for the sessions whose chunks are plain text
+	Finalizing  bool               `json:"finalizing" bson:"finalizing,omitempty"`
+	ExpireAt    time.Time          `json:"expire_at" bson:"expire_at"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+const UploadSessionsCollectionName = "upload_sessions"
+
+func (session *UploadSessionModel) Create(ownerId, teamId, folderId primitive.ObjectID, fileName string, totalSize int64,
+	keyId, wrappedKey string, expireAt time.Time) (primitive.ObjectID, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	newSession := &UploadSession{
+		OwnerId:    ownerId,
+		TeamId:     teamId,
+		FolderId:   folderId,
+		FileName:   fileName,
+		TotalSize:  totalSize,
+		Chunks:     []string{},
+		KeyId:      keyId,
+		WrappedKey: wrappedKey,
+		ExpireAt:   expireAt,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+
+	result, err := session.db.Collection(UploadSessionsCollectionName).InsertOne(ctx, newSession)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	return result.InsertedID.(primitive.ObjectID), nil
+}
+
+// Get -> Returns One
+func (session *UploadSessionModel) Get(filter, projection bson.M) (*UploadSession, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	findOptions := options.FindOne()
+	findOptions.SetProjection(projection)
+
+	var sessionInstance UploadSession
+	if err := session.db.Collection(UploadSessionsCollectionName).FindOne(ctx, filter, findOptions).Decode(&sessionInstance); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, errors.New("upload session does not exist")
+		}
+
+		return nil, err
+	}
+
+	return &sessionInstance, nil
+}
+
+// GetAll -> Returns List
+func (session *UploadSessionModel) GetAll(filter, projection bson.M) ([]UploadSession, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	findOptions := options.Find()
+	findOptions.SetProjection(projection)
+
+	cursor, err := session.db.Collection(UploadSessionsCollectionName).Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []UploadSession
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// AppendChunk -> Moves the offset forward only if nobody else has written at this offset in the meantime
+func (session *UploadSessionModel) AppendChunk(id primitive.ObjectID, offset, chunkSize int64, chunkKey, contentType string,
+	expireAt time.Time) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"_id":    id,
+		"offset": offset,
+	}
+
+	updates := bson.M{
+		"offset":     offset + chunkSize,
+		"expire_at":  expireAt,
+		"updated_at": time.Now(),
+	}
+
+	if contentType != "" {
+		updates["content_type"] = contentType
+	}
+
+	update := bson.M{
+		"$set":  updates,
+		"$push": bson.M{"chunks": chunkKey},
+	}
+
+	result, err := session.db.Collection(UploadSessionsCollectionName).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return errors.New("upload offset has changed. Please check the current offset and try again")
+	}
+
+	return nil
+}
+
+// ClaimFinalize -> Marks the complete session as being finalized. Only one finalize can claim it,
+// false when another one did already
+func (session *UploadSessionModel) ClaimFinalize(id primitive.ObjectID) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"_id":        id,
+		"finalizing": bson.M{"$ne": true},
+		"$expr":      bson.M{"$eq": bson.A{"$offset", "$total_size"}},
+	}
+
+	update := bson.M{
+		"$set": bson.M{"finalizing": true, "updated_at": time.Now()},
+	}
+
+	result, err := session.db.Collection(UploadSessionsCollectionName).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return result.MatchedCount == 1, nil
+}
+
+// ReleaseFinalize -> Lets the session be finalized again, after a failed finalize
+func (session *UploadSessionModel) ReleaseFinalize(id primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.M{
+		"$unset": bson.M{"finalizing": ""},
+	}
+
+	_, err := session.db.Collection(UploadSessionsCollectionName).UpdateByID(ctx, id, update)
+	return err
+}
+
+// UpdateKey -> Replaces the wrapped data key, only if it is still wrapped by oldKeyId
+func (session *UploadSessionModel) UpdateKey(id primitive.ObjectID, oldKeyId, keyId, wrappedKey string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"_id":    id,
+		"key_id": oldKeyId,
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"key_id":      keyId,
+			"wrapped_key": wrappedKey,
+		},
+	}
+
+	_, err := session.db.Collection(UploadSessionsCollectionName).UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (session *UploadSessionModel) Delete(id primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"_id": id,
+	}
+
+	result, err := session.db.Collection(UploadSessionsCollectionName).DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return errors.New("upload session does not exist")
+	}
+
+	return nil
+}
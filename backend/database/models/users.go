@@ -24,6 +24,26 @@ type User struct {
 	Salt            string             `json:"salt" bson:"salt"`
 	HashedPassword  string             `json:"hashed_password" bson:"hashed_password"`
 	CreatedAt       time.Time          `json:"created_at" bson:"created_at"`
+
+	// two-factor authentication (TOTP). The secret is wrapped by the key ring, it is set at enrollment
+	// and only used once TotpEnabled (the first code was verified)
+	TotpEnabled     bool      `json:"totp_enabled" bson:"totp_enabled"`
+	TotpSecret      string    `json:"-" bson:"totp_secret,omitempty"`
+	TotpKeyId       string    `json:"-" bson:"totp_key_id,omitempty"`
+	TotpLastStep    int64     `json:"-" bson:"totp_last_step,omitempty"` // the codes of this step and the earlier ones are rejected (replays)
+	TotpFailures    int       `json:"-" bson:"totp_failures,omitempty"`
+	TotpLockedUntil time.Time `json:"-" bson:"totp_locked_until,omitempty"`
+	RecoveryCodes   []string  `json:"-" bson:"recovery_codes,omitempty"` // hashed, each one works once
+
+	// single sign-on, at most one identity per provider. The accounts created by it have no password
+	OidcIdentities []OidcIdentity `json:"oidc_identities,omitempty" bson:"oidc_identities,omitempty"`
+}
+
+// OidcIdentity -> The account of the user at an OpenID Connect provider ("sub" of its ID tokens)
+type OidcIdentity struct {
+	Provider string    `json:"provider" bson:"provider"`
+	Subject  string    `json:"-" bson:"subject"`
+	LinkedAt time.Time `json:"linked_at" bson:"linked_at"`
 }
 
 const userCollectionName = "users"
@@ -97,6 +117,246 @@ func (user *UserModel) Update(id primitive.ObjectID, updates bson.M) error {
 	return nil
 }
 
+// UseTotpStep -> Accepts the time step of a TOTP code only once (and only after the last accepted one)
+func (user *UserModel) UseTotpStep(id primitive.ObjectID, step int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"_id": id,
+		"$or": bson.A{
+			bson.M{"totp_last_step": bson.M{"$exists": false}},
+			bson.M{"totp_last_step": bson.M{"$lt": step}},
+		},
+	}
+
+	update := bson.M{
+		"$set":   bson.M{"totp_last_step": step},
+		"$unset": bson.M{"totp_failures": "", "totp_locked_until": ""},
+	}
+
+	result, err := user.db.Collection(userCollectionName).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return result.MatchedCount == 1, nil
+}
+
+// UseRecoveryCode -> Removes the (hashed) recovery code, false when the user does not have it
+func (user *UserModel) UseRecoveryCode(id primitive.ObjectID, codeHash string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"_id":            id,
+		"recovery_codes": codeHash,
+	}
+
+	update := bson.M{
+		"$pull":  bson.M{"recovery_codes": codeHash},
+		"$unset": bson.M{"totp_failures": "", "totp_locked_until": ""},
+	}
+
+	result, err := user.db.Collection(userCollectionName).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return result.MatchedCount == 1, nil
+}
+
+// RecordTotpFailure -> Counts a wrong code. The maxFailures-th one locks the second factor for lockFor (and resets the count)
+func (user *UserModel) RecordTotpFailure(id primitive.ObjectID, maxFailures int, lockFor time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.A{
+		bson.M{"$set": bson.M{"totp_failures": bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$totp_failures", 0}}, 1}}}},
+		bson.M{"$set": bson.M{
+			"totp_locked_until": bson.M{"$cond": bson.A{
+				bson.M{"$gte": bson.A{"$totp_failures", maxFailures}}, time.Now().Add(lockFor), "$totp_locked_until",
+			}},
+			"totp_failures": bson.M{"$cond": bson.A{bson.M{"$gte": bson.A{"$totp_failures", maxFailures}}, 0, "$totp_failures"}},
+		}},
+	}
+
+	_, err := user.db.Collection(userCollectionName).UpdateByID(ctx, id, update)
+	return err
+}
+
+// LinkOidcIdentity -> Adds the identity, false when the user is linked to another account of the provider already
+func (user *UserModel) LinkOidcIdentity(id primitive.ObjectID, provider, subject string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"_id":                      id,
+		"oidc_identities.provider": bson.M{"$ne": provider},
+	}
+
+	update := bson.M{
+		"$push": bson.M{"oidc_identities": OidcIdentity{Provider: provider, Subject: subject, LinkedAt: time.Now()}},
+	}
+
+	result, err := user.db.Collection(userCollectionName).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return result.MatchedCount == 1, nil
+}
+
+// GetAll -> Returns List
+func (user *UserModel) GetAll(filter, projection bson.M, page, pageLimit int64) ([]User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	findOptions := options.Find()
+	findOptions.SetProjection(projection)
+	findOptions.SetSkip((page - 1) * pageLimit)
+	findOptions.SetLimit(pageLimit)
+
+	cursor, err := user.db.Collection(userCollectionName).Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	var users []User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// Find -> Returns every matching user (not paginated)
+func (user *UserModel) Find(filter, projection bson.M) ([]User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	findOptions := options.Find()
+	findOptions.SetProjection(projection)
+
+	cursor, err := user.db.Collection(userCollectionName).Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	var users []User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// UpdateTotpKey -> Replaces the wrapped TOTP secret, only if it is still the same one wrapped by oldKeyId
+// (the user did not enroll again in the meantime)
+func (user *UserModel) UpdateTotpKey(id primitive.ObjectID, oldKeyId, oldSecret, keyId, wrappedSecret string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"_id":         id,
+		"totp_key_id": oldKeyId,
+		"totp_secret": oldSecret,
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"totp_key_id": keyId,
+			"totp_secret": wrappedSecret,
+		},
+	}
+
+	_, err := user.db.Collection(userCollectionName).UpdateOne(ctx, filter, update)
+	return err
+}
+
+// ReserveStorage -> Adds size to the user`s used storage only if it stays within limit, in a single update,
+// so concurrent uploads can`t exceed the quota together. Returns ErrStorageExceeded otherwise
+func (user *UserModel) ReserveStorage(id primitive.ObjectID, size, limit int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if size > limit {
+		return ErrStorageExceeded
+	}
+
+	filter := bson.M{
+		"_id": id,
+		"$or": bson.A{
+			bson.M{"total_upload_size": bson.M{"$lte": limit - size}},
+			bson.M{"total_upload_size": bson.M{"$exists": false}},
+		},
+	}
+
+	update := bson.M{
+		"$inc": bson.M{"total_upload_size": size},
+	}
+
+	result, err := user.db.Collection(userCollectionName).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		count, err := user.db.Collection(userCollectionName).CountDocuments(ctx, bson.M{"_id": id})
+		if err == nil && count == 0 {
+			return errors.New("user with this Id does not exist")
+		}
+
+		return ErrStorageExceeded
+	}
+
+	return nil
+}
+
+// SetUploadSize -> Overwrites the user`s used storage, only for reconciling it with the stored files
+func (user *UserModel) SetUploadSize(id primitive.ObjectID, size int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.M{
+		"$set": bson.M{"total_upload_size": size},
+	}
+
+	result, err := user.db.Collection(userCollectionName).UpdateByID(ctx, id, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return errors.New("user with this Id does not exist")
+	}
+
+	return nil
+}
+
+// AddUploadSize -> Adds delta (negative when space is freed) to the user`s used storage, never going below zero
+func (user *UserModel) AddUploadSize(id primitive.ObjectID, delta int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.A{
+		bson.M{"$set": bson.M{
+			"total_upload_size": bson.M{"$max": bson.A{0, bson.M{"$add": bson.A{"$total_upload_size", delta}}}},
+		}},
+	}
+
+	result, err := user.db.Collection(userCollectionName).UpdateByID(ctx, id, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return errors.New("user with this Id does not exist")
+	}
+
+	return nil
+}
+
 func (user *UserModel) Delete(id primitive.ObjectID) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -0,0 +1,130 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const DataKeySize = 32
+
+// KeyRing -> Master keys, which only wrap (encrypt) the per-file and per-upload data keys and the TOTP secrets.
+// The first key of ENCRYPTION_MASTER_KEYS is the active one, the rest are kept for unwrapping the old ones
+type KeyRing struct {
+	activeKeyId string
+	keys        map[string][]byte
+}
+
+// NewKeyRing -> ENCRYPTION_MASTER_KEYS format: "<key id>:<secret>,<old key id>:<old secret>,..."
+func NewKeyRing() (*KeyRing, error) {
+	masterKeys := os.Getenv("ENCRYPTION_MASTER_KEYS")
+	if masterKeys == "" {
+		return nil, errors.New("ENCRYPTION_MASTER_KEYS env variable is missing")
+	}
+
+	ring := &KeyRing{
+		keys: map[string][]byte{},
+	}
+
+	for _, entry := range strings.Split(masterKeys, ",") {
+		keyId, secret, found := strings.Cut(strings.TrimSpace(entry), ":")
+		if !found || keyId == "" || secret == "" {
+			return nil, fmt.Errorf("invalid master key entry: %q. Must be <key id>:<secret>", entry)
+		}
+
+		if _, exists := ring.keys[keyId]; exists {
+			return nil, fmt.Errorf("duplicate master key id: %s", keyId)
+		}
+
+		// same as the paseto key, any secret size is accepted
+		hash := sha256.Sum256([]byte(secret))
+		ring.keys[keyId] = hash[:]
+
+		if ring.activeKeyId == "" {
+			ring.activeKeyId = keyId
+		}
+	}
+
+	return ring, nil
+}
+
+func (ring *KeyRing) ActiveKeyId() string {
+	return ring.activeKeyId
+}
+
+// NewDataKey -> Returns a random data key and its wrapped form (wrapped by the active master key)
+func (ring *KeyRing) NewDataKey() ([]byte, string, error) {
+	dataKey := make([]byte, DataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, "", err
+	}
+
+	wrappedKey, err := ring.WrapKey(dataKey)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return dataKey, wrappedKey, nil
+}
+
+// WrapKey -> Encrypts the data key with the active master key
+func (ring *KeyRing) WrapKey(dataKey []byte) (string, error) {
+	aead, err := newGCM(ring.keys[ring.activeKeyId])
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	// the key id is authenticated too, so a wrapped key can`t be moved under another master key
+	sealed := aead.Seal(nonce, nonce, dataKey, []byte(ring.activeKeyId))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (ring *KeyRing) UnwrapKey(keyId, wrappedKey string) ([]byte, error) {
+	masterKey, exists := ring.keys[keyId]
+	if !exists {
+		return nil, fmt.Errorf("master key %q is not configured", keyId)
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(wrappedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newGCM(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("wrapped key is too short")
+	}
+
+	nonce, cipherText := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+
+	dataKey, err := aead.Open(nil, nonce, cipherText, []byte(keyId))
+	if err != nil {
+		return nil, fmt.Errorf("unwrapping data key: %w", err)
+	}
+
+	return dataKey, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
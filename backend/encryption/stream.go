@@ -0,0 +1,227 @@
+package encryption
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The content is split into chunks, each one sealed with AES-GCM on its own, so the content can be
+// streamed and a range can be decrypted without reading everything before it.
+// Every object has its own data key, so the nonce can simply be: chunk index (8 bytes) + last chunk flag (4 bytes).
+// The flag makes truncating the object at a chunk boundary detectable
+const (
+	ChunkSize          = 64 << 10 // 64 KB of plain text per chunk
+	encryptedChunkSize = ChunkSize + tagSize
+	tagSize            = 16
+	nonceSize          = 12
+)
+
+func chunkNonce(index uint64, last bool) []byte {
+	nonce := make([]byte, nonceSize)
+	binary.BigEndian.PutUint64(nonce, index)
+
+	if last {
+		nonce[nonceSize-1] = 1
+	}
+
+	return nonce
+}
+
+// PlainTextSize -> Returns the size of the content before encryption
+func PlainTextSize(encryptedSize int64) int64 {
+	chunks := (encryptedSize + encryptedChunkSize - 1) / encryptedChunkSize
+	return encryptedSize - chunks*tagSize
+}
+
+// EncryptedSize -> Returns the size of the stored object for the given content size
+func EncryptedSize(plainTextSize int64) int64 {
+	// an empty content is still one (empty) last chunk
+	chunks := max((plainTextSize+ChunkSize-1)/ChunkSize, 1)
+	return plainTextSize + chunks*tagSize
+}
+
+type encryptingReader struct {
+	aead   cipher.AEAD
+	source *bufio.Reader
+	index  uint64
+	plain  []byte
+	sealed []byte // current sealed chunk, not read yet
+	done   bool
+}
+
+func NewEncryptingReader(source io.Reader, dataKey []byte) (io.Reader, error) {
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := &encryptingReader{
+		aead:   aead,
+		source: bufio.NewReaderSize(source, ChunkSize),
+		plain:  make([]byte, ChunkSize),
+	}
+
+	return reader, nil
+}
+
+func (reader *encryptingReader) Read(p []byte) (int, error) {
+	if len(reader.sealed) == 0 {
+		if reader.done {
+			return 0, io.EOF
+		}
+
+		if err := reader.sealNextChunk(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, reader.sealed)
+	reader.sealed = reader.sealed[n:]
+
+	return n, nil
+}
+
+func (reader *encryptingReader) sealNextChunk() error {
+	n, err := io.ReadFull(reader.source, reader.plain)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+
+	// the chunk is the last one if nothing comes after it
+	last := n < ChunkSize
+	if !last {
+		if _, err := reader.source.Peek(1); err != nil {
+			if !errors.Is(err, io.EOF) {
+				return err
+			}
+
+			last = true
+		}
+	}
+
+	reader.sealed = reader.aead.Seal(reader.sealed[:0], chunkNonce(reader.index, last), reader.plain[:n], nil)
+	reader.index++
+	reader.done = last
+
+	return nil
+}
+
+type decryptingReader struct {
+	aead          cipher.AEAD
+	source        io.ReadSeekCloser
+	encryptedSize int64
+	plainTextSize int64
+	offset        int64 // plain text offset
+	chunkIndex    int64 // index of the decrypted chunk in plain, -1 if none
+	plain         []byte
+	sealed        []byte
+}
+
+// NewDecryptingReader -> Seeking works on the plain text offsets, so it can be given to http.ServeContent
+func NewDecryptingReader(source io.ReadSeekCloser, dataKey []byte) (io.ReadSeekCloser, error) {
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedSize, err := source.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	if encryptedSize < tagSize {
+		return nil, errors.New("encrypted object is too short")
+	}
+
+	reader := &decryptingReader{
+		aead:          aead,
+		source:        source,
+		encryptedSize: encryptedSize,
+		plainTextSize: PlainTextSize(encryptedSize),
+		chunkIndex:    -1,
+		sealed:        make([]byte, encryptedChunkSize),
+	}
+
+	return reader, nil
+}
+
+func (reader *decryptingReader) Read(p []byte) (int, error) {
+	if reader.offset >= reader.plainTextSize {
+		// an empty content still has to be authenticated
+		if reader.plainTextSize == 0 && reader.chunkIndex == -1 {
+			if err := reader.openChunk(0); err != nil {
+				return 0, err
+			}
+		}
+
+		return 0, io.EOF
+	}
+
+	index := reader.offset / ChunkSize
+	if index != reader.chunkIndex {
+		if err := reader.openChunk(index); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, reader.plain[reader.offset-index*ChunkSize:])
+	reader.offset += int64(n)
+
+	return n, nil
+}
+
+func (reader *decryptingReader) openChunk(index int64) error {
+	start := index * encryptedChunkSize
+	end := min(start+encryptedChunkSize, reader.encryptedSize)
+
+	if _, err := reader.source.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+
+	sealed := reader.sealed[:end-start]
+	if _, err := io.ReadFull(reader.source, sealed); err != nil {
+		return err
+	}
+
+	last := end == reader.encryptedSize
+
+	plain, err := reader.aead.Open(reader.plain[:0], chunkNonce(uint64(index), last), sealed, nil)
+	if err != nil {
+		return fmt.Errorf("decrypting chunk %d: %w", index, err)
+	}
+
+	reader.plain = plain
+	reader.chunkIndex = index
+
+	return nil
+}
+
+func (reader *decryptingReader) Seek(offset int64, whence int) (int64, error) {
+	var newOffset int64
+
+	switch whence {
+	case io.SeekStart:
+		newOffset = offset
+	case io.SeekCurrent:
+		newOffset = reader.offset + offset
+	case io.SeekEnd:
+		newOffset = reader.plainTextSize + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+
+	if newOffset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	reader.offset = newOffset
+	return newOffset, nil
+}
+
+func (reader *decryptingReader) Close() error {
+	return reader.source.Close()
+}
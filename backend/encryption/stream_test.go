@@ -0,0 +1,238 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+// readSeekCloser -> An in-memory stored object
+type readSeekCloser struct {
+	*bytes.Reader
+}
+
+func (readSeekCloser) Close() error {
+	return nil
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	dataKey := newTestDataKey(t)
+
+	tests := []struct {
+		name string
+		size int
+	}{
+		{"empty", 0},
+		{"one byte", 1},
+		{"less than a chunk", ChunkSize - 1},
+		{"exactly one chunk", ChunkSize},
+		{"one chunk and a byte", ChunkSize + 1},
+		{"exactly three chunks", 3 * ChunkSize},
+		{"several chunks", 3*ChunkSize + 1234},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			content := make([]byte, test.size)
+			rand.Read(content)
+
+			encrypted := encrypt(t, content, dataKey)
+			if int64(len(encrypted)) != EncryptedSize(int64(test.size)) {
+				t.Errorf("encrypted size = %d, EncryptedSize() = %d", len(encrypted), EncryptedSize(int64(test.size)))
+			}
+
+			if PlainTextSize(int64(len(encrypted))) != int64(test.size) {
+				t.Errorf("PlainTextSize() = %d, want %d", PlainTextSize(int64(len(encrypted))), test.size)
+			}
+
+			decrypted, err := decrypt(encrypted, dataKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(decrypted, content) {
+				t.Error("the decrypted content differs")
+			}
+		})
+	}
+}
+
+// TestStreamSmallReads -> The chunks do not depend on how the source is read (one byte at a time here)
+func TestStreamSmallReads(t *testing.T) {
+	dataKey := newTestDataKey(t)
+
+	content := make([]byte, 2*ChunkSize+7)
+	rand.Read(content)
+
+	reader, err := NewEncryptingReader(iotest.OneByteReader(bytes.NewReader(content)), dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := io.ReadAll(iotest.OneByteReader(reader))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(encrypted[:encryptedChunkSize], encrypt(t, content, dataKey)[:encryptedChunkSize]) {
+		t.Error("the chunks depend on the reads")
+	}
+
+	decrypted, err := decrypt(encrypted, dataKey)
+	if err != nil || !bytes.Equal(decrypted, content) {
+		t.Errorf("decrypt() error = %v, equal = %t", err, bytes.Equal(decrypted, content))
+	}
+}
+
+// TestStreamSeek -> Ranges are decrypted from their chunks, as http.ServeContent reads them
+func TestStreamSeek(t *testing.T) {
+	dataKey := newTestDataKey(t)
+
+	content := make([]byte, 3*ChunkSize+100)
+	rand.Read(content)
+
+	reader, err := NewDecryptingReader(readSeekCloser{bytes.NewReader(encrypt(t, content, dataKey))}, dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	ranges := []struct {
+		start, length int64
+	}{
+		{0, 10},
+		{ChunkSize - 5, 10}, // across the chunk boundary
+		{2*ChunkSize + 3, ChunkSize + 97},
+		{10, 2 * ChunkSize}, // backwards
+		{3 * ChunkSize, 100},
+	}
+
+	for _, byteRange := range ranges {
+		if _, err := reader.Seek(byteRange.start, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+
+		part := make([]byte, byteRange.length)
+		if _, err := io.ReadFull(reader, part); err != nil {
+			t.Fatalf("reading %d bytes at %d: %v", byteRange.length, byteRange.start, err)
+		}
+
+		if !bytes.Equal(part, content[byteRange.start:byteRange.start+byteRange.length]) {
+			t.Errorf("the %d bytes at %d differ", byteRange.length, byteRange.start)
+		}
+	}
+
+	if size, err := reader.Seek(0, io.SeekEnd); err != nil || size != int64(len(content)) {
+		t.Errorf("Seek(0, io.SeekEnd) = %d, %v, want %d", size, err, len(content))
+	}
+}
+
+// TestStreamTampering -> Every change of the stored object is detected: a truncated, reordered or modified object
+// never decrypts to a shorter or different content
+func TestStreamTampering(t *testing.T) {
+	dataKey := newTestDataKey(t)
+
+	content := make([]byte, 3*ChunkSize+100) // 3 full chunks and a short last one
+	rand.Read(content)
+	encrypted := encrypt(t, content, dataKey)
+
+	fullChunks := make([]byte, 3*ChunkSize) // the last chunk is a full one
+	rand.Read(fullChunks)
+	encryptedFullChunks := encrypt(t, fullChunks, dataKey)
+
+	chunk := func(object []byte, index int) []byte {
+		return object[index*encryptedChunkSize : min((index+1)*encryptedChunkSize, len(object))]
+	}
+
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+
+	flipped := bytes.Clone(encrypted)
+	flipped[ChunkSize+10] ^= 1
+
+	tests := []struct {
+		name   string
+		object []byte
+		key    []byte
+	}{
+		{"dropped final chunk", encrypted[:3*encryptedChunkSize], dataKey},
+		{"dropped final full chunk", encryptedFullChunks[:2*encryptedChunkSize], dataKey},
+		{"truncated within the last chunk", encrypted[:len(encrypted)-1], dataKey},
+		{"truncated within a chunk", encrypted[:2*encryptedChunkSize+100], dataKey},
+		{"reordered chunks", join(chunk(encrypted, 1), chunk(encrypted, 0), chunk(encrypted, 2), chunk(encrypted, 3)), dataKey},
+		{"reordered full chunks", join(chunk(encryptedFullChunks, 0), chunk(encryptedFullChunks, 2), chunk(encryptedFullChunks, 1)),
+			dataKey},
+		{"duplicated chunk", join(chunk(encrypted, 0), chunk(encrypted, 0), chunk(encrypted, 2), chunk(encrypted, 3)), dataKey},
+		{"dropped middle chunk", join(chunk(encrypted, 0), chunk(encrypted, 2), chunk(encrypted, 3)), dataKey},
+		{"flipped bit", flipped, dataKey},
+		{"appended data", join(encrypted, []byte("more")), dataKey},
+		{"appended chunk", join(encryptedFullChunks, chunk(encryptedFullChunks, 2)), dataKey},
+		{"emptied", encrypt(t, nil, dataKey)[:tagSize-1], dataKey},
+		{"other key", encrypted, newTestDataKey(t)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := decrypt(test.object, test.key); err == nil {
+				t.Error("the tampered object is decrypted")
+			}
+		})
+	}
+}
+
+// TestStreamEmptyContentAuthenticated -> An empty content is one (empty) authenticated chunk, not nothing
+func TestStreamEmptyContentAuthenticated(t *testing.T) {
+	dataKey := newTestDataKey(t)
+
+	// the tag of an empty content with another key, the last chunk of another content
+	objects := [][]byte{
+		encrypt(t, nil, newTestDataKey(t)),
+		encrypt(t, []byte("x"), dataKey)[1:],
+	}
+
+	for _, object := range objects {
+		if _, err := decrypt(object, dataKey); err == nil {
+			t.Error("the forged empty object is decrypted")
+		}
+	}
+}
+
+func newTestDataKey(t *testing.T) []byte {
+	t.Helper()
+
+	dataKey := make([]byte, DataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		t.Fatal(err)
+	}
+
+	return dataKey
+}
+
+func encrypt(t *testing.T, content, dataKey []byte) []byte {
+	t.Helper()
+
+	reader, err := NewEncryptingReader(bytes.NewReader(content), dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return encrypted
+}
+
+func decrypt(encrypted, dataKey []byte) ([]byte, error) {
+	reader, err := NewDecryptingReader(readSeekCloser{bytes.NewReader(encrypted)}, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
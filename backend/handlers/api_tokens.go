@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"file_manager/token"
+	"file_manager/utils"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	maxApiTokensPerUser     = 50
+	maxApiTokenLifetime     = 365 // days
+	apiTokenUsageThrottle   = time.Minute
+	defaultApiTokenLifetime = 90 // days
+)
+
+// CreateApiToken -> Creates a personal access token. It is returned only once, only its hash is stored
+func (handler *Handler) CreateApiToken(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	userObjectId, err := utils.ToObjectID(payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var input struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+		Code          string   `json:"code"` // for the users with two-factor authentication
+	}
+
+	if err := utils.ParseJSON(r.Body, 1000, &input); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" || len(input.Name) > 100 {
+		utils.WriteError(w, http.StatusBadRequest, "'name' is required (up to 100 characters)")
+		return
+	}
+
+	if err := token.ValidateScopes(input.Scopes); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if input.ExpiresInDays == 0 {
+		input.ExpiresInDays = defaultApiTokenLifetime
+	}
+
+	if input.ExpiresInDays < 0 || input.ExpiresInDays > maxApiTokenLifetime {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Sprintf("'expires_in_days' must be between 1 and %d", maxApiTokenLifetime))
+		return
+	}
+
+	// the api tokens are long-lived credentials, creating one needs a fresh second factor
+	if err := handler.checkSecondFactor(userObjectId, input.Code); err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	filter := bson.M{
+		"user_id": userObjectId,
+	}
+
+	tokens, err := handler.Models.ApiToken.GetAll(filter, bson.M{"_id": 1})
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if len(tokens) >= maxApiTokensPerUser {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Sprintf("you can have up to %d api tokens, revoke some first", maxApiTokensPerUser))
+		return
+	}
+
+	tokenId := primitive.NewObjectID()
+	apiToken, tokenHash := newApiToken(tokenId)
+	expireAt := time.Now().AddDate(0, 0, input.ExpiresInDays)
+
+	if err := handler.Models.ApiToken.Create(tokenId, userObjectId, input.Name, input.Scopes, tokenHash, expireAt); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("creating api token instance: %w", err))
+		return
+	}
+
+	response := map[string]any{
+		"id":        tokenId,
+		"token":     apiToken,
+		"expire_at": expireAt,
+	}
+
+	utils.WriteJSONData(w, response)
+}
+
+// GetApiTokens -> Returns the user`s personal access tokens (without the tokens themselves)
+func (handler *Handler) GetApiTokens(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	userObjectId, err := utils.ToObjectID(payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	filter := bson.M{
+		"user_id": userObjectId,
+	}
+
+	projection := bson.M{
+		"token_hash": 0,
+	}
+
+	tokens, err := handler.Models.ApiToken.GetAll(filter, projection)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	utils.WriteJSONData(w, map[string]any{"api_tokens": tokens, "available_scopes": token.Scopes})
+}
+
+// DeleteApiToken -> Revokes a personal access token, it stops working at once
+func (handler *Handler) DeleteApiToken(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	userObjectId, err := utils.ToObjectID(payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	tokenIdStr, err := utils.ParseIdParam(r.Context())
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	tokenObjectId, err := utils.ToObjectID(tokenIdStr)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	filter := bson.M{
+		"_id":     tokenObjectId,
+		"user_id": userObjectId,
+	}
+
+	deleted, err := handler.Models.ApiToken.DeleteAll(filter)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if deleted == 0 {
+		utils.WriteError(w, http.StatusNotFound, "api token does not exist")
+		return
+	}
+
+	utils.WriteJSON(w, "api token revoked successfully")
+}
+
+// verifyApiToken -> The api token verifier of the PasetoMaker. The payload gets the current username and plan
+func (handler *Handler) verifyApiToken(apiToken string) (*token.Payload, error) {
+	tokenId, tokenHash, err := parseApiToken(apiToken)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{
+		"_id": tokenId,
+	}
+
+	projection := bson.M{
+		"user_id":    1,
+		"scopes":     1,
+		"token_hash": 1,
+		"expire_at":  1,
+	}
+
+	tokenInstance, err := handler.Models.ApiToken.Get(filter, projection)
+	if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(tokenHash), []byte(tokenInstance.TokenHash)) != 1 {
+		return nil, errors.New("invalid api token")
+	}
+
+	if time.Now().After(tokenInstance.ExpireAt) {
+		return nil, errors.New("api token has expired")
+	}
+
+	filter = bson.M{
+		"_id": tokenInstance.UserId,
+	}
+
+	projection = bson.M{
+		"username": 1,
+		"plan":     1,
+	}
+
+	user, err := handler.Models.User.Get(filter, projection)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := handler.Models.ApiToken.Touch(tokenId, apiTokenUsageThrottle); err != nil {
+		slog.Error("updating api token last use", "id", tokenId.Hex(), "error", err)
+	}
+
+	payload := &token.Payload{
+		Username:   user.Username,
+		UserId:     user.Id.Hex(),
+		UserPlan:   user.Plan,
+		ExpiryAt:   tokenInstance.ExpireAt,
+		ApiTokenId: tokenId.Hex(),
+		Scopes:     tokenInstance.Scopes,
+	}
+
+	return payload, nil
+}
+
+// newApiToken -> "fm_pat_<token id>_<random secret>", only the hash of the whole token is stored
+func newApiToken(tokenId primitive.ObjectID) (string, string) {
+	apiToken := token.ApiTokenPrefix + tokenId.Hex() + "_" + rand.Text()
+	return apiToken, hashToken(apiToken)
+}
+
+func parseApiToken(apiToken string) (primitive.ObjectID, string, error) {
+	tokenIdStr, _, found := strings.Cut(strings.TrimPrefix(apiToken, token.ApiTokenPrefix), "_")
+	if !found {
+		return primitive.NilObjectID, "", errors.New("invalid api token")
+	}
+
+	tokenId, err := utils.ToObjectID(tokenIdStr)
+	if err != nil {
+		return primitive.NilObjectID, "", errors.New("invalid api token")
+	}
+
+	return tokenId, hashToken(apiToken), nil
+}
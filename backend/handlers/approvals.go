@@ -108,24 +108,13 @@ func (handler *Handler) CreateApproval(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	filter := bson.M{
-		"short_url": input.ShortUrl,
-	}
-
-	projection := bson.M{
-		"user_id":    1,
-		"name":       1,
-		"approvable": 1,
-		"file_id":    1,
-	}
-
-	fileSettings, err := handler.Models.FileSettings.Get(filter, projection)
+	target, err := handler.getApprovalTarget(input.ShortUrl)
 	if err != nil {
 		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	if !fileSettings.Approvable {
+	if !target.Approvable {
 		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("this file does not require approval"))
 		return
 	}
@@ -136,12 +125,10 @@ func (handler *Handler) CreateApproval(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	filter = bson.M{
-		"file_id":   fileSettings.FileId,
-		"sender_id": userObjectId,
-	}
+	filter := target.approvalFilter()
+	filter["sender_id"] = userObjectId
 
-	projection = bson.M{
+	projection := bson.M{
 		"_id": 1,
 	}
 
@@ -156,21 +143,9 @@ func (handler *Handler) CreateApproval(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	filter = bson.M{
-		"_id": fileSettings.FileId,
-	}
-
-	projection = bson.M{
-		"name": 1,
-	}
-
-	file, err := handler.Models.File.Get(filter, projection)
-	if err != nil {
-		utils.WriteError(w, http.StatusBadRequest, err)
-		return
-	}
+	if _, err := handler.Models.Approval.Create(target.FileId, target.FolderId, target.OwnerId, userObjectId, target.Name,
+		input.Reason); err != nil {
 
-	if _, err := handler.Models.Approval.Create(fileSettings.FileId, fileSettings.UserId, userObjectId, file.Name, input.Reason); err != nil {
 		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
@@ -258,36 +233,13 @@ func (handler *Handler) CheckApproval(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	filter := bson.M{
-		"short_url": shortUrl,
-	}
-
-	projection := bson.M{
-		"_id":     1,
-		"file_id": 1,
-	}
-
-	settingsInstance, err := handler.Models.FileSettings.Get(filter, projection)
+	target, err := handler.getApprovalTarget(shortUrl)
 	if err != nil {
 		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	filter = bson.M{
-		"_id": settingsInstance.FileId,
-	}
-
-	projection = bson.M{
-		"owner_id": 1,
-	}
-
-	file, err := handler.Models.File.Get(filter, projection)
-	if err != nil {
-		utils.WriteError(w, http.StatusBadRequest, err)
-		return
-	}
-
-	if err := checkApprovalAccess(settingsInstance.FileId, file.OwnerId, userObjectId, handler); err != nil {
+	if err := checkApprovalAccess(target.approvalFilter(), target.OwnerId, userObjectId, handler); err != nil {
 		var approvalError *utils.ApprovalError
 
 		if errors.As(err, &approvalError) {
@@ -359,15 +311,13 @@ func (handler *Handler) DeleteApproval(w http.ResponseWriter, r *http.Request) {
 	utils.WriteJSON(w, "approval deleted successfully")
 }
 
-func checkApprovalAccess(fileId, ownerId, requesterId primitive.ObjectID, handler *Handler) error {
+// checkApprovalAccess -> The filter matches the approvals of the file or of the folder (see approvalTarget)
+func checkApprovalAccess(filter bson.M, ownerId, requesterId primitive.ObjectID, handler *Handler) error {
 	if ownerId == requesterId {
 		return nil
 	}
 
-	filter := bson.M{
-		"file_id":   fileId,
-		"sender_id": requesterId,
-	}
+	filter["sender_id"] = requesterId
 
 	projection := bson.M{
 		"status": 1,
@@ -396,3 +346,98 @@ func checkApprovalAccess(fileId, ownerId, requesterId primitive.ObjectID, handle
 	}
 
 }
+
+// approvalTarget -> The file or the folder a short url shares
+type approvalTarget struct {
+	FileId     primitive.ObjectID
+	FolderId   primitive.ObjectID
+	OwnerId    primitive.ObjectID
+	Name       string
+	Approvable bool
+}
+
+// approvalFilter -> Matches the approvals of the target
+func (target *approvalTarget) approvalFilter() bson.M {
+	if target.FolderId != primitive.NilObjectID {
+		return bson.M{"folder_id": target.FolderId}
+	}
+
+	return bson.M{"file_id": target.FileId}
+}
+
+// getApprovalTarget -> The short url is looked up in the file links first, then in the folder links
+func (handler *Handler) getApprovalTarget(shortUrl string) (*approvalTarget, error) {
+	filter := bson.M{
+		"short_url": shortUrl,
+	}
+
+	projection := bson.M{
+		"file_id":    1,
+		"approvable": 1,
+	}
+
+	fileSettings, err := handler.Models.FileSettings.Get(filter, projection)
+	if err == nil {
+		filter = bson.M{
+			"_id":        fileSettings.FileId,
+			"deleted_at": bson.M{"$exists": false},
+		}
+
+		projection = bson.M{
+			"owner_id": 1,
+			"name":     1,
+		}
+
+		file, err := handler.Models.File.Get(filter, projection)
+		if err != nil {
+			return nil, err
+		}
+
+		target := &approvalTarget{
+			FileId:     file.Id,
+			OwnerId:    file.OwnerId,
+			Name:       file.Name,
+			Approvable: fileSettings.Approvable,
+		}
+
+		return target, nil
+	}
+
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	projection = bson.M{
+		"folder_id":  1,
+		"approvable": 1,
+	}
+
+	folderSettings, err := handler.Models.FolderSettings.Get(filter, projection)
+	if err != nil {
+		return nil, err
+	}
+
+	filter = bson.M{
+		"_id":        folderSettings.FolderId,
+		"deleted_at": bson.M{"$exists": false},
+	}
+
+	projection = bson.M{
+		"owner_id": 1,
+		"name":     1,
+	}
+
+	folder, err := handler.Models.Folder.Get(filter, projection)
+	if err != nil {
+		return nil, err
+	}
+
+	target := &approvalTarget{
+		FolderId:   folder.Id,
+		OwnerId:    folder.OwnerId,
+		Name:       folder.Name,
+		Approvable: folderSettings.Approvable,
+	}
+
+	return target, nil
+}
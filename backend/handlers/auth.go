@@ -1,19 +1,26 @@
 package handlers
 
 import (
-	"encoding/hex"
 	"errors"
+	"file_manager/database/models"
 	"file_manager/utils"
 	"fmt"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"log/slog"
 	"net/http"
-	"time"
+	"os"
 )
 
 const DefaultPlan = "free"
 
 func (handler *Handler) Register(w http.ResponseWriter, r *http.Request) {
+	if isPasswordLoginDisabled() {
+		utils.WriteError(w, http.StatusForbidden, "registration is disabled, log in with single sign-on")
+		return
+	}
+
 	var input struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
@@ -29,16 +36,12 @@ func (handler *Handler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	salt, err := utils.GenerateSalt()
+	hashedPassword, err := utils.HashPassword(input.Password)
 	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("error creating salt: %w", err))
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("error hashing password: %w", err))
 		return
 	}
 
-	hashedPassword := utils.Hash256([]byte(input.Password), salt)
-	encodedHash := hex.EncodeToString(hashedPassword[:])
-	encodedSalt := hex.EncodeToString(salt)
-
 	filter := bson.M{
 		"username": input.Username,
 	}
@@ -54,7 +57,7 @@ func (handler *Handler) Register(w http.ResponseWriter, r *http.Request) {
 
 	// If user does not exist (ErrNoDocuments), Create One
 	if errors.Is(err, mongo.ErrNoDocuments) {
-		if _, err = handler.Models.User.Create(input.Username, DefaultPlan, encodedSalt, encodedHash); err != nil {
+		if _, err = handler.Models.User.Create(input.Username, DefaultPlan, "", hashedPassword); err != nil {
 			utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("creating user instance: %w", err))
 			return
 		}
@@ -67,6 +70,11 @@ func (handler *Handler) Register(w http.ResponseWriter, r *http.Request) {
 }
 
 func (handler *Handler) Login(w http.ResponseWriter, r *http.Request) {
+	if isPasswordLoginDisabled() {
+		utils.WriteError(w, http.StatusForbidden, "password login is disabled, log in with single sign-on")
+		return
+	}
+
 	var input struct {
 		Username    string `json:"username"`
 		RawPassword string `json:"password"`
@@ -81,13 +89,9 @@ func (handler *Handler) Login(w http.ResponseWriter, r *http.Request) {
 		"username": input.Username,
 	}
 
-	projection := bson.M{
-		"_id":             1,
-		"hashed_password": 1,
-		"salt":            1,
-		"plan":            1,
-		"avatar_url":      1,
-	}
+	projection := getLoginProjection()
+	projection["hashed_password"] = 1
+	projection["salt"] = 1
 
 	user, err := handler.Models.User.Get(filter, projection)
 	if err != nil {
@@ -95,38 +99,89 @@ func (handler *Handler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fmt.Println(*user)
-
-	decodedHash, err := hex.DecodeString(user.HashedPassword)
-	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("error decoding hash: %w", err))
+	// the accounts created by single sign-on have no password
+	if user.HashedPassword == "" {
+		utils.WriteError(w, http.StatusUnauthorized, errors.New("invalid username or password"))
 		return
 	}
 
-	decodedSalt, err := hex.DecodeString(user.Salt)
+	needsRehash, err := utils.CheckPassword(input.RawPassword, user.HashedPassword, user.Salt)
 	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("error decoding salt: %w", err))
+		utils.WriteError(w, http.StatusUnauthorized, errors.New("invalid username or password"))
 		return
 	}
 
-	if !utils.ValidateHash([]byte(input.RawPassword), decodedHash, decodedSalt) {
-		utils.WriteError(w, http.StatusUnauthorized, errors.New("invalid username or password"))
+	if needsRehash {
+		handler.rehashPassword(input.RawPassword, user.Id, handler.Models.User.Update)
+	}
+
+	handler.completeLogin(w, user)
+}
+
+// completeLogin -> Starts the session of the authenticated user, or the second step (VerifyTwoFactorLogin)
+// which exchanges the challenge and a code for the session
+func (handler *Handler) completeLogin(w http.ResponseWriter, user *models.User) {
+	if user.TotpEnabled {
+		challengeToken, err := handler.PasetoMaker.CreateChallengeToken(user.Id.Hex(), twoFactorChallengeLifetime)
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("error creating challenge: %w", err))
+			return
+		}
+
+		utils.WriteJSONData(w, map[string]any{"two_factor_required": true, "challenge_token": challengeToken})
 		return
 	}
 
-	token, err := handler.PasetoMaker.CreateToken(input.Username, user.Id.Hex(), user.Plan, 24*time.Hour)
+	handler.writeLoginResponse(w, user)
+}
+
+// writeLoginResponse -> Starts the session of the logged-in user
+func (handler *Handler) writeLoginResponse(w http.ResponseWriter, user *models.User) {
+	tokens, err := handler.createSession(user.Id, user.Username, user.Plan)
 	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("error creating token: %w", err))
+		utils.WriteError(w, http.StatusInternalServerError, err)
 		return
 	}
 
 	response := map[string]interface{}{
-		"token":      token,
-		"userId":     user.Id.Hex(),
-		"username":   input.Username,
-		"plan":       user.Plan,
-		"avatar_url": user.AvatarUrl,
+		"token":            tokens.AccessToken,
+		"refresh_token":    tokens.RefreshToken,
+		"token_expires_at": tokens.TokenExpiresAt,
+		"userId":           user.Id.Hex(),
+		"username":         user.Username,
+		"plan":             user.Plan,
+		"avatar_url":       user.AvatarUrl,
 	}
 
 	utils.WriteJSONData(w, response)
 }
+
+// rehashPassword -> Replaces a legacy (or outdated) hash once the password was checked, with update of the matching model.
+// It is best-effort: the old hash keeps working when it fails
+func (handler *Handler) rehashPassword(rawPassword string, id primitive.ObjectID, update func(primitive.ObjectID, bson.M) error) {
+	hashedPassword, err := utils.HashPassword(rawPassword)
+	if err == nil {
+		// the salt is a part of the new hashes
+		err = update(id, bson.M{"hashed_password": hashedPassword, "salt": ""})
+	}
+
+	if err != nil {
+		slog.Error("rehashing password", "id", id.Hex(), "error", err)
+	}
+}
+
+// getLoginProjection -> What completeLogin needs of the user
+func getLoginProjection() bson.M {
+	return bson.M{
+		"_id":          1,
+		"username":     1,
+		"plan":         1,
+		"avatar_url":   1,
+		"totp_enabled": 1,
+	}
+}
+
+// isPasswordLoginDisabled -> PASSWORD_LOGIN_DISABLED=true leaves single sign-on as the only way to log in
+func isPasswordLoginDisabled() bool {
+	return os.Getenv("PASSWORD_LOGIN_DISABLED") == "true"
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"file_manager/database/models"
+	"file_manager/encryption"
+	"file_manager/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"io"
+)
+
+// storeBlob -> Streams the content into a temporary object while hashing and encrypting it, then keeps only one copy per sha256.
+// The returned blob holds a new reference which must be released once the file referring to it is deleted
+func (handler *Handler) storeBlob(ctx context.Context, reader io.Reader) (*models.Blob, error) {
+	tempAddress := getTempUploadDir() + rand.Text()
+
+	dataKey, wrappedKey, err := handler.KeyRing.NewDataKey()
+	if err != nil {
+		return nil, err
+	}
+
+	// the hash and the size are of the plain text
+	hasher := sha256.New()
+	countingReader := &utils.CountingReader{Reader: io.TeeReader(reader, hasher)}
+
+	encryptingReader, err := encryption.NewEncryptingReader(countingReader, dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := handler.Storage.Put(ctx, tempAddress, encryptingReader, -1); err != nil {
+		handler.deleteObject(tempAddress)
+		return nil, err
+	}
+
+	hash := hex.EncodeToString(hasher.Sum(nil))
+
+	// a fresh address per blob creation, so a concurrent release of an older blob can`t remove our object
+	newAddress := getBlobAddress(hash)
+
+	// the object is put in place before the blob is recorded: once the record exists, other uploads of the same
+	// content rely on its object
+	if err := handler.Storage.Move(ctx, tempAddress, newAddress); err != nil {
+		handler.deleteObject(tempAddress)
+		return nil, err
+	}
+
+	blob, err := handler.Models.Blob.AddReference(hash, newAddress, countingReader.Count, handler.KeyRing.ActiveKeyId(), wrappedKey)
+	if err != nil {
+		handler.deleteObject(newAddress)
+		return nil, err
+	}
+
+	// identical content is stored already (encrypted with the existing blob`s key)
+	if blob.Address != newAddress {
+		handler.deleteObject(newAddress)
+	}
+
+	return blob, nil
+}
+
+// newFileContent -> The metadata of an uploaded file which is stored in the given blob
+func newFileContent(blob *models.Blob, originalName, contentType string) *models.FileContent {
+	content := &models.FileContent{
+		Address:      blob.Address,
+		Checksum:     blob.Id,
+		Size:         blob.Size,
+		ContentType:  contentType,
+		OriginalName: originalName,
+		Extension:    utils.GetFileExtension(originalName, contentType),
+	}
+
+	return content
+}
+
+// openContent -> Returns the decrypted content (seekable) of a file (version) and its size.
+// Files uploaded before encryption are stored as plain text
+func (handler *Handler) openContent(ctx context.Context, content *models.FileContent) (io.ReadSeekCloser, int64, error) {
+	// files uploaded before deduplication have no blob
+	if content.Checksum == "" {
+		return handler.openObject(ctx, content.Address, nil)
+	}
+
+	filter := bson.M{
+		"_id": content.Checksum,
+	}
+
+	blob, err := handler.Models.Blob.Get(filter, getBlobKeyProjection())
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return handler.openObject(ctx, content.Address, blob)
+}
+
+// openObject -> blob may be nil for objects which are not encrypted
+func (handler *Handler) openObject(ctx context.Context, address string, blob *models.Blob) (io.ReadSeekCloser, int64, error) {
+	object, err := handler.Storage.Get(ctx, address)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if blob == nil || blob.WrappedKey == "" {
+		size, err := object.Seek(0, io.SeekEnd)
+		if err != nil {
+			object.Close()
+			return nil, 0, err
+		}
+
+		if _, err := object.Seek(0, io.SeekStart); err != nil {
+			object.Close()
+			return nil, 0, err
+		}
+
+		return object, size, nil
+	}
+
+	dataKey, err := handler.KeyRing.UnwrapKey(blob.KeyId, blob.WrappedKey)
+	if err != nil {
+		object.Close()
+		return nil, 0, err
+	}
+
+	decryptingReader, err := encryption.NewDecryptingReader(object, dataKey)
+	if err != nil {
+		object.Close()
+		return nil, 0, err
+	}
+
+	return decryptingReader, blob.Size, nil
+}
+
+// releaseContent -> Removes the bytes of a file (version) from the storage once nothing else refers to them
+func (handler *Handler) releaseContent(ctx context.Context, content *models.FileContent) error {
+	// files uploaded before deduplication own their object
+	if content.Checksum == "" {
+		return handler.Storage.Delete(ctx, content.Address)
+	}
+
+	return handler.releaseBlob(ctx, content.Checksum)
+}
+
+func (handler *Handler) releaseBlob(ctx context.Context, hash string) error {
+	blob, err := handler.Models.Blob.RemoveReference(hash)
+	if err != nil {
+		return err
+	}
+
+	if blob.RefCount > 0 {
+		return nil
+	}
+
+	deleted, err := handler.Models.Blob.DeleteUnreferenced(hash)
+	if err != nil || !deleted {
+		return err
+	}
+
+	return handler.Storage.Delete(ctx, blob.Address)
+}
+
+func getBlobKeyProjection() bson.M {
+	return bson.M{
+		"size":        1,
+		"key_id":      1,
+		"wrapped_key": 1,
+	}
+}
+
+func getBlobAddress(hash string) string {
+	return getBlobsDir() + hash[:2] + "/" + hash + "-" + rand.Text()
+}
+
+func getBlobsDir() string {
+	return "uploads/blobs/"
+}
+
+func getTempUploadDir() string {
+	return "uploads/tmp/"
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"file_manager/database/models"
+	"file_manager/storage"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"log/slog"
+	"time"
+)
+
+// expiredFilesBatchSize -> Files are removed in batches, so a large backlog does not load everything at once
+const expiredFilesBatchSize = 100
+
+// StartExpirationSweeper -> Periodically removes the expired files (returning their space), the expired share settings,
+// the trashed files and folders whose retention is over and the expired login sessions
+func (handler *Handler) StartExpirationSweeper(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			handler.removeExpiredFiles()
+			handler.removeExpiredFileSettings()
+			handler.purgeExpiredTrash()
+			handler.removeExpiredSessions()
+		}
+	}()
+}
+
+func (handler *Handler) removeExpiredFiles() {
+	filter := bson.M{
+		"expire_at": bson.M{"$lt": time.Now()},
+	}
+
+	if err := handler.removeMatchingFiles(context.Background(), filter); err != nil {
+		slog.Error("removing expired files", "error", err)
+	}
+}
+
+// removeMatchingFiles -> Removes the files in batches, the failed ones are logged and left for the next run
+func (handler *Handler) removeMatchingFiles(ctx context.Context, filter bson.M) error {
+	for {
+		files, err := handler.Models.File.GetAll(filter, 1, expiredFilesBatchSize)
+		if err != nil {
+			return err
+		}
+
+		var removed int
+		for _, file := range files {
+			if err := handler.removeFile(ctx, &file); err != nil {
+				slog.Error("removing file", "id", file.Id.Hex(), "error", err)
+				continue
+			}
+
+			removed++
+		}
+
+		// the failed ones would be returned again
+		if len(files) < expiredFilesBatchSize || removed == 0 {
+			return nil
+		}
+	}
+}
+
+func (handler *Handler) removeExpiredSessions() {
+	filter := bson.M{
+		"expire_at": bson.M{"$lt": time.Now()},
+	}
+
+	if _, err := handler.Models.Session.DeleteAll(filter); err != nil {
+		slog.Error("removing expired sessions", "error", err)
+	}
+}
+
+func (handler *Handler) removeExpiredFileSettings() {
+	filter := bson.M{
+		"expiration_at": bson.M{"$lt": time.Now()},
+	}
+
+	if _, err := handler.Models.FileSettings.DeleteAll(filter); err != nil {
+		slog.Error("removing expired file settings", "error", err)
+	}
+
+	if _, err := handler.Models.FolderSettings.DeleteAll(filter); err != nil {
+		slog.Error("removing expired folder settings", "error", err)
+	}
+}
+
+// removeFile -> Deletes the file with its versions, bytes and share settings, and gives their space back to the team or the owner
+func (handler *Handler) removeFile(ctx context.Context, file *models.File) error {
+	size, err := handler.getFileSize(ctx, file)
+	if err != nil {
+		return err
+	}
+
+	versionsSize, err := handler.removeFileVersions(ctx, file.Id)
+	if err != nil {
+		return err
+	}
+
+	// the versions are gone already, their space is returned even if the rest fails
+	if err := handler.returnStorage(file.OwnerId, file.TeamId, versionsSize); err != nil {
+		return err
+	}
+
+	// the release is recorded first: if a step below fails, the retried removal must not release the content again
+	// (the blob may be referenced by other files). A failed release leaves the bytes behind instead
+	released, err := handler.Models.File.MarkContentReleased(file.Id)
+	if err != nil {
+		return err
+	}
+
+	if released {
+		if err := handler.releaseContent(ctx, &file.FileContent); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			slog.Error("releasing file content", "id", file.Id.Hex(), "error", err)
+		}
+	}
+
+	if err := handler.Models.File.Delete(file.Id); err != nil {
+		return err
+	}
+
+	filter := bson.M{
+		"file_id": file.Id,
+	}
+
+	if _, err := handler.Models.FileSettings.DeleteAll(filter); err != nil {
+		return fmt.Errorf("removing file settings: %w", err)
+	}
+
+	if _, err := handler.Models.Share.DeleteAll(filter); err != nil {
+		return fmt.Errorf("removing file shares: %w", err)
+	}
+
+	return handler.returnStorage(file.OwnerId, file.TeamId, size)
+}
+
+// getFileSize -> Files uploaded before the size was stored are measured in the storage
+func (handler *Handler) getFileSize(ctx context.Context, file *models.File) (int64, error) {
+	if file.Size != 0 || file.Checksum != "" {
+		return file.Size, nil
+	}
+
+	info, err := handler.Storage.Stat(ctx, file.Address)
+	if errors.Is(err, storage.ErrNotFound) {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Size, nil
+}
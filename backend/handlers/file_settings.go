@@ -1,13 +1,13 @@
 package handlers
 
 import (
-	"encoding/hex"
 	"errors"
+	"file_manager/database/models"
 	"file_manager/utils"
 	"fmt"
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"net/http"
 	"strconv"
 	"time"
@@ -44,7 +44,8 @@ func (handler *Handler) CreateFileSettings(w http.ResponseWriter, r *http.Reques
 	}
 
 	filter := bson.M{
-		"_id": fileObjectId,
+		"_id":        fileObjectId,
+		"deleted_at": bson.M{"$exists": false},
 	}
 
 	projection := bson.M{
@@ -53,31 +54,19 @@ func (handler *Handler) CreateFileSettings(w http.ResponseWriter, r *http.Reques
 	}
 
 	file, err := handler.Models.File.Get(filter, projection)
-	if file.OwnerId != userObjectId {
-		utils.WriteError(w, http.StatusBadRequest, "only the file owner can create settings(shortUrl) for it")
-		return
-	}
-
 	if err != nil {
 		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	filter = bson.M{
-		"file_id": fileObjectId,
-	}
-
-	projection = bson.M{
-		"file_id": 1,
-	}
-
-	_, err = handler.Models.FileSettings.Get(filter, projection)
-	// If it exists, return error
-	if !errors.Is(err, mongo.ErrNoDocuments) {
-		utils.WriteError(w, http.StatusBadRequest, "setting with this filter does not exist")
+	if file.OwnerId != userObjectId {
+		utils.WriteError(w, http.StatusBadRequest, "only the file owner can create settings(shortUrl) for it")
 		return
 	}
 
+	// a file can have many links (e.g. one per audience), each with its own settings
+	label := r.FormValue("label")
+
 	hashedPassword, salt, err := getPasswordAndSalt(r)
 	if err != nil {
 		utils.WriteError(w, http.StatusBadRequest, err)
@@ -114,7 +103,7 @@ func (handler *Handler) CreateFileSettings(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	if err := handler.Models.FileSettings.Create(fileObjectId, userObjectId, fileShortUrl.String(), salt, hashedPassword, maxDownloads,
+	if err := handler.Models.FileSettings.Create(fileObjectId, userObjectId, fileShortUrl.String(), label, salt, hashedPassword, maxDownloads,
 		viewOnly, approvable, expireAt); err != nil {
 
 		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("creating file share setting instance: %w", err))
@@ -145,7 +134,7 @@ func (handler *Handler) GetFilesSettings(w http.ResponseWriter, r *http.Request)
 		"user_id": userObjectId,
 	}
 
-	files, err := handler.Models.FileSettings.GetAll(filter)
+	files, err := handler.Models.FileSettings.GetAll(filter, getShareLinkProjection())
 	if err != nil {
 		utils.WriteError(w, http.StatusBadRequest, err)
 		return
@@ -158,6 +147,79 @@ func (handler *Handler) GetFilesSettings(w http.ResponseWriter, r *http.Request)
 	utils.WriteJSONData(w, response)
 }
 
+// UpdateFileSettings -> Changes the settings of an existing link, its short url stays the same. Only the sent fields change,
+// with the same plan checks as on creation. remove_password=true removes the password, reset_downloads=true resets the
+// download counter
+func (handler *Handler) UpdateFileSettings(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	userObjectId, err := utils.ToObjectID(payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	settingId, err := utils.ParseIdParam(r.Context())
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	settingObjectId, err := utils.ToObjectID(settingId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	filter := bson.M{
+		"_id":     settingObjectId,
+		"user_id": userObjectId,
+	}
+
+	projection := bson.M{
+		"_id": 1,
+	}
+
+	if _, err := handler.Models.FileSettings.Get(filter, projection); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	updates, err := getShareLinkUpdates(r, payload.UserPlan)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	resetDownloads, err := getBoolFormValue(r, "reset_downloads")
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// the downloads in progress keep their reservations
+	if resetDownloads {
+		updates["current_download_amount"] = 0
+		updates["downloaded_bytes"] = 0
+	}
+
+	if len(updates) == 0 {
+		utils.WriteError(w, http.StatusBadRequest, "nothing to update")
+		return
+	}
+
+	if err := handler.Models.FileSettings.Update(settingObjectId, updates); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	utils.WriteJSON(w, "setting updated successfully")
+}
+
 func (handler *Handler) DeleteFileSettings(w http.ResponseWriter, r *http.Request) {
 	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
 	if err != nil {
@@ -209,23 +271,159 @@ func (handler *Handler) DeleteFileSettings(w http.ResponseWriter, r *http.Reques
 	utils.WriteJSON(w, "setting deleted successfully")
 }
 
+// getShareLinks -> Returns the share links of each file (by file id), oldest first
+func (handler *Handler) getShareLinks(files []models.File) (map[string][]models.FileSettings, error) {
+	fileIds := make([]primitive.ObjectID, 0, len(files))
+	for _, file := range files {
+		fileIds = append(fileIds, file.Id)
+	}
+
+	filter := bson.M{
+		"file_id": bson.M{"$in": fileIds},
+	}
+
+	settings, err := handler.Models.FileSettings.GetAll(filter, getShareLinkProjection())
+	if err != nil {
+		return nil, err
+	}
+
+	shareLinks := make(map[string][]models.FileSettings, len(files))
+	for _, setting := range settings {
+		fileId := setting.FileId.Hex()
+		shareLinks[fileId] = append(shareLinks[fileId], setting)
+	}
+
+	return shareLinks, nil
+}
+
+// getShareLinkProjection -> The fields of a file or folder link shown to its owner and team. The salt, the password hash
+// and the download reservations are left out, has_password tells whether the link needs a password
+func getShareLinkProjection() bson.M {
+	return bson.M{
+		"user_id":                 1,
+		"file_id":                 1,
+		"folder_id":               1,
+		"short_url":               1,
+		"label":                   1,
+		"max_downloads":           1,
+		"current_download_amount": 1,
+		"downloaded_bytes":        1,
+		"downloaded_amount":       1,
+		"view_only":               1,
+		"approvable":              1,
+		"expiration_at":           1,
+		"created_at":              1,
+		"has_password":            bson.M{"$gt": bson.A{"$hashed_password", ""}},
+	}
+}
+
+// getShareLinkUpdates -> The changed settings of a file or folder link, only the sent fields are changed.
+// remove_password=true removes the password
+func getShareLinkUpdates(r *http.Request, plan string) (bson.M, error) {
+	updates := bson.M{}
+
+	if hasFormValue(r, "label") {
+		updates["label"] = r.FormValue("label")
+	}
+
+	removePassword, err := getBoolFormValue(r, "remove_password")
+	if err != nil {
+		return nil, err
+	}
+
+	if removePassword {
+		if r.FormValue("password") != "" {
+			return nil, errors.New("'password' and 'remove_password' can`t be sent together")
+		}
+
+		updates["hashed_password"] = ""
+		updates["salt"] = ""
+	}
+
+	if r.FormValue("password") != "" {
+		hashedPassword, salt, err := getPasswordAndSalt(r)
+		if err != nil {
+			return nil, err
+		}
+
+		updates["hashed_password"] = hashedPassword
+		updates["salt"] = salt
+	}
+
+	if hasFormValue(r, "approvable") {
+		approvable, err := getApproval(r, plan)
+		if err != nil {
+			return nil, err
+		}
+
+		updates["approvable"] = approvable
+	}
+
+	if hasFormValue(r, "view_only") {
+		viewOnly, err := getViewOnly(r, plan)
+		if err != nil {
+			return nil, err
+		}
+
+		updates["view_only"] = viewOnly
+	}
+
+	if hasFormValue(r, "max_downloads") {
+		maxDownloads, err := getMaxDownloads(r, plan)
+		if err != nil {
+			return nil, err
+		}
+
+		updates["max_downloads"] = maxDownloads
+	}
+
+	if hasFormValue(r, "expiration_at") {
+		expireAt, err := getExpireAt(r, plan)
+		if err != nil {
+			return nil, err
+		}
+
+		updates["expiration_at"] = expireAt
+	}
+
+	return updates, nil
+}
+
+// hasFormValue -> Whether the field was sent at all (even empty)
+func hasFormValue(r *http.Request, key string) bool {
+	// parses the form if it is not parsed yet
+	_ = r.FormValue(key)
+
+	return r.Form.Has(key)
+}
+
+func getBoolFormValue(r *http.Request, key string) (bool, error) {
+	value := r.FormValue(key)
+	if value == "" {
+		return false, nil
+	}
+
+	parsedValue, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("'%s' must be true or false", key)
+	}
+
+	return parsedValue, nil
+}
+
 func getPasswordAndSalt(r *http.Request) (string, string, error) {
 	rawPassword := r.FormValue("password")
 	if rawPassword == "" {
 		return "", "", nil
 	}
 
-	salt, err := utils.GenerateSalt()
+	// the salt is a part of the hash now, it is only stored apart for the legacy hashes
+	hashedPassword, err := utils.HashPassword(rawPassword)
 	if err != nil {
 		return "", "", err
 	}
 
-	hashedPassword := utils.Hash256([]byte(rawPassword), salt)
-
-	encodedPasswordHash := hex.EncodeToString(hashedPassword[:])
-	encodedSalt := hex.EncodeToString(salt)
-
-	return encodedPasswordHash, encodedSalt, nil
+	return hashedPassword, "", nil
 }
 
 func getApproval(r *http.Request, plan string) (bool, error) {
@@ -0,0 +1,460 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"file_manager/database/models"
+	"file_manager/storage"
+	"file_manager/utils"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// UploadFileVersion -> Uploads a new content for an existing file. Share links keep pointing to the file, so they serve the new version
+func (handler *Handler) UploadFileVersion(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	userObjectId, err := utils.ToObjectID(payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	file, team, err := handler.getVersionedFile(r, userObjectId, models.SharePermissionWrite)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// the owner`s quota and limits apply to the uploads of the users the file is shared with
+	ownerPlan, err := handler.getOwnerPlan(file.OwnerId, userObjectId, payload.UserPlan)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var content *models.FileContent
+	if team == nil {
+		maxUploadSize := utils.GetUserMaxUploadSize(ownerPlan)
+
+		// extra megabyte for the multipart boundaries and the plain fields
+		r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize+MegaBytes)
+
+		content, err = handler.storeUserFile(r, maxUploadSize, file.OwnerId, ownerPlan)
+	} else {
+		maxUploadSize := utils.GetTeamMaxUploadSize(team.Plan)
+		r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize+MegaBytes)
+
+		content, err = handler.storeTeamFile(r, maxUploadSize, team)
+	}
+
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	content.UploaderId = userObjectId
+	content.UploadedAt = time.Now()
+
+	version, err := handler.addFileVersion(context.Background(), file, team, ownerPlan, content)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	utils.WriteJSONData(w, map[string]any{"version": version})
+}
+
+// GetFileVersions -> Returns the current version followed by the previous ones (newest first)
+func (handler *Handler) GetFileVersions(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	userObjectId, err := utils.ToObjectID(payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	file, _, err := handler.getVersionedFile(r, userObjectId, models.SharePermissionRead)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	filter := bson.M{
+		"file_id": file.Id,
+	}
+
+	previousVersions, err := handler.Models.FileVersion.GetAll(filter, bson.M{})
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	versions := append([]models.FileVersion{getCurrentVersion(file)}, previousVersions...)
+
+	response := map[string]any{
+		"file_id":         file.Id,
+		"current_version": getCurrentVersion(file).Number,
+		"versions":        versions,
+	}
+
+	utils.WriteJSONData(w, response)
+}
+
+// DownloadFileVersion -> Serves a specific version (?version=<number>) to the owner or the team members
+func (handler *Handler) DownloadFileVersion(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	userObjectId, err := utils.ToObjectID(payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	number, err := strconv.ParseInt(r.URL.Query().Get("version"), 10, 64)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "invalid version number")
+		return
+	}
+
+	file, _, err := handler.getVersionedFile(r, userObjectId, models.SharePermissionRead)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	version, err := handler.getFileVersion(file, number)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	content, _, err := handler.openContent(r.Context(), &version.FileContent)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	defer content.Close()
+
+	versionFile := *file
+	versionFile.FileContent = version.FileContent
+
+	contentType := version.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": getDownloadName(&versionFile)}))
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", getETag(&versionFile))
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	http.ServeContent(w, r, versionFile.Name, version.UploadedAt, content)
+}
+
+// RestoreFileVersion -> The content of the given version becomes a new (current) version, the history is kept as it is
+func (handler *Handler) RestoreFileVersion(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	userObjectId, err := utils.ToObjectID(payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var input struct {
+		Version int64 `json:"version"`
+	}
+
+	if err := utils.ParseJSON(r.Body, 1000, &input); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	file, team, err := handler.getVersionedFile(r, userObjectId, models.SharePermissionWrite)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ownerPlan, err := handler.getOwnerPlan(file.OwnerId, userObjectId, payload.UserPlan)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if input.Version == getCurrentVersion(file).Number {
+		utils.WriteError(w, http.StatusBadRequest, "this version is the current one already")
+		return
+	}
+
+	version, err := handler.getFileVersion(file, input.Version)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// every version counts against the quota, so the restored one is charged again
+	if err := handler.chargeStorage(file.OwnerId, team, ownerPlan, version.Size); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	content, err := handler.copyContent(r.Context(), &version.FileContent)
+	if err != nil {
+		_ = handler.returnStorage(file.OwnerId, file.TeamId, version.Size)
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	content.UploaderId = userObjectId
+	content.UploadedAt = time.Now()
+
+	newVersion, err := handler.addFileVersion(context.Background(), file, team, ownerPlan, content)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	utils.WriteJSONData(w, map[string]any{"version": newVersion})
+}
+
+// addFileVersion -> Makes content the current one and keeps the previous content as a version.
+// The content`s space must be charged already, it is given back (with the content released) on failure
+func (handler *Handler) addFileVersion(ctx context.Context, file *models.File, team *models.Team, userPlan string,
+	content *models.FileContent) (int64, error) {
+
+	previous := getCurrentVersion(file)
+	newNumber := previous.Number + 1
+
+	if err := handler.Models.File.ReplaceContent(file.Id, file.Version, newNumber, *content); err != nil {
+		_ = handler.releaseContent(ctx, content)
+		_ = handler.returnStorage(file.OwnerId, file.TeamId, content.Size)
+		return 0, err
+	}
+
+	if _, err := handler.Models.FileVersion.Create(file.Id, previous.Number, previous.FileContent); err != nil {
+		// the previous content can`t be kept without its version, the file gets it back instead
+		if rollbackErr := handler.Models.File.ReplaceContent(file.Id, newNumber, file.Version, file.FileContent); rollbackErr != nil {
+			// the new content is the current one now, it is kept and the previous one is left in the storage
+			slog.Error("restoring previous file content", "file_id", file.Id.Hex(), "version", previous.Number,
+				"address", previous.Address, "error", rollbackErr)
+
+			return 0, fmt.Errorf("creating file version: %w", err)
+		}
+
+		_ = handler.releaseContent(ctx, content)
+		_ = handler.returnStorage(file.OwnerId, file.TeamId, content.Size)
+		return 0, fmt.Errorf("creating file version: %w", err)
+	}
+
+	maxVersions := utils.GetUserMaxFileVersions(userPlan)
+	if team != nil {
+		maxVersions = utils.GetTeamMaxFileVersions(team.Plan)
+	}
+
+	if err := handler.pruneFileVersions(ctx, file, maxVersions); err != nil {
+		slog.Error("pruning file versions", "file_id", file.Id.Hex(), "error", err)
+	}
+
+	return newNumber, nil
+}
+
+// pruneFileVersions -> Removes the oldest versions above the plan`s limit and gives their space back
+func (handler *Handler) pruneFileVersions(ctx context.Context, file *models.File, maxVersions int) error {
+	filter := bson.M{
+		"file_id": file.Id,
+	}
+
+	projection := bson.M{
+		"address":  1,
+		"checksum": 1,
+		"size":     1,
+	}
+
+	versions, err := handler.Models.FileVersion.GetAll(filter, projection)
+	if err != nil {
+		return err
+	}
+
+	if len(versions) <= maxVersions {
+		return nil
+	}
+
+	var freedSize int64
+	for _, version := range versions[maxVersions:] {
+		if err := handler.removeFileVersion(ctx, &version); err != nil {
+			slog.Error("removing file version", "id", version.Id.Hex(), "error", err)
+			continue
+		}
+
+		freedSize += version.Size
+	}
+
+	return handler.returnStorage(file.OwnerId, file.TeamId, freedSize)
+}
+
+// removeFileVersions -> Removes every previous version of the file, returns the freed size
+func (handler *Handler) removeFileVersions(ctx context.Context, fileId primitive.ObjectID) (int64, error) {
+	filter := bson.M{
+		"file_id": fileId,
+	}
+
+	projection := bson.M{
+		"address":  1,
+		"checksum": 1,
+		"size":     1,
+	}
+
+	versions, err := handler.Models.FileVersion.GetAll(filter, projection)
+	if err != nil {
+		return 0, err
+	}
+
+	var freedSize int64
+	for _, version := range versions {
+		if err := handler.removeFileVersion(ctx, &version); err != nil {
+			return freedSize, err
+		}
+
+		freedSize += version.Size
+	}
+
+	return freedSize, nil
+}
+
+// removeFileVersion -> The version is deleted before its content is released, so a retried removal can`t release
+// the content twice. A failed release leaves the bytes behind instead
+func (handler *Handler) removeFileVersion(ctx context.Context, version *models.FileVersion) error {
+	if err := handler.Models.FileVersion.Delete(version.Id); err != nil {
+		return err
+	}
+
+	if err := handler.releaseContent(ctx, &version.FileContent); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		slog.Error("releasing file version content", "id", version.Id.Hex(), "error", err)
+	}
+
+	return nil
+}
+
+// copyContent -> Returns the same content with a new reference
+func (handler *Handler) copyContent(ctx context.Context, content *models.FileContent) (*models.FileContent, error) {
+	if content.Checksum != "" {
+		if _, err := handler.Models.Blob.AddExistingReference(content.Checksum); err != nil {
+			return nil, err
+		}
+
+		contentCopy := *content
+		return &contentCopy, nil
+	}
+
+	// files uploaded before deduplication own their object, so it is stored again as a blob
+	object, _, err := handler.openContent(ctx, content)
+	if err != nil {
+		return nil, err
+	}
+
+	defer object.Close()
+
+	blob, err := handler.storeBlob(ctx, object)
+	if err != nil {
+		return nil, err
+	}
+
+	return newFileContent(blob, content.OriginalName, content.ContentType), nil
+}
+
+// getVersionedFile -> Only the owner (and the users it is shared with, with the permission) can manage the versions of
+// a user file, and the members of a team file. team is nil for user files
+func (handler *Handler) getVersionedFile(r *http.Request, userId primitive.ObjectID, permission string) (*models.File, *models.Team, error) {
+	fileId, err := utils.ParseIdParam(r.Context())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fileObjectId, err := utils.ToObjectID(fileId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	filter := bson.M{
+		"_id":        fileObjectId,
+		"deleted_at": bson.M{"$exists": false},
+	}
+
+	file, err := handler.Models.File.Get(filter, bson.M{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if file.TeamId == primitive.NilObjectID {
+		if _, err := handler.checkFileShareAccess(userId, file, permission); err != nil {
+			return nil, nil, fmt.Errorf("only the file owner can manage its versions: %w", err)
+		}
+
+		return file, nil, nil
+	}
+
+	team, err := handler.getTeamForUpload(file.TeamId, userId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return file, team, nil
+}
+
+func (handler *Handler) getFileVersion(file *models.File, number int64) (*models.FileVersion, error) {
+	if current := getCurrentVersion(file); number == current.Number {
+		return &current, nil
+	}
+
+	filter := bson.M{
+		"file_id": file.Id,
+		"number":  number,
+	}
+
+	return handler.Models.FileVersion.Get(filter, bson.M{})
+}
+
+// getCurrentVersion -> Files uploaded before versioning are at version 1
+func getCurrentVersion(file *models.File) models.FileVersion {
+	current := models.FileVersion{
+		FileId:      file.Id,
+		Number:      max(file.Version, 1),
+		FileContent: file.FileContent,
+	}
+
+	if current.UploaderId == primitive.NilObjectID {
+		current.UploaderId = file.OwnerId
+	}
+
+	if current.UploadedAt.IsZero() {
+		current.UploadedAt = file.CreatedAt
+	}
+
+	return current
+}
@@ -1,21 +1,37 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"file_manager/database/models"
+	"file_manager/storage"
 	"file_manager/utils"
 	"fmt"
 	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"io"
+	"log/slog"
+	"mime"
 	"net/http"
-	"os"
 	"path"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// downloadReservationLifetime -> A reservation stops counting after this duration, in case its download never finishes
+// (the server crashed). Downloads running longer are still counted when they finish
+const downloadReservationLifetime = 6 * time.Hour
+
+// contentUrlLifetime -> How long the content urls GetFile issues stay valid
+const contentUrlLifetime = 10 * time.Minute
+
+var userFileAllowedTypes = []string{"image/jpeg", "image/png", "application/zip", "application/pdf"}
+
 func (handler *Handler) UploadUserFile(w http.ResponseWriter, r *http.Request) {
 	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
 	if err != nil {
@@ -25,12 +41,8 @@ func (handler *Handler) UploadUserFile(w http.ResponseWriter, r *http.Request) {
 
 	maxUploadSize := utils.GetUserMaxUploadSize(payload.UserPlan)
 
-	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
-
-	fileName := r.FormValue("file_name")
-	if fileName == "" {
-		fileName = uuid.New().String()
-	}
+	// extra megabyte for the multipart boundaries and the plain fields
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize+MegaBytes)
 
 	userObjectId, err := utils.ToObjectID(payload.UserId)
 	if err != nil {
@@ -38,37 +50,43 @@ func (handler *Handler) UploadUserFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	folderObjectId, err := getFolderId(r)
+	content, err := handler.storeUserFile(r, maxUploadSize, userObjectId, payload.UserPlan)
 	if err != nil {
 		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	if err := handler.ValidateFolderId(folderObjectId, userObjectId); err != nil {
-		utils.WriteError(w, http.StatusBadRequest, err)
-		return
+	// plain fields are parsed while streaming the body, so they are available only after the file is stored
+	fileName := r.FormValue("file_name")
+	if fileName == "" {
+		fileName = content.OriginalName
+	}
+
+	if fileName == "" {
+		fileName = uuid.New().String()
 	}
 
-	uploadDir := getUserUploadDir(payload.UserId)
+	// no teamId for user uploaded files
+	teamId := primitive.NilObjectID
 
-	fileAddress, totalUserUploadSize, err := handler.storeUserFile(r, maxUploadSize, payload.UserId, payload.UserPlan, uploadDir)
+	folderObjectId, err := getFolderId(r)
 	if err != nil {
+		handler.discardUpload(content, userObjectId, teamId)
 		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	expireAt := utils.GetUserExpirationDate(payload.UserPlan)
-
-	// no teamId for user uploaded files
-	teamId := primitive.NilObjectID
-	if _, err := handler.Models.File.Create(userObjectId, teamId, folderObjectId, fileName, fileAddress, expireAt); err != nil {
-		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("creating file instance: %w", err))
+	if err := handler.ValidateFolderId(folderObjectId, userObjectId); err != nil {
+		handler.discardUpload(content, userObjectId, teamId)
+		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	updates := bson.M{"total_upload_size": totalUserUploadSize}
-	if err := handler.Models.User.Update(userObjectId, updates); err != nil {
-		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("updating user instance: %w", err))
+	expireAt := utils.GetUserExpirationDate(payload.UserPlan)
+
+	if _, err := handler.Models.File.Create(userObjectId, teamId, folderObjectId, fileName, *content, expireAt); err != nil {
+		handler.discardUpload(content, userObjectId, teamId)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("creating file instance: %w", err))
 		return
 	}
 
@@ -106,12 +124,14 @@ func (handler *Handler) GetFiles(w http.ResponseWriter, r *http.Request) {
 		}
 
 		filter = bson.M{
-			"team_id": teamObjectId,
+			"team_id":    teamObjectId,
+			"deleted_at": bson.M{"$exists": false},
 		}
 	} else {
 		filter = bson.M{
-			"owner_id": userObjectId,
-			"team_id":  primitive.NilObjectID,
+			"owner_id":   userObjectId,
+			"team_id":    primitive.NilObjectID,
+			"deleted_at": bson.M{"$exists": false},
 		}
 	}
 
@@ -121,15 +141,15 @@ func (handler *Handler) GetFiles(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	shortUrls := map[string]any{}
-
-	for _, file := range files {
-		shortUrls[file.Id.Hex()] = handler.getFileShortUrl(file.Id)
+	shareLinks, err := handler.getShareLinks(files)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
 	}
 
 	response := map[string]any{
-		"files":     files,
-		"shortUrls": shortUrls,
+		"files":       files,
+		"share_links": shareLinks,
 	}
 
 	utils.WriteJSONData(w, response)
@@ -161,12 +181,13 @@ func (handler *Handler) DeleteFile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	filter := bson.M{
-		"_id": fileObjectId,
+		"_id":        fileObjectId,
+		"deleted_at": bson.M{"$exists": false},
 	}
 
 	projection := bson.M{
-		"address":  1,
 		"owner_id": 1,
+		"team_id":  1,
 	}
 
 	fileInstance, err := handler.Models.File.Get(filter, projection)
@@ -180,26 +201,19 @@ func (handler *Handler) DeleteFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := utils.DeleteFileFromDisk(fileInstance.Address); err != nil {
-		utils.WriteError(w, http.StatusBadRequest, err)
-		return
-	}
-
-	if err := handler.Models.File.Delete(fileObjectId); err != nil {
+	// the file stays in the trash (and counts against the quota) until it is purged
+	purgeAt, err := handler.getTrashPurgeDate(fileInstance.TeamId, payload.UserPlan)
+	if err != nil {
 		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	filter = bson.M{
-		"file_id": fileObjectId,
-	}
-
-	if err := handler.Models.FileSettings.Delete(filter); err != nil {
+	if _, err := handler.Models.File.Trash(filter, time.Now(), purgeAt); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	utils.WriteJSON(w, "file deleted successfully")
+	utils.WriteJSON(w, "file moved to the trash")
 }
 
 func (handler *Handler) RenameFile(w http.ResponseWriter, r *http.Request) {
@@ -228,11 +242,14 @@ func (handler *Handler) RenameFile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	filter := bson.M{
-		"_id": fileObjectId,
+		"_id":        fileObjectId,
+		"deleted_at": bson.M{"$exists": false},
 	}
 
 	projection := bson.M{
-		"owner_id": 1,
+		"owner_id":  1,
+		"team_id":   1,
+		"folder_id": 1,
 	}
 
 	file, err := handler.Models.File.Get(filter, projection)
@@ -241,8 +258,9 @@ func (handler *Handler) RenameFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if file.OwnerId != userObjectId {
-		utils.WriteError(w, http.StatusBadRequest, "only the file owner can rename it")
+	// the owner, or the users the file is shared with for writing
+	if _, err := handler.checkFileShareAccess(userObjectId, file, models.SharePermissionWrite); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("only the file owner can rename it: %w", err))
 		return
 	}
 
@@ -272,6 +290,223 @@ func (handler *Handler) RenameFile(w http.ResponseWriter, r *http.Request) {
 	utils.WriteJSON(w, "file`s name changed successfully")
 }
 
+// MoveFile -> Moves the file into another folder, and into another team or the personal space. Its space (with the
+// versions) is charged to the new team or user and given back to the previous one
+func (handler *Handler) MoveFile(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	userObjectId, err := utils.ToObjectID(payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var input struct {
+		TeamId   string `json:"team_id"`   // empty for the personal space
+		FolderId string `json:"folder_id"` // empty for the root
+	}
+
+	if err := utils.ParseJSON(r.Body, 1000, &input); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	file, err := handler.getSourceFile(r, userObjectId, true)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	team, folderObjectId, err := handler.getTargetSpace(userObjectId, input.TeamId, input.FolderId, 0)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	filter := bson.M{
+		"_id":        file.Id,
+		"team_id":    file.TeamId,
+		"deleted_at": bson.M{"$exists": false},
+	}
+
+	updates := bson.M{
+		"folder_id": folderObjectId,
+	}
+
+	teamObjectId := getTeamId(team)
+	if teamObjectId == file.TeamId {
+		if _, err := handler.Models.File.Move(filter, updates, time.Time{}); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		utils.WriteJSON(w, "file moved successfully")
+		return
+	}
+
+	size, err := handler.getFileSize(r.Context(), file)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	versionsSize, err := handler.Models.FileVersion.GetTotalSize(bson.M{"file_id": file.Id})
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	size += versionsSize
+
+	if err := handler.chargeStorage(userObjectId, team, payload.UserPlan, size); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	updates["team_id"] = teamObjectId
+
+	moved, err := handler.Models.File.Move(filter, updates, getExpirationDate(team, payload.UserPlan))
+	if err != nil || moved == 0 {
+		_ = handler.returnStorage(userObjectId, teamObjectId, size)
+
+		if err == nil {
+			err = errors.New("the file has been changed in the meantime, try again")
+		}
+
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := handler.returnStorage(file.OwnerId, file.TeamId, size); err != nil {
+		slog.Error("returning storage of moved file", "id", file.Id.Hex(), "error", err)
+	}
+
+	utils.WriteJSON(w, "file moved successfully")
+}
+
+// CopyFile -> Copies the current content of the file into a folder of any space the user can upload to.
+// The copy shares the stored bytes with the original, only its size is charged
+func (handler *Handler) CopyFile(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	userObjectId, err := utils.ToObjectID(payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var input struct {
+		TeamId   string `json:"team_id"`   // empty for the personal space
+		FolderId string `json:"folder_id"` // empty for the root
+		Name     string `json:"name"`      // the original name by default
+	}
+
+	if err := utils.ParseJSON(r.Body, 1000, &input); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	file, err := handler.getSourceFile(r, userObjectId, false)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	team, folderObjectId, err := handler.getTargetSpace(userObjectId, input.TeamId, input.FolderId, 0)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if input.Name != "" {
+		file.Name = input.Name
+	}
+
+	size, err := handler.getFileSize(r.Context(), file)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := handler.chargeStorage(userObjectId, team, payload.UserPlan, size); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	teamObjectId := getTeamId(team)
+
+	newFileId, err := handler.copyFile(r.Context(), file, userObjectId, teamObjectId, folderObjectId, getExpirationDate(team, payload.UserPlan))
+	if err != nil {
+		_ = handler.returnStorage(userObjectId, teamObjectId, size)
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	utils.WriteJSONData(w, map[string]any{"id": newFileId})
+}
+
+// copyFile -> Creates a file referring to the same content, its space must be charged already
+func (handler *Handler) copyFile(ctx context.Context, file *models.File, userId, teamId, folderId primitive.ObjectID,
+	expireAt time.Time) (primitive.ObjectID, error) {
+
+	content, err := handler.copyContent(ctx, &file.FileContent)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	newFileId, err := handler.Models.File.Create(userId, teamId, folderId, file.Name, *content, expireAt)
+	if err != nil {
+		_ = handler.releaseContent(context.Background(), content)
+		return primitive.NilObjectID, fmt.Errorf("creating file instance: %w", err)
+	}
+
+	return newFileId, nil
+}
+
+// getSourceFile -> Returns the (not trashed) file of the id param. A team file needs a membership in the team,
+// a user file (or any file which is moved) needs the ownership
+func (handler *Handler) getSourceFile(r *http.Request, userId primitive.ObjectID, moving bool) (*models.File, error) {
+	fileId, err := utils.ParseIdParam(r.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	fileObjectId, err := utils.ToObjectID(fileId)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{
+		"_id":        fileObjectId,
+		"deleted_at": bson.M{"$exists": false},
+	}
+
+	file, err := handler.Models.File.Get(filter, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	if (moving || file.TeamId == primitive.NilObjectID) && file.OwnerId != userId {
+		return nil, errors.New("only the file owner can do this")
+	}
+
+	if file.TeamId != primitive.NilObjectID {
+		if _, err := handler.getTeamForUpload(file.TeamId, userId); err != nil {
+			return nil, err
+		}
+	}
+
+	return file, nil
+}
+
 func (handler *Handler) SearchFiles(w http.ResponseWriter, r *http.Request) {
 	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
 	if err != nil {
@@ -309,6 +544,7 @@ func (handler *Handler) SearchFiles(w http.ResponseWriter, r *http.Request) {
 		"name": bson.M{
 			"$regex": input.SearchText, "$options": "i", // case insensitive
 		},
+		"deleted_at": bson.M{"$exists": false},
 	}
 
 	// Search through files names
@@ -327,112 +563,408 @@ func (handler *Handler) SearchFiles(w http.ResponseWriter, r *http.Request) {
 	utils.WriteJSON(w, data)
 }
 
-func (handler *Handler) storeUserFile(r *http.Request, maxUploadSize int64, userId, userPlan, uploadDir string) (string, int64, error) {
-	allowedTypes := []string{"image/jpeg", "image/png", "application/zip", "application/pdf"}
-
-	file, err := utils.ReadFile(r, maxUploadSize, allowedTypes)
+// storeUserFile -> Stores the uploaded file and reserves its size in the user`s storage.
+// The content must be discarded (see discardUpload) if its file can`t be created
+func (handler *Handler) storeUserFile(r *http.Request, maxUploadSize int64, userId primitive.ObjectID, userPlan string) (*models.FileContent, error) {
+	remainedStorage, err := handler.getUserRemainedStorage(userId.Hex(), userPlan)
 	if err != nil {
-		return "", 0, err
+		return nil, err
 	}
 
-	defer file.File.Close()
-
-	totalUsedStorage, err := handler.IsUserEligibleToUpload(userId, userPlan, file.Size)
+	// the size is not known before streaming, so the stream itself is limited
+	file, err := utils.ReadFile(r, min(maxUploadSize, remainedStorage), userFileAllowedTypes)
 	if err != nil {
-		return "", 0, err
+		return nil, err
 	}
 
-	fileAddress, err := file.UploadToDisk(uploadDir)
+	blob, err := handler.storeBlob(r.Context(), file.File)
 	if err != nil {
-		return "", 0, err
+		if errors.Is(err, utils.ErrFileTooLarge) {
+			return nil, fmt.Errorf("your file exceeds either your plan's max upload size (%d bytes) or your remaining storage (%d bytes)",
+				maxUploadSize, remainedStorage)
+		}
+
+		return nil, err
+	}
+
+	if err := file.ReadRemainingFields(); err != nil {
+		_ = handler.releaseBlob(context.Background(), blob.Id)
+		return nil, err
+	}
+
+	// each owner is charged for the logical size, even if the bytes are shared with another file.
+	// Another upload may have used the remaining storage meanwhile, the reservation fails then
+	if err := handler.chargeStorage(userId, nil, userPlan, blob.Size); err != nil {
+		_ = handler.releaseBlob(context.Background(), blob.Id)
+		return nil, err
 	}
 
-	return fileAddress, totalUsedStorage, nil
+	return newFileContent(blob, file.Name, file.ContentType), nil
 }
 
+// DownloadFile -> Supports Range (single and multipart), If-None-Match, If-Range and If-Modified-Since.
+// Every served byte counts against the link: MaxDownloads allows MaxDownloads * file size bytes, and
+// current_download_amount is the number of whole downloads these bytes amount to (rounded up).
+// So resuming or splitting a download into ranges costs the same as one full download, and 304 responses are free.
+// It is checked like GetFile (the password is sent in the body of the POST requests).
+// The view-only links are served by PreviewFile only
 func (handler *Handler) DownloadFile(w http.ResponseWriter, r *http.Request) {
-	shortUrlStr, err := utils.ParseIdParam(r.Context())
+	projection := bson.M{
+		"short_url":               1,
+		"max_downloads":           1,
+		"current_download_amount": 1,
+		"downloaded_bytes":        1,
+		"view_only":               1,
+	}
+
+	settingInstance, fileInstance, status, err := handler.getSharedFile(r, projection, getServedFileProjection())
 	if err != nil {
-		utils.WriteError(w, http.StatusBadRequest, err)
+		utils.WriteError(w, status, err)
 		return
 	}
 
-	filter := bson.M{
-		"short_url": shortUrlStr,
+	if settingInstance.ViewOnly {
+		utils.WriteError(w, http.StatusForbidden, "this file can only be viewed")
+		return
 	}
 
+	handler.serveLinkedFile(w, r, settingInstance, fileInstance, "attachment")
+}
+
+// PreviewFile -> Streams the file inline, for the view-only links (and the others). It is checked like GetFile
+// (the password is sent in the body of the POST requests), counted like DownloadFile and never cached
+func (handler *Handler) PreviewFile(w http.ResponseWriter, r *http.Request) {
 	projection := bson.M{
-		"file_id":                 1,
+		"short_url":               1,
 		"max_downloads":           1,
 		"current_download_amount": 1,
+		"downloaded_bytes":        1,
 	}
 
-	settingInstance, err := handler.Models.FileSettings.Get(filter, projection)
+	settingInstance, fileInstance, status, err := handler.getSharedFile(r, projection, getServedFileProjection())
+	if err != nil {
+		utils.WriteError(w, status, err)
+		return
+	}
+
+	setPreviewHeaders(w)
+	handler.serveLinkedFile(w, r, settingInstance, fileInstance, "inline")
+}
+
+// serveLinkedFile -> Serves the file of a link with the disposition (attachment or inline), counting the served bytes
+func (handler *Handler) serveLinkedFile(w http.ResponseWriter, r *http.Request, settingInstance *models.FileSettings,
+	fileInstance *models.File, disposition string) {
+
+	file, fileSize, err := handler.openContent(r.Context(), &fileInstance.FileContent)
 	if err != nil {
 		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	// -1 means unlimited downloads
+	defer file.Close()
+
+	// -1 means unlimited downloads. The bytes are reserved before streaming, so parallel downloads can`t go past the limit
+	var reservationId primitive.ObjectID
 	if settingInstance.MaxDownloads != -1 {
-		if settingInstance.CurrentDownloadAmount+1 > settingInstance.MaxDownloads {
-			utils.WriteError(w, http.StatusBadRequest, "you have exceed you maximum downloads amount")
+		reservationId = primitive.NewObjectID()
+		expireAt := time.Now().Add(downloadReservationLifetime)
+
+		if err := handler.Models.FileSettings.ReserveDownload(settingInstance.Id, reservationId, getRequestedBytes(r, fileSize),
+			fileSize, expireAt); err != nil {
+
+			utils.WriteError(w, http.StatusBadRequest, err)
 			return
 		}
 	}
 
-	filter = bson.M{
-		"_id": settingInstance.FileId,
+	servedBytes := writeContent(w, r, fileInstance, file, disposition)
+
+	if reservationId == primitive.NilObjectID && servedBytes == 0 {
+		return
 	}
 
-	projection = bson.M{
-		"address": 1,
+	// an aborted download gives its reservation back as well, only the bytes served until then are counted.
+	// So the client can resume it with a Range request, and the whole file still costs one download
+	if err := handler.Models.FileSettings.FinishDownload(settingInstance.Id, reservationId, servedBytes, fileSize); err != nil {
+		slog.Error("updating download amount", "short_url", settingInstance.ShortUrl, "error", err)
+	}
+}
+
+// writeContent -> Sends the content with the disposition (attachment or inline), returns the amount of content bytes
+// actually written
+func writeContent(w http.ResponseWriter, r *http.Request, fileInstance *models.File, content io.ReadSeeker, disposition string) int64 {
+	contentType := fileInstance.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream" // files uploaded before the content type was stored
+	}
+
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": getDownloadName(fileInstance)}))
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", getETag(fileInstance))
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	// ServeContent sets the Content-Length (of the whole file or of the requested ranges)
+	countingWriter := &countingResponseWriter{ResponseWriter: w}
+	http.ServeContent(countingWriter, r, fileInstance.Name, getModifiedAt(fileInstance), content)
+
+	return countingWriter.Count
+}
+
+// setPreviewHeaders -> The previews are neither cached (by the browser or the proxies) nor sniffed into another type,
+// and the sandbox keeps an inline document from running scripts
+func setPreviewHeaders(w http.ResponseWriter) {
+	w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate, private")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Expires", "0")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+}
+
+// getServedFileProjection -> What serving a file needs
+func getServedFileProjection() bson.M {
+	return bson.M{
+		"name":          1,
+		"address":       1,
+		"checksum":      1,
+		"content_type":  1,
+		"original_name": 1,
+		"extension":     1,
+		"expire_at":     1,
+		"created_at":    1,
+		"uploaded_at":   1,
+	}
+}
+
+// getRequestedBytes -> The length of a single requested range, the whole size otherwise. Multiple ranges
+// and If-Range (which may turn into a full response) reserve the whole size
+func getRequestedBytes(r *http.Request, size int64) int64 {
+	rangeHeader := r.Header.Get("Range")
+	if rangeHeader == "" || r.Header.Get("If-Range") != "" {
+		return size
 	}
 
-	fileAddress, err := handler.Models.File.Get(filter, projection)
+	spec, found := strings.CutPrefix(rangeHeader, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return size
+	}
+
+	startStr, endStr, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return size
+	}
+
+	// suffix range: the last n bytes
+	if startStr == "" {
+		suffixLength, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || suffixLength < 0 {
+			return size
+		}
+
+		return min(suffixLength, size)
+	}
+
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return size
+	}
+
+	end := size - 1
+	if endStr != "" {
+		end, err = strconv.ParseInt(endStr, 10, 64)
+		if err != nil || end < start {
+			return size
+		}
+
+		end = min(end, size-1)
+	}
+
+	return end - start + 1
+}
+
+// getDownloadName -> The file`s name, with the original extension if the name does not have one
+func getDownloadName(file *models.File) string {
+	name := file.Name
+	if name == "" {
+		name = file.OriginalName
+	}
+
+	if path.Ext(name) == "" {
+		name += file.Extension
+	}
+
+	return name
+}
+
+// getModifiedAt -> When the current content was uploaded (a new version or a restored one), the creation time for
+// the files uploaded before the versions
+func getModifiedAt(file *models.File) time.Time {
+	if file.UploadedAt.IsZero() {
+		return file.CreatedAt
+	}
+
+	return file.UploadedAt
+}
+
+// getETag -> A file`s content changes with its versions, the checksum identifies the current one (file id for the
+// older files)
+func getETag(file *models.File) string {
+	if file.Checksum != "" {
+		return fmt.Sprintf("\"%s\"", file.Checksum)
+	}
+
+	return fmt.Sprintf("\"%s\"", file.Id.Hex())
+}
+
+// countingResponseWriter -> Counts the content bytes (200 and 206 responses only) which are actually written to the client
+type countingResponseWriter struct {
+	http.ResponseWriter
+	Count  int64
+	status int
+}
+
+func (writer *countingResponseWriter) WriteHeader(status int) {
+	writer.status = status
+	writer.ResponseWriter.WriteHeader(status)
+}
+
+func (writer *countingResponseWriter) Write(p []byte) (int, error) {
+	if writer.status == 0 {
+		writer.status = http.StatusOK
+	}
+
+	n, err := writer.ResponseWriter.Write(p)
+	if writer.status == http.StatusOK || writer.status == http.StatusPartialContent {
+		writer.Count += int64(n)
+	}
+
+	return n, err
+}
+
+// GetFile -> Returns One: a short-lived content url, issued once all the checks passed (the storage address is never exposed).
+// The view-only links return the preview url instead
+func (handler *Handler) GetFile(w http.ResponseWriter, r *http.Request) {
+	projection := bson.M{
+		"short_url": 1,
+		"view_only": 1,
+	}
+
+	fileProjection := bson.M{
+		"name":          1,
+		"content_type":  1,
+		"original_name": 1,
+		"extension":     1,
+	}
+
+	fileShareSettings, file, status, err := handler.getSharedFile(r, projection, fileProjection)
 	if err != nil {
-		utils.WriteError(w, http.StatusBadRequest, err)
+		utils.WriteError(w, status, err)
 		return
 	}
 
-	file, err := os.Open(fileAddress.Address)
+	if fileShareSettings.ViewOnly {
+		response := map[string]any{
+			"view_only":    true,
+			"file_name":    getDownloadName(file),
+			"content_type": file.ContentType,
+			"preview_url":  "/api/file/preview/" + fileShareSettings.ShortUrl,
+		}
+
+		utils.WriteJSONData(w, response)
+		return
+	}
+
+	contentToken, err := handler.PasetoMaker.CreateContentToken(fileShareSettings.ShortUrl, file.Id.Hex(), contentUrlLifetime)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	response := map[string]any{
+		"view_only":    false,
+		"file_name":    getDownloadName(file),
+		"content_type": file.ContentType,
+		"content_url":  "/api/file/content/" + contentToken,
+	}
+
+	utils.WriteJSONData(w, response)
+}
+
+// ServeFileContent -> Streams the file of a content url issued by GetFile, inline (or as an attachment with ?download=1).
+// The link is looked up again, so a deleted, expired or exhausted link (or one made view-only since) stops serving at once
+func (handler *Handler) ServeFileContent(w http.ResponseWriter, r *http.Request) {
+	contentToken := httprouter.ParamsFromContext(r.Context()).ByName("token")
+
+	payload, err := handler.PasetoMaker.VerifyContentToken(contentToken)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	fileObjectId, err := utils.ToObjectID(payload.FileId)
 	if err != nil {
 		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	defer file.Close()
+	filter := bson.M{
+		"short_url": payload.ShortUrl,
+		"file_id":   fileObjectId,
+	}
 
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", path.Base(file.Name())))
-	w.Header().Set("Content-Type", "application/octet-stream")
+	projection := bson.M{
+		"short_url":               1,
+		"file_id":                 1,
+		"max_downloads":           1,
+		"current_download_amount": 1,
+		"downloaded_bytes":        1,
+		"view_only":               1,
+		"expiration_at":           1,
+	}
 
-	if _, err := io.Copy(w, file); err != nil {
+	settingInstance, err := handler.Models.FileSettings.Get(filter, projection)
+	if err != nil {
 		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	updates := bson.M{
-		"current_download_amount": settingInstance.CurrentDownloadAmount + 1,
+	if settingInstance.ViewOnly {
+		utils.WriteError(w, http.StatusForbidden, "this file can only be viewed")
+		return
+	}
+
+	filter = bson.M{
+		"_id":        fileObjectId,
+		"deleted_at": bson.M{"$exists": false},
 	}
 
-	if err := handler.Models.FileSettings.Update(settingInstance.Id, updates); err != nil {
+	fileInstance, err := handler.Models.File.Get(filter, getServedFileProjection())
+	if err != nil {
 		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	utils.WriteJSON(w, "download finished successfully")
-}
+	if err := checkExpiration(settingInstance, fileInstance); err != nil {
+		utils.WriteError(w, http.StatusGone, err)
+		return
+	}
+
+	disposition := "inline"
+	if r.URL.Query().Get("download") == "1" {
+		disposition = "attachment"
+	}
 
-func getUserUploadDir(userId string) string {
-	return "uploads/user_files/" + userId + "/files/"
+	// the url is a bearer credential, the responses must not outlive it in a cache
+	setPreviewHeaders(w)
+	handler.serveLinkedFile(w, r, settingInstance, fileInstance, disposition)
 }
 
-// GetFile -> Returns One
-func (handler *Handler) GetFile(w http.ResponseWriter, r *http.Request) {
+// getSharedFile -> Returns the link`s settings and its file (with the extra projected fields), once the link`s
+// expiration, password and approval are checked, with the status to respond with otherwise.
+// The password is sent in the body of the POST requests
+func (handler *Handler) getSharedFile(r *http.Request, projection, fileProjection bson.M) (*models.FileSettings, *models.File, int, error) {
 	shortUrl, err := utils.ParseIdParam(r.Context())
 	if err != nil {
-		utils.WriteError(w, http.StatusBadRequest, err)
-		return
+		return nil, nil, http.StatusBadRequest, err
 	}
 
 	var providedPassword string
@@ -442,8 +974,7 @@ func (handler *Handler) GetFile(w http.ResponseWriter, r *http.Request) {
 		}
 
 		if err := utils.ParseJSON(r.Body, 1000, &input); err != nil {
-			utils.WriteError(w, http.StatusBadRequest, err)
-			return
+			return nil, nil, http.StatusBadRequest, err
 		}
 
 		providedPassword = input.Password
@@ -453,32 +984,32 @@ func (handler *Handler) GetFile(w http.ResponseWriter, r *http.Request) {
 		"short_url": shortUrl,
 	}
 
-	projection := bson.M{
-		"file_id":         1,
-		"approvable":      1,
-		"salt":            1,
-		"hashed_password": 1,
-	}
+	projection["file_id"] = 1
+	projection["approvable"] = 1
+	projection["salt"] = 1
+	projection["hashed_password"] = 1
+	projection["expiration_at"] = 1
 
 	fileShareSettings, err := handler.Models.FileSettings.Get(filter, projection)
 	if err != nil {
-		utils.WriteError(w, http.StatusBadRequest, err)
-		return
+		return nil, nil, http.StatusBadRequest, err
 	}
 
 	filter = bson.M{
-		"_id": fileShareSettings.FileId,
+		"_id":        fileShareSettings.FileId,
+		"deleted_at": bson.M{"$exists": false},
 	}
 
-	projection = bson.M{
-		"owner_id": 1,
-		"address":  1,
-	}
+	fileProjection["owner_id"] = 1
+	fileProjection["expire_at"] = 1
 
-	file, err := handler.Models.File.Get(filter, projection)
+	file, err := handler.Models.File.Get(filter, fileProjection)
 	if err != nil {
-		utils.WriteError(w, http.StatusBadRequest, err)
-		return
+		return nil, nil, http.StatusBadRequest, err
+	}
+
+	if err := checkExpiration(fileShareSettings, file); err != nil {
+		return nil, nil, http.StatusGone, err
 	}
 
 	var requesterId primitive.ObjectID
@@ -487,9 +1018,8 @@ func (handler *Handler) GetFile(w http.ResponseWriter, r *http.Request) {
 		requesterId, _ = utils.ToObjectID(payload.UserId)
 	}
 
-	if err := checkPasswordAccess(file.OwnerId, requesterId, providedPassword, fileShareSettings); err != nil {
-		utils.WriteError(w, http.StatusNotAcceptable, err)
-		return
+	if err := handler.checkPasswordAccess(file.OwnerId, requesterId, providedPassword, fileShareSettings); err != nil {
+		return nil, nil, http.StatusNotAcceptable, err
 	}
 
 	filter = bson.M{
@@ -506,33 +1036,44 @@ func (handler *Handler) GetFile(w http.ResponseWriter, r *http.Request) {
 
 		approvalInstance, err := handler.Models.Approval.Get(filter, projection)
 		if errors.Is(err, mongo.ErrNoDocuments) {
-			utils.WriteError(w, http.StatusPreconditionRequired, "approval required")
-			return
+			return nil, nil, http.StatusPreconditionRequired, errors.New("approval required")
 		}
 
 		if err != nil {
-			utils.WriteError(w, http.StatusBadRequest, err)
-			return
+			return nil, nil, http.StatusBadRequest, err
 		}
 
 		if approvalInstance.Status == "rejected" {
-			utils.WriteError(w, http.StatusBadRequest, "Your approval request has been rejected.")
-			return
+			return nil, nil, http.StatusBadRequest, errors.New("Your approval request has been rejected.")
 		}
 
 		if approvalInstance.Status == "pending" {
-			utils.WriteError(w, http.StatusBadRequest, "Your approval request is in pending. Please be patient")
-			return
+			return nil, nil, http.StatusBadRequest, errors.New("Your approval request is in pending. Please be patient")
 		}
 
 	}
 
-	utils.WriteJSONData(w, map[string]any{"file_address": file.Address})
-	return
+	return fileShareSettings, file, http.StatusOK, nil
+}
+
+// checkExpiration -> Expired links and files are rejected even if the expiration sweeper has not removed them yet
+func checkExpiration(fileSettings *models.FileSettings, file *models.File) error {
+	now := time.Now()
+
+	if !fileSettings.ExpireAt.IsZero() && now.After(fileSettings.ExpireAt) {
+		return errors.New("this link has expired")
+	}
+
+	if !file.ExpireAt.IsZero() && now.After(file.ExpireAt) {
+		return errors.New("this file has expired")
+	}
+
+	return nil
 }
 
-func checkPasswordAccess(ownerId, requesterId primitive.ObjectID, rawPassword string, fileSettings *models.FileSettings) error {
-	if ownerId == requesterId || requesterId == primitive.NilObjectID {
+func (handler *Handler) checkPasswordAccess(ownerId, requesterId primitive.ObjectID, rawPassword string, fileSettings *models.FileSettings) error {
+	// the anonymous requests need the password too, only the owner is let through
+	if requesterId != primitive.NilObjectID && ownerId == requesterId {
 		return nil
 	}
 
@@ -544,25 +1085,57 @@ func checkPasswordAccess(ownerId, requesterId primitive.ObjectID, rawPassword st
 		return errors.New("password is required")
 	}
 
-	return utils.CheckFilePassword(
-		[]byte(fileSettings.HashedPassword),
-		[]byte(fileSettings.Salt),
-		[]byte(rawPassword),
-	)
+	needsRehash, err := utils.CheckPassword(rawPassword, fileSettings.HashedPassword, fileSettings.Salt)
+	if err != nil {
+		return err
+	}
+
+	if needsRehash {
+		handler.rehashPassword(rawPassword, fileSettings.Id, handler.Models.FileSettings.Update)
+	}
+
+	return nil
 }
 
-func (handler *Handler) getFileShortUrl(fileId primitive.ObjectID) string {
-	filter := bson.M{
-		"file_id": fileId,
+// ServeStaticFile -> Streams the avatars (of the users and the teams) from the storage backend.
+// Nothing else is served from here, the files are only reachable through the checked handlers
+func (handler *Handler) ServeStaticFile(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	key := strings.TrimPrefix(params.ByName("filepath"), "/")
+
+	if !isAvatarKey(key) {
+		http.NotFound(w, r)
+		return
 	}
 
-	projection := bson.M{
-		"short_url": 1,
+	object, _, err := handler.openObject(r.Context(), key, nil)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	defer object.Close()
+
+	// ServeContent handles the content-type and the ranges
+	http.ServeContent(w, r, path.Base(key), time.Time{}, object)
+}
+
+// isAvatarKey -> Whether the key is one of getUserAvatarUploadDir or of the team avatars dir (uploadAvatar), "*" stops at "/"
+func isAvatarKey(key string) bool {
+	if path.Clean(key) != key {
+		return false
 	}
 
-	setting, err := handler.Models.FileSettings.Get(filter, projection)
-	if errors.Is(err, mongo.ErrNoDocuments) {
-		return ""
+	for _, pattern := range []string{"uploads/user_files/*/avatar/*", "uploads/team_files/avatars/*/*"} {
+		if matched, _ := path.Match(pattern, key); matched {
+			return true
+		}
 	}
-	return setting.ShortUrl
+
+	return false
 }
@@ -0,0 +1,334 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"file_manager/database/models"
+	"file_manager/utils"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestDownloadFileConcurrentLimit -> N+k parallel downloads of a max_downloads=N link: exactly N of them are served
+func TestDownloadFileConcurrentLimit(t *testing.T) {
+	const maxDownloads, extraDownloads = 5, 20
+
+	handler, _ := newTestHandler(t)
+	server := newDownloadServer(handler)
+	defer server.Close()
+
+	content := make([]byte, 256<<10)
+	rand.Read(content)
+
+	shortUrl := createSharedFile(t, handler, content, maxDownloads, "")
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	statuses := make([]int, maxDownloads+extraDownloads)
+
+	for i := range statuses {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			<-start
+
+			status, body := download(t, server.URL+"/api/file/download/"+shortUrl, "")
+			if status == http.StatusOK && !bytes.Equal(body, content) {
+				t.Errorf("download %d: served %d bytes, not the file", i, len(body))
+			}
+
+			statuses[i] = status
+		}()
+	}
+
+	close(start)
+	wg.Wait()
+
+	var served int
+	for _, status := range statuses {
+		if status == http.StatusOK {
+			served++
+		}
+	}
+
+	if served != maxDownloads {
+		t.Fatalf("served %d downloads of a max_downloads=%d link (statuses %v)", served, maxDownloads, statuses)
+	}
+
+	settings := getFileSettings(t, handler, shortUrl)
+	if settings.DownloadedBytes != maxDownloads*int64(len(content)) || settings.CurrentDownloadAmount != maxDownloads {
+		t.Errorf("counted %d bytes and %d downloads, want %d and %d", settings.DownloadedBytes, settings.CurrentDownloadAmount,
+			maxDownloads*len(content), maxDownloads)
+	}
+
+	if len(settings.DownloadReservations) != 0 {
+		t.Errorf("%d reservations are left behind", len(settings.DownloadReservations))
+	}
+
+	if status, _ := download(t, server.URL+"/api/file/download/"+shortUrl, ""); status == http.StatusOK {
+		t.Error("the link is served past its limit")
+	}
+}
+
+// TestDownloadFileResume -> An interrupted download counts only its served bytes, so its rest can still be
+// downloaded on a max_downloads=1 link, and nothing more
+func TestDownloadFileResume(t *testing.T) {
+	handler, _ := newTestHandler(t)
+	server := newDownloadServer(handler)
+	defer server.Close()
+
+	content := make([]byte, 64<<10)
+	rand.Read(content)
+
+	shortUrl := createSharedFile(t, handler, content, 1, "")
+	downloadUrl := server.URL + "/api/file/download/" + shortUrl
+
+	status, head := download(t, downloadUrl, "bytes=0-0")
+	if status != http.StatusPartialContent || len(head) != 1 {
+		t.Fatalf("first byte: status %d, %d bytes", status, len(head))
+	}
+
+	status, rest := download(t, downloadUrl, "bytes=1-")
+	if status != http.StatusPartialContent || !bytes.Equal(append(head, rest...), content) {
+		t.Fatalf("resume: status %d, %d bytes", status, len(rest))
+	}
+
+	if status, _ := download(t, downloadUrl, ""); status == http.StatusOK {
+		t.Error("the link is served past its limit")
+	}
+}
+
+// TestDownloadFilePassword -> The password of the link is required from anonymous requests too, in the POST body
+func TestDownloadFilePassword(t *testing.T) {
+	handler, _ := newTestHandler(t)
+	server := newDownloadServer(handler)
+	defer server.Close()
+
+	content := []byte("protected content")
+	downloadUrl := server.URL + "/api/file/download/" + createSharedFile(t, handler, content, -1, "secret")
+
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		status int
+	}{
+		{"no password", http.MethodGet, "", http.StatusNotAcceptable},
+		{"empty password", http.MethodPost, `{"password":""}`, http.StatusNotAcceptable},
+		{"wrong password", http.MethodPost, `{"password":"guess"}`, http.StatusNotAcceptable},
+		{"password", http.MethodPost, `{"password":"secret"}`, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request, err := http.NewRequest(test.method, downloadUrl, strings.NewReader(test.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer response.Body.Close()
+
+			body, _ := io.ReadAll(response.Body)
+			if response.StatusCode != test.status {
+				t.Fatalf("status %d (%s), want %d", response.StatusCode, body, test.status)
+			}
+
+			if test.status == http.StatusOK && !bytes.Equal(body, content) {
+				t.Errorf("served %q, not the file", body)
+			}
+		})
+	}
+}
+
+// TestGetRequestedBytes -> What a download reserves of the link`s allowance: the length of a single range, the whole
+// size for anything the server may answer with more than it
+func TestGetRequestedBytes(t *testing.T) {
+	const size = 1000
+
+	tests := []struct {
+		name      string
+		byteRange string
+		ifRange   string
+		want      int64
+	}{
+		{"no range", "", "", size},
+		{"first byte", "bytes=0-0", "", 1},
+		{"from the start", "bytes=0-499", "", 500},
+		{"resume", "bytes=600-", "", 400},
+		{"end past the size", "bytes=900-5000", "", 100},
+		{"last byte", "bytes=999-", "", 1},
+		{"suffix", "bytes=-300", "", 300},
+		{"suffix longer than the size", "bytes=-5000", "", size},
+		{"start past the size", "bytes=1000-", "", size},
+		{"end before the start", "bytes=500-400", "", size},
+		{"multiple ranges", "bytes=0-9,20-29", "", size},
+		{"If-Range", "bytes=600-", `"checksum"`, size},
+		{"other unit", "items=0-9", "", size},
+		{"invalid start", "bytes=a-9", "", size},
+		{"invalid end", "bytes=0-b", "", size},
+		{"negative suffix", "bytes=--5", "", size},
+		{"no dash", "bytes=5", "", size},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.byteRange != "" {
+				r.Header.Set("Range", test.byteRange)
+			}
+
+			if test.ifRange != "" {
+				r.Header.Set("If-Range", test.ifRange)
+			}
+
+			if got := getRequestedBytes(r, size); got != test.want {
+				t.Errorf("getRequestedBytes(%q) = %d, want %d", test.byteRange, got, test.want)
+			}
+		})
+	}
+}
+
+// TestWriteContentLastModified -> A new version of the file is served again to the clients which cached the first one
+func TestWriteContentLastModified(t *testing.T) {
+	createdAt := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	uploadedAt := createdAt.Add(24 * time.Hour)
+
+	tests := []struct {
+		name            string
+		uploadedAt      time.Time
+		ifModifiedSince time.Time
+		wantStatus      int
+		wantModified    time.Time
+	}{
+		{"new version, cached before it", uploadedAt, createdAt, http.StatusOK, uploadedAt},
+		{"new version, cached after it", uploadedAt, uploadedAt, http.StatusNotModified, uploadedAt},
+		{"file without versions", time.Time{}, createdAt, http.StatusNotModified, createdAt},
+		{"file without versions, cached before it", time.Time{}, createdAt.Add(-time.Hour), http.StatusOK, createdAt},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fileInstance := &models.File{
+				Id:          primitive.NewObjectID(),
+				Name:        "report.txt",
+				CreatedAt:   createdAt,
+				FileContent: models.FileContent{ContentType: "text/plain", UploadedAt: test.uploadedAt},
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("If-Modified-Since", test.ifModifiedSince.Format(http.TimeFormat))
+			w := httptest.NewRecorder()
+
+			writeContent(w, r, fileInstance, strings.NewReader("content"), "attachment")
+
+			if w.Code != test.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, test.wantStatus)
+			}
+
+			// a 304 does not repeat the Last-Modified of the ETag tagged responses
+			if lastModified := w.Header().Get("Last-Modified"); w.Code == http.StatusOK && lastModified != test.wantModified.Format(http.TimeFormat) {
+				t.Errorf("Last-Modified = %s, want %s", lastModified, test.wantModified.Format(http.TimeFormat))
+			}
+		})
+	}
+}
+
+func newDownloadServer(handler *Handler) *httptest.Server {
+	router := httprouter.New()
+	router.HandlerFunc("GET", "/api/file/download/:id", handler.DownloadFile)
+	router.HandlerFunc("POST", "/api/file/download/:id", handler.DownloadFile)
+
+	return httptest.NewServer(router)
+}
+
+// createSharedFile -> Stores the content as a file of a new user, shared by a link without approval (and without
+// password when it is empty)
+func createSharedFile(t *testing.T, handler *Handler, content []byte, maxDownloads int64, password string) string {
+	t.Helper()
+
+	ownerId := primitive.NewObjectID()
+	address := fmt.Sprintf("uploads/user_files/%s/%s", ownerId.Hex(), rand.Text())
+
+	if err := handler.Storage.Put(context.Background(), address, bytes.NewReader(content), int64(len(content))); err != nil {
+		t.Fatal(err)
+	}
+
+	fileContent := models.FileContent{
+		Address:     address,
+		Size:        int64(len(content)),
+		ContentType: "application/octet-stream",
+	}
+
+	fileId, err := handler.Models.File.Create(ownerId, primitive.NilObjectID, primitive.NilObjectID, "test.bin", fileContent,
+		time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var hashedPassword string
+	if password != "" {
+		if hashedPassword, err = utils.HashPassword(password); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	shortUrl := rand.Text()
+	if err := handler.Models.FileSettings.Create(fileId, ownerId, shortUrl, "", "", hashedPassword, maxDownloads, false, false,
+		time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	return shortUrl
+}
+
+func getFileSettings(t *testing.T, handler *Handler, shortUrl string) *models.FileSettings {
+	t.Helper()
+
+	settings, err := handler.Models.FileSettings.Get(bson.M{"short_url": shortUrl}, bson.M{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return settings
+}
+
+func download(t *testing.T, url, byteRange string) (int, []byte) {
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Error(err)
+		return 0, nil
+	}
+
+	if byteRange != "" {
+		request.Header.Set("Range", byteRange)
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Error(err)
+		return 0, nil
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Error(err)
+	}
+
+	return response.StatusCode, body
+}
@@ -0,0 +1,590 @@
+package handlers
+
+import (
+	"errors"
+	"file_manager/database/models"
+	"file_manager/utils"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// sharedItem -> What a folder link exposes of a subfolder or a file
+type sharedItem struct {
+	Id          primitive.ObjectID `json:"id"`
+	Name        string             `json:"name"`
+	Size        int64              `json:"size,omitempty"`
+	ContentType string             `json:"content_type,omitempty"`
+	CreatedAt   time.Time          `json:"created_at,omitzero"`
+}
+
+// CreateFolderSettings -> Creates a share link for a folder, with the same options as the file links
+func (handler *Handler) CreateFolderSettings(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	userObjectId, err := utils.ToObjectID(payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	folderId, err := utils.ParseIdParam(r.Context())
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	folderObjectId, err := utils.ToObjectID(folderId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	filter := bson.M{
+		"_id":        folderObjectId,
+		"deleted_at": bson.M{"$exists": false},
+	}
+
+	projection := bson.M{
+		"_id":      1,
+		"owner_id": 1,
+	}
+
+	folder, err := handler.Models.Folder.Get(filter, projection)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if folder.OwnerId != userObjectId {
+		utils.WriteError(w, http.StatusBadRequest, "only the folder owner can create settings(shortUrl) for it")
+		return
+	}
+
+	label := r.FormValue("label")
+
+	hashedPassword, salt, err := getPasswordAndSalt(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	approvable, err := getApproval(r, payload.UserPlan)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	viewOnly, err := getViewOnly(r, payload.UserPlan)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	maxDownloads, err := getMaxDownloads(r, payload.UserPlan)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	expireAt, err := getExpireAt(r, payload.UserPlan)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	folderShortUrl, err := uuid.NewUUID()
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("failed generating folder short url: %s", err))
+		return
+	}
+
+	if err := handler.Models.FolderSettings.Create(folderObjectId, userObjectId, folderShortUrl.String(), label, salt, hashedPassword,
+		maxDownloads, viewOnly, approvable, expireAt); err != nil {
+
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("creating folder share setting instance: %w", err))
+		return
+	}
+
+	data := map[string]string{
+		"short_url": folderShortUrl.String(),
+	}
+
+	utils.WriteJSONData(w, data)
+}
+
+func (handler *Handler) GetFoldersSettings(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	userObjectId, err := utils.ToObjectID(payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	filter := bson.M{
+		"user_id": userObjectId,
+	}
+
+	folders, err := handler.Models.FolderSettings.GetAll(filter, getShareLinkProjection())
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	response := map[string]any{
+		"sharedUrls": folders,
+	}
+
+	utils.WriteJSONData(w, response)
+}
+
+// UpdateFolderSettings -> Like UpdateFileSettings, reset_downloads=true resets the download counter
+func (handler *Handler) UpdateFolderSettings(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	settingObjectId, err := handler.getOwnFolderSettingId(r, payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	updates, err := getShareLinkUpdates(r, payload.UserPlan)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	resetDownloads, err := getBoolFormValue(r, "reset_downloads")
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if resetDownloads {
+		updates["current_download_amount"] = 0
+		updates["downloaded_amount"] = 0
+	}
+
+	if len(updates) == 0 {
+		utils.WriteError(w, http.StatusBadRequest, "nothing to update")
+		return
+	}
+
+	if err := handler.Models.FolderSettings.Update(settingObjectId, updates); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	utils.WriteJSON(w, "setting updated successfully")
+}
+
+func (handler *Handler) DeleteFolderSettings(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	settingObjectId, err := handler.getOwnFolderSettingId(r, payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	filter := bson.M{
+		"_id": settingObjectId,
+	}
+
+	if _, err := handler.Models.FolderSettings.DeleteAll(filter); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	utils.WriteJSON(w, "setting deleted successfully")
+}
+
+// GetSharedFolder -> Lists a shared folder (or one of its subfolders with the folder_id query param)
+func (handler *Handler) GetSharedFolder(w http.ResponseWriter, r *http.Request) {
+	projection := bson.M{
+		"label":     1,
+		"view_only": 1,
+	}
+
+	settings, sharedFolder, status, err := handler.getSharedFolder(r, projection)
+	if err != nil {
+		utils.WriteError(w, status, err)
+		return
+	}
+
+	folderId := sharedFolder.Id
+	if folderIdStr := r.URL.Query().Get("folder_id"); folderIdStr != "" {
+		folderId, err = utils.ToObjectID(folderIdStr)
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	path, err := handler.getSharedFolderPath(sharedFolder.Id, folderId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	filter := bson.M{
+		"_id":        folderId,
+		"deleted_at": bson.M{"$exists": false},
+	}
+
+	projection = bson.M{
+		"_id": 1,
+	}
+
+	if _, err := handler.Models.Folder.Get(filter, projection); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	page, pageSize, err := utils.GetPaginationParams(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	filter = bson.M{
+		"folder_id":  folderId,
+		"deleted_at": bson.M{"$exists": false},
+	}
+
+	files, err := handler.Models.File.GetAll(filter, page, pageSize)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	filter = bson.M{
+		"parent_id":  folderId,
+		"deleted_at": bson.M{"$exists": false},
+	}
+
+	folders, err := handler.Models.Folder.GetAll(filter, page, pageSize)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	sharedFiles := make([]sharedItem, 0, len(files))
+	for _, file := range files {
+		sharedFiles = append(sharedFiles, sharedItem{
+			Id:          file.Id,
+			Name:        getDownloadName(&file),
+			Size:        file.Size,
+			ContentType: file.ContentType,
+			CreatedAt:   file.CreatedAt,
+		})
+	}
+
+	sharedPath := make([]sharedItem, 0, len(path))
+	for _, folder := range path {
+		sharedPath = append(sharedPath, sharedItem{
+			Id:   folder.Id,
+			Name: folder.Name,
+		})
+	}
+
+	sharedFolders := make([]sharedItem, 0, len(folders))
+	for _, folder := range folders {
+		sharedFolders = append(sharedFolders, sharedItem{
+			Id:        folder.Id,
+			Name:      folder.Name,
+			CreatedAt: folder.CreatedAt,
+		})
+	}
+
+	response := map[string]any{
+		"label":     settings.Label,
+		"view_only": settings.ViewOnly,
+		"folder_id": folderId,
+		"path":      sharedPath,
+		"folders":   sharedFolders,
+		"files":     sharedFiles,
+	}
+
+	utils.WriteJSONData(w, response)
+}
+
+// DownloadSharedFile -> Downloads a file of the shared folder (or of its subfolders), like DownloadFile.
+// The file counts as one download of the link (a part of it as the matching fraction)
+func (handler *Handler) DownloadSharedFile(w http.ResponseWriter, r *http.Request) {
+	handler.serveSharedFolderFile(w, r, false)
+}
+
+// PreviewSharedFile -> Streams a file of the shared folder inline, like PreviewFile. It is counted like DownloadSharedFile,
+// but also allowed for the view-only links
+func (handler *Handler) PreviewSharedFile(w http.ResponseWriter, r *http.Request) {
+	handler.serveSharedFolderFile(w, r, true)
+}
+
+// serveSharedFolderFile -> Serves a file of the shared folder, inline (the previews) or as an attachment
+func (handler *Handler) serveSharedFolderFile(w http.ResponseWriter, r *http.Request, inline bool) {
+	projection := bson.M{
+		"view_only":     1,
+		"max_downloads": 1,
+	}
+
+	settings, sharedFolder, status, err := handler.getSharedFolder(r, projection)
+	if err != nil {
+		utils.WriteError(w, status, err)
+		return
+	}
+
+	if settings.ViewOnly && !inline {
+		utils.WriteError(w, http.StatusForbidden, "the files of this link can only be viewed")
+		return
+	}
+
+	fileObjectId, err := utils.ToObjectID(httprouter.ParamsFromContext(r.Context()).ByName("file_id"))
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	filter := bson.M{
+		"_id":        fileObjectId,
+		"deleted_at": bson.M{"$exists": false},
+	}
+
+	projection = getServedFileProjection()
+	projection["folder_id"] = 1
+
+	fileInstance, err := handler.Models.File.Get(filter, projection)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, err := handler.getSharedFolderPath(sharedFolder.Id, fileInstance.FolderId); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !fileInstance.ExpireAt.IsZero() && time.Now().After(fileInstance.ExpireAt) {
+		utils.WriteError(w, http.StatusGone, "this file has expired")
+		return
+	}
+
+	file, fileSize, err := handler.openContent(r.Context(), &fileInstance.FileContent)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	defer file.Close()
+
+	// -1 means unlimited downloads
+	var reservationId primitive.ObjectID
+	if settings.MaxDownloads != -1 {
+		reservationId = primitive.NewObjectID()
+		expireAt := time.Now().Add(downloadReservationLifetime)
+
+		if err := handler.Models.FolderSettings.ReserveDownload(settings.Id, reservationId,
+			getDownloadFraction(getRequestedBytes(r, fileSize), fileSize), expireAt); err != nil {
+
+			utils.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	disposition := "attachment"
+	if inline {
+		disposition = "inline"
+		setPreviewHeaders(w)
+	}
+
+	servedBytes := writeContent(w, r, fileInstance, file, disposition)
+
+	if reservationId == primitive.NilObjectID && servedBytes == 0 {
+		return
+	}
+
+	if err := handler.Models.FolderSettings.FinishDownload(settings.Id, reservationId, getDownloadFraction(servedBytes, fileSize)); err != nil {
+		slog.Error("updating download amount", "short_url", settings.ShortUrl, "error", err)
+	}
+}
+
+// getSharedFolder -> Returns the link`s settings (with the extra projected fields) and the shared folder, once the link`s
+// expiration, password and approval are checked, with the status to respond with otherwise.
+// The password is sent in the body of the POST requests. Same as for the file links, only the owner can skip it
+func (handler *Handler) getSharedFolder(r *http.Request, projection bson.M) (*models.FolderSettings, *models.Folder, int, error) {
+	shortUrl, err := utils.ParseIdParam(r.Context())
+	if err != nil {
+		return nil, nil, http.StatusBadRequest, err
+	}
+
+	var providedPassword string
+	if r.Method == "POST" {
+		var input struct {
+			Password string `json:"password"`
+		}
+
+		if err := utils.ParseJSON(r.Body, 1000, &input); err != nil {
+			return nil, nil, http.StatusBadRequest, err
+		}
+
+		providedPassword = input.Password
+	}
+
+	filter := bson.M{
+		"short_url": shortUrl,
+	}
+
+	projection["short_url"] = 1
+	projection["folder_id"] = 1
+	projection["approvable"] = 1
+	projection["salt"] = 1
+	projection["hashed_password"] = 1
+	projection["expiration_at"] = 1
+
+	settings, err := handler.Models.FolderSettings.Get(filter, projection)
+	if err != nil {
+		return nil, nil, http.StatusBadRequest, err
+	}
+
+	if !settings.ExpireAt.IsZero() && time.Now().After(settings.ExpireAt) {
+		return nil, nil, http.StatusGone, errors.New("this link has expired")
+	}
+
+	filter = bson.M{
+		"_id":        settings.FolderId,
+		"deleted_at": bson.M{"$exists": false},
+	}
+
+	folderProjection := bson.M{
+		"_id":      1,
+		"owner_id": 1,
+	}
+
+	folder, err := handler.Models.Folder.Get(filter, folderProjection)
+	if err != nil {
+		return nil, nil, http.StatusBadRequest, err
+	}
+
+	var requesterId primitive.ObjectID
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err == nil {
+		requesterId, _ = utils.ToObjectID(payload.UserId)
+	}
+
+	if settings.HashedPassword != "" && folder.OwnerId != requesterId {
+		if providedPassword == "" {
+			return nil, nil, http.StatusNotAcceptable, errors.New("password is required")
+		}
+
+		needsRehash, err := utils.CheckPassword(providedPassword, settings.HashedPassword, settings.Salt)
+		if err != nil {
+			return nil, nil, http.StatusNotAcceptable, err
+		}
+
+		if needsRehash {
+			handler.rehashPassword(providedPassword, settings.Id, handler.Models.FolderSettings.Update)
+		}
+	}
+
+	if settings.Approvable {
+		filter = bson.M{
+			"folder_id": folder.Id,
+		}
+
+		if err := checkApprovalAccess(filter, folder.OwnerId, requesterId, handler); err != nil {
+			var approvalError *utils.ApprovalError
+
+			if errors.As(err, &approvalError) {
+				return nil, nil, http.StatusPreconditionRequired, errors.New(approvalError.Message)
+			}
+
+			return nil, nil, http.StatusBadRequest, err
+		}
+	}
+
+	return settings, folder, http.StatusOK, nil
+}
+
+// getSharedFolderPath -> Returns the path from the shared folder down to the folder, if the folder is inside the shared one
+func (handler *Handler) getSharedFolderPath(sharedFolderId, folderId primitive.ObjectID) ([]models.Folder, error) {
+	if folderId == primitive.NilObjectID {
+		return nil, errors.New("this item is not shared by this link")
+	}
+
+	path, err := handler.getFolderPath(folderId)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, folder := range path {
+		if folder.Id == sharedFolderId {
+			return path[i:], nil
+		}
+	}
+
+	return nil, errors.New("this item is not shared by this link")
+}
+
+// getOwnFolderSettingId -> The id param, if the user created this folder setting
+func (handler *Handler) getOwnFolderSettingId(r *http.Request, userId string) (primitive.ObjectID, error) {
+	userObjectId, err := utils.ToObjectID(userId)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	settingId, err := utils.ParseIdParam(r.Context())
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	settingObjectId, err := utils.ToObjectID(settingId)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	filter := bson.M{
+		"_id":     settingObjectId,
+		"user_id": userObjectId,
+	}
+
+	projection := bson.M{
+		"_id": 1,
+	}
+
+	if _, err := handler.Models.FolderSettings.Get(filter, projection); err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	return settingObjectId, nil
+}
+
+// getDownloadFraction -> The part of the file the bytes amount to, an empty file counts as a whole download
+func getDownloadFraction(bytes, size int64) float64 {
+	if size == 0 {
+		return 1
+	}
+
+	return float64(bytes) / float64(size)
+}
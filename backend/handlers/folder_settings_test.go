@@ -0,0 +1,27 @@
+package handlers
+
+import "testing"
+
+// TestGetDownloadFraction -> The part of a folder link`s max_downloads a download of the bytes reserves
+func TestGetDownloadFraction(t *testing.T) {
+	tests := []struct {
+		name  string
+		bytes int64
+		size  int64
+		want  float64
+	}{
+		{"whole file", 1000, 1000, 1},
+		{"half", 500, 1000, 0.5},
+		{"one byte", 1, 1000, 0.001},
+		{"nothing", 0, 1000, 0},
+		{"empty file", 0, 0, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := getDownloadFraction(test.bytes, test.size); got != test.want {
+				t.Errorf("getDownloadFraction(%d, %d) = %g, want %g", test.bytes, test.size, got, test.want)
+			}
+		})
+	}
+}
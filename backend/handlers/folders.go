@@ -1,16 +1,24 @@
 package handlers
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/json"
+	"errors"
+	"file_manager/database/models"
 	"file_manager/utils"
 	"fmt"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log/slog"
 	"net/http"
+	"slices"
 	"time"
 )
 
+// maxFolderDepth -> Folders can be nested at most this many levels deep
+const maxFolderDepth = 32
+
 func (handler *Handler) CreateFolder(w http.ResponseWriter, r *http.Request) {
 	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
 	if err != nil {
@@ -19,8 +27,9 @@ func (handler *Handler) CreateFolder(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var input struct {
-		TeamId string `json:"team_id"`
-		Name   string `json:"name"`
+		TeamId   string `json:"team_id"`
+		ParentId string `json:"parent_id"` // empty for a root folder
+		Name     string `json:"name"`
 	}
 
 	if err := utils.ParseJSON(r.Body, 1000, &input); err != nil {
@@ -47,7 +56,21 @@ func (handler *Handler) CreateFolder(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	if _, err := handler.Models.Folder.Create(userObjectId, teamObjectId, input.Name); err != nil {
+	var parentObjectId primitive.ObjectID
+	if input.ParentId != "" {
+		parentObjectId, err = utils.ToObjectID(input.ParentId)
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := handler.validateParentFolder(parentObjectId, userObjectId, teamObjectId, 1); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	if _, err := handler.Models.Folder.Create(userObjectId, teamObjectId, parentObjectId, input.Name); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
@@ -81,13 +104,15 @@ func (handler *Handler) GetFolderContents(w http.ResponseWriter, r *http.Request
 	}
 
 	filter := bson.M{
-		"_id":      folderObjectId,
-		"owner_id": userObjectId,
+		"_id":        folderObjectId,
+		"owner_id":   userObjectId,
+		"deleted_at": bson.M{"$exists": false},
 	}
 
 	projection := bson.M{
-		"_id":  1,
-		"name": 1,
+		"_id":       1,
+		"name":      1,
+		"parent_id": 1,
 	}
 
 	folder, err := handler.Models.Folder.Get(filter, projection)
@@ -103,7 +128,8 @@ func (handler *Handler) GetFolderContents(w http.ResponseWriter, r *http.Request
 	}
 
 	filter = bson.M{
-		"folder_id": folderObjectId,
+		"folder_id":  folderObjectId,
+		"deleted_at": bson.M{"$exists": false},
 	}
 
 	// getting the files
@@ -113,9 +139,23 @@ func (handler *Handler) GetFolderContents(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	filter = bson.M{
+		"parent_id":  folderObjectId,
+		"deleted_at": bson.M{"$exists": false},
+	}
+
+	// getting the subfolders
+	folders, err := handler.Models.Folder.GetAll(filter, page, pageSize)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
 	response := map[string]any{
 		"folder_id":   folderId,
 		"folder_name": folder.Name,
+		"parent_id":   folder.ParentId,
+		"folders":     folders,
 		"files":       files,
 	}
 
@@ -128,8 +168,32 @@ func (handler *Handler) ValidateFolderId(folderId, userId primitive.ObjectID) er
 	}
 
 	filter := bson.M{
-		"_id":      folderId,
-		"owner_id": userId,
+		"_id":        folderId,
+		"owner_id":   userId,
+		"deleted_at": bson.M{"$exists": false},
+	}
+
+	projection := bson.M{
+		"_id": 1,
+	}
+
+	if _, err := handler.Models.Folder.Get(filter, projection); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// validateTeamFolderId -> The folder must be a (not trashed) folder of the team, and the user a member of it
+func (handler *Handler) validateTeamFolderId(folderId, userId, teamId primitive.ObjectID) error {
+	if _, err := handler.getTeamForUpload(teamId, userId); err != nil {
+		return err
+	}
+
+	filter := bson.M{
+		"_id":        folderId,
+		"team_id":    teamId,
+		"deleted_at": bson.M{"$exists": false},
 	}
 
 	projection := bson.M{
@@ -169,15 +233,24 @@ func (handler *Handler) RenameFolder(w http.ResponseWriter, r *http.Request) {
 	}
 
 	filter := bson.M{
-		"_id":      folderObjectId,
-		"owner_id": userObjectId,
+		"_id":        folderObjectId,
+		"deleted_at": bson.M{"$exists": false},
 	}
 
 	projection := bson.M{
-		"_id": 1,
+		"_id":      1,
+		"owner_id": 1,
+		"team_id":  1,
 	}
 
-	if _, err := handler.Models.Folder.Get(filter, projection); err != nil {
+	folder, err := handler.Models.Folder.Get(filter, projection)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// the owner, or the users the folder is shared with for writing
+	if _, err := handler.checkFolderShareAccess(userObjectId, folder, models.SharePermissionWrite); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
@@ -230,12 +303,14 @@ func (handler *Handler) DeleteFolder(w http.ResponseWriter, r *http.Request) {
 	}
 
 	filter := bson.M{
-		"_id": folderObjectId,
+		"_id":        folderObjectId,
+		"deleted_at": bson.M{"$exists": false},
 	}
 
 	projection := bson.M{
 		"_id":      1,
 		"owner_id": 1,
+		"team_id":  1,
 	}
 
 	folderInstance, err := handler.Models.Folder.Get(filter, projection)
@@ -249,12 +324,42 @@ func (handler *Handler) DeleteFolder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := handler.Models.Folder.Delete(folderObjectId); err != nil {
+	purgeAt, err := handler.getTrashPurgeDate(folderInstance.TeamId, payload.UserPlan)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// the subfolders and the files are trashed with the same date, so restoring the folder restores exactly these
+	deletedAt := time.Now()
+
+	folderIds, _, err := handler.getFolderSubtree(folderObjectId, bson.M{"deleted_at": bson.M{"$exists": false}})
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	filter = bson.M{
+		"_id":        bson.M{"$in": folderIds},
+		"deleted_at": bson.M{"$exists": false},
+	}
+
+	if _, err := handler.Models.Folder.Trash(filter, deletedAt, purgeAt); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	filter = bson.M{
+		"folder_id":  bson.M{"$in": folderIds},
+		"deleted_at": bson.M{"$exists": false},
+	}
+
+	if _, err := handler.Models.File.Trash(filter, deletedAt, purgeAt); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	utils.WriteJSON(w, "folder deleted successfully")
+	utils.WriteJSON(w, "folder moved to the trash")
 }
 
 func (handler *Handler) GetFoldersList(w http.ResponseWriter, r *http.Request) {
@@ -281,15 +386,33 @@ func (handler *Handler) GetFoldersList(w http.ResponseWriter, r *http.Request) {
 		}
 
 		filter = bson.M{
-			"team_id": teamObjectId,
+			"team_id":    teamObjectId,
+			"deleted_at": bson.M{"$exists": false},
 		}
 	} else {
 		filter = bson.M{
-			"owner_id": userObjectId,
-			"team_id":  primitive.NilObjectID,
+			"owner_id":   userObjectId,
+			"team_id":    primitive.NilObjectID,
+			"deleted_at": bson.M{"$exists": false},
 		}
 	}
 
+	// optional: "root" for the top level folders, or a folder id for its subfolders
+	switch parentId := r.URL.Query().Get("parent_id"); parentId {
+	case "":
+	case "root":
+		// folders created before nesting have no parent_id
+		filter["parent_id"] = bson.M{"$in": bson.A{primitive.NilObjectID, nil}}
+	default:
+		parentObjectId, err := utils.ToObjectID(parentId)
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		filter["parent_id"] = parentObjectId
+	}
+
 	page, pageSize, err := utils.GetPaginationParams(r)
 	if err != nil {
 		utils.WriteError(w, http.StatusBadRequest, err)
@@ -311,6 +434,545 @@ func (handler *Handler) GetFoldersList(w http.ResponseWriter, r *http.Request) {
 	utils.WriteJSON(w, data)
 }
 
+// GetFolderPath -> Returns the folders from the root down to the given one (breadcrumb)
+func (handler *Handler) GetFolderPath(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	folderId, err := utils.ParseIdParam(r.Context())
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	folderObjectId, err := utils.ToObjectID(folderId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	userObjectId, err := utils.ToObjectID(payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := handler.ValidateFolderId(folderObjectId, userObjectId); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	path, err := handler.getFolderPath(folderObjectId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	utils.WriteJSONData(w, map[string]any{"path": path})
+}
+
+// MoveFolder -> Moves the folder (with its subfolders and files) under another folder or to the root, optionally into
+// another team or the personal space
+func (handler *Handler) MoveFolder(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	userObjectId, err := utils.ToObjectID(payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var input struct {
+		TeamId   string `json:"team_id"`   // empty for the personal space
+		ParentId string `json:"parent_id"` // empty for the root
+	}
+
+	if err := utils.ParseJSON(r.Body, 1000, &input); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	folder, err := handler.getSourceFolder(r, userObjectId, true)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// the trashed subfolders move too, so they can be restored in place
+	folderIds, subtreeHeight, err := handler.getFolderSubtree(folder.Id, bson.M{})
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	team, parentObjectId, err := handler.getTargetSpace(userObjectId, input.TeamId, input.ParentId, subtreeHeight)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if slices.Contains(folderIds, parentObjectId) {
+		utils.WriteError(w, http.StatusBadRequest, "a folder can`t be moved into itself or into its subfolders")
+		return
+	}
+
+	if getTeamId(team) != folder.TeamId {
+		if err := handler.changeFolderSpace(r.Context(), folder, folderIds, userObjectId, team, payload.UserPlan); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	if err := handler.Models.Folder.Move(folder.Id, parentObjectId); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	utils.WriteJSON(w, "folder moved successfully")
+}
+
+// CopyFolder -> Copies the folder with its (not trashed) subfolders and files under a folder of any space the user
+// can upload to. The copied files share the stored bytes with the originals
+func (handler *Handler) CopyFolder(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	userObjectId, err := utils.ToObjectID(payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var input struct {
+		TeamId   string `json:"team_id"`   // empty for the personal space
+		ParentId string `json:"parent_id"` // empty for the root
+	}
+
+	if err := utils.ParseJSON(r.Body, 1000, &input); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	folder, err := handler.getSourceFolder(r, userObjectId, false)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	folderIds, subtreeHeight, err := handler.getFolderSubtree(folder.Id, bson.M{"deleted_at": bson.M{"$exists": false}})
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	team, parentObjectId, err := handler.getTargetSpace(userObjectId, input.TeamId, input.ParentId, subtreeHeight)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if slices.Contains(folderIds, parentObjectId) {
+		utils.WriteError(w, http.StatusBadRequest, "a folder can`t be copied into itself or into its subfolders")
+		return
+	}
+
+	newFolderId, err := handler.copyFolderTree(r.Context(), folderIds, userObjectId, team, parentObjectId, payload.UserPlan)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	utils.WriteJSONData(w, map[string]any{"id": newFolderId})
+}
+
+// changeFolderSpace -> Moves the subtree (folders, files and their versions) into another team or the personal space.
+// Only a subtree made entirely by the user can leave its space, so nobody loses their files with it
+func (handler *Handler) changeFolderSpace(ctx context.Context, folder *models.Folder, folderIds []primitive.ObjectID,
+	userId primitive.ObjectID, team *models.Team, userPlan string) error {
+
+	filter := bson.M{
+		"_id":      bson.M{"$in": folderIds},
+		"owner_id": bson.M{"$ne": userId},
+	}
+
+	projection := bson.M{
+		"_id": 1,
+	}
+
+	foreignFolders, err := handler.Models.Folder.Find(filter, projection)
+	if err != nil {
+		return err
+	}
+
+	if len(foreignFolders) != 0 {
+		return errors.New("the folder contains folders of other users, it can only be moved within its space")
+	}
+
+	filter = bson.M{
+		"folder_id": bson.M{"$in": folderIds},
+	}
+
+	projection = bson.M{
+		"owner_id": 1,
+		"address":  1,
+		"checksum": 1,
+		"size":     1,
+	}
+
+	files, err := handler.Models.File.Find(filter, projection)
+	if err != nil {
+		return err
+	}
+
+	var size int64
+	fileIds := make([]primitive.ObjectID, 0, len(files))
+
+	for _, file := range files {
+		if file.OwnerId != userId {
+			return errors.New("the folder contains files of other users, it can only be moved within its space")
+		}
+
+		fileSize, err := handler.getFileSize(ctx, &file)
+		if err != nil {
+			return err
+		}
+
+		size += fileSize
+		fileIds = append(fileIds, file.Id)
+	}
+
+	versionsSize, err := handler.Models.FileVersion.GetTotalSize(bson.M{"file_id": bson.M{"$in": fileIds}})
+	if err != nil {
+		return err
+	}
+
+	size += versionsSize
+
+	if err := handler.chargeStorage(userId, team, userPlan, size); err != nil {
+		return err
+	}
+
+	teamId := getTeamId(team)
+
+	folderFilter := bson.M{
+		"_id": bson.M{"$in": folderIds},
+	}
+
+	if _, err := handler.Models.Folder.UpdateAll(folderFilter, bson.M{"team_id": teamId}); err != nil {
+		_ = handler.returnStorage(userId, teamId, size)
+		return err
+	}
+
+	fileFilter := bson.M{
+		"folder_id": bson.M{"$in": folderIds},
+	}
+
+	if _, err := handler.Models.File.Move(fileFilter, bson.M{"team_id": teamId}, getExpirationDate(team, userPlan)); err != nil {
+		// the folders go back, so the subtree stays in one space
+		_, _ = handler.Models.Folder.UpdateAll(folderFilter, bson.M{"team_id": folder.TeamId})
+		_ = handler.returnStorage(userId, teamId, size)
+		return err
+	}
+
+	if err := handler.returnStorage(userId, folder.TeamId, size); err != nil {
+		slog.Error("returning storage of moved folder", "id", folder.Id.Hex(), "error", err)
+	}
+
+	return nil
+}
+
+// copyFolderTree -> Copies the folders (ordered as returned by getFolderSubtree, so parents come before their subfolders)
+// and their not trashed files under parentId. Returns the id of the copied root folder
+func (handler *Handler) copyFolderTree(ctx context.Context, folderIds []primitive.ObjectID, userId primitive.ObjectID,
+	team *models.Team, parentId primitive.ObjectID, userPlan string) (primitive.ObjectID, error) {
+
+	filter := bson.M{
+		"folder_id":  bson.M{"$in": folderIds},
+		"deleted_at": bson.M{"$exists": false},
+	}
+
+	files, err := handler.Models.File.Find(filter, bson.M{})
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	// the whole copy is charged upfront, so it either fits into the quota or nothing is copied
+	sizes := make([]int64, len(files))
+	var totalSize int64
+
+	for i := range files {
+		sizes[i], err = handler.getFileSize(ctx, &files[i])
+		if err != nil {
+			return primitive.NilObjectID, err
+		}
+
+		totalSize += sizes[i]
+	}
+
+	if err := handler.chargeStorage(userId, team, userPlan, totalSize); err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	teamId := getTeamId(team)
+
+	filter = bson.M{
+		"_id": bson.M{"$in": folderIds},
+	}
+
+	projection := bson.M{
+		"_id":       1,
+		"name":      1,
+		"parent_id": 1,
+	}
+
+	folders, err := handler.Models.Folder.Find(filter, projection)
+	if err != nil {
+		_ = handler.returnStorage(userId, teamId, totalSize)
+		return primitive.NilObjectID, err
+	}
+
+	foldersById := make(map[primitive.ObjectID]models.Folder, len(folders))
+	for _, folder := range folders {
+		foldersById[folder.Id] = folder
+	}
+
+	copiedIds := make(map[primitive.ObjectID]primitive.ObjectID, len(folderIds))
+	for i, folderId := range folderIds {
+		folder, ok := foldersById[folderId]
+		if !ok {
+			continue
+		}
+
+		newParentId := parentId
+		if i != 0 {
+			// the parent was removed in the meantime
+			if newParentId, ok = copiedIds[folder.ParentId]; !ok {
+				continue
+			}
+		}
+
+		newFolderId, err := handler.Models.Folder.Create(userId, teamId, newParentId, folder.Name)
+		if err != nil {
+			_ = handler.returnStorage(userId, teamId, totalSize)
+			return copiedIds[folderIds[0]], err
+		}
+
+		copiedIds[folderId] = newFolderId
+	}
+
+	expireAt := getExpirationDate(team, userPlan)
+
+	for i, file := range files {
+		// its folder was removed in the meantime
+		newFolderId, ok := copiedIds[file.FolderId]
+		if !ok {
+			_ = handler.returnStorage(userId, teamId, sizes[i])
+			totalSize -= sizes[i]
+			continue
+		}
+
+		if _, err := handler.copyFile(ctx, &file, userId, teamId, newFolderId, expireAt); err != nil {
+			// the space of this file and of the ones not copied yet is given back
+			_ = handler.returnStorage(userId, teamId, totalSize)
+			return copiedIds[folderIds[0]], fmt.Errorf("copying file %s: %w", file.Name, err)
+		}
+
+		totalSize -= sizes[i]
+	}
+
+	return copiedIds[folderIds[0]], nil
+}
+
+// getSourceFolder -> Returns the (not trashed) folder of the id param. A team folder needs a membership in the team,
+// a user folder (or any folder which is moved) needs the ownership
+func (handler *Handler) getSourceFolder(r *http.Request, userId primitive.ObjectID, moving bool) (*models.Folder, error) {
+	folderId, err := utils.ParseIdParam(r.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	folderObjectId, err := utils.ToObjectID(folderId)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{
+		"_id":        folderObjectId,
+		"deleted_at": bson.M{"$exists": false},
+	}
+
+	projection := bson.M{
+		"_id":      1,
+		"owner_id": 1,
+		"team_id":  1,
+	}
+
+	folder, err := handler.Models.Folder.Get(filter, projection)
+	if err != nil {
+		return nil, err
+	}
+
+	if (moving || folder.TeamId == primitive.NilObjectID) && folder.OwnerId != userId {
+		return nil, errors.New("only the folder owner can do this")
+	}
+
+	if folder.TeamId != primitive.NilObjectID {
+		if _, err := handler.getTeamForUpload(folder.TeamId, userId); err != nil {
+			return nil, err
+		}
+	}
+
+	return folder, nil
+}
+
+// getTargetSpace -> Returns the team (nil for the personal space) and the folder (nil for the root) which an item is
+// moved or copied into. subtreeHeight is the height of a moved folder tree, 0 for files
+func (handler *Handler) getTargetSpace(userId primitive.ObjectID, teamId, folderId string, subtreeHeight int) (*models.Team, primitive.ObjectID, error) {
+	var team *models.Team
+	var teamObjectId primitive.ObjectID
+
+	if teamId != "" {
+		var err error
+		teamObjectId, err = utils.ToObjectID(teamId)
+		if err != nil {
+			return nil, primitive.NilObjectID, err
+		}
+
+		team, err = handler.getTeamForUpload(teamObjectId, userId)
+		if err != nil {
+			return nil, primitive.NilObjectID, err
+		}
+	}
+
+	if folderId == "" {
+		return team, primitive.NilObjectID, nil
+	}
+
+	folderObjectId, err := utils.ToObjectID(folderId)
+	if err != nil {
+		return nil, primitive.NilObjectID, err
+	}
+
+	if err := handler.validateParentFolder(folderObjectId, userId, teamObjectId, subtreeHeight); err != nil {
+		return nil, primitive.NilObjectID, err
+	}
+
+	return team, folderObjectId, nil
+}
+
+// validateParentFolder -> The parent must be a folder of the same space (user or team), and the subtree
+// of height subtreeHeight placed in it must not exceed the max depth
+func (handler *Handler) validateParentFolder(parentId, userId, teamId primitive.ObjectID, subtreeHeight int) error {
+	path, err := handler.getFolderPath(parentId)
+	if err != nil {
+		return err
+	}
+
+	if path[len(path)-1].TeamId != teamId {
+		return errors.New("the folder belongs to another space")
+	}
+
+	// the team folders may be created by any member, the membership is what counts
+	if teamId != primitive.NilObjectID {
+		err = handler.validateTeamFolderId(parentId, userId, teamId)
+	} else {
+		err = handler.ValidateFolderId(parentId, userId)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if len(path)+subtreeHeight > maxFolderDepth {
+		return fmt.Errorf("folders can be nested at most %d levels deep", maxFolderDepth)
+	}
+
+	return nil
+}
+
+// getFolderPath -> Returns the folder and its ancestors, from the root down to the folder
+func (handler *Handler) getFolderPath(folderId primitive.ObjectID) ([]models.Folder, error) {
+	var path []models.Folder
+
+	projection := bson.M{
+		"_id":       1,
+		"team_id":   1,
+		"name":      1,
+		"parent_id": 1,
+	}
+
+	for currentId := folderId; currentId != primitive.NilObjectID; {
+		if len(path) == maxFolderDepth {
+			return nil, errors.New("folder hierarchy is too deep")
+		}
+
+		filter := bson.M{
+			"_id": currentId,
+		}
+
+		folder, err := handler.Models.Folder.Get(filter, projection)
+		if err != nil {
+			return nil, err
+		}
+
+		path = append(path, *folder)
+		currentId = folder.ParentId
+	}
+
+	slices.Reverse(path)
+	return path, nil
+}
+
+// getFolderSubtree -> Returns the ids of the folder and of its subfolders (only the ones matching the filter),
+// and the height of the subtree (1 for a folder without subfolders)
+func (handler *Handler) getFolderSubtree(folderId primitive.ObjectID, filter bson.M) ([]primitive.ObjectID, int, error) {
+	folderIds := []primitive.ObjectID{folderId}
+	currentLevel := []primitive.ObjectID{folderId}
+
+	projection := bson.M{
+		"_id": 1,
+	}
+
+	height := 1
+	for ; height <= maxFolderDepth; height++ {
+		levelFilter := bson.M{
+			"parent_id": bson.M{"$in": currentLevel},
+		}
+
+		for key, value := range filter {
+			levelFilter[key] = value
+		}
+
+		children, err := handler.Models.Folder.Find(levelFilter, projection)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if len(children) == 0 {
+			return folderIds, height, nil
+		}
+
+		currentLevel = currentLevel[:0]
+		for _, child := range children {
+			currentLevel = append(currentLevel, child.Id)
+		}
+
+		folderIds = append(folderIds, currentLevel...)
+	}
+
+	return nil, 0, errors.New("folder hierarchy is too deep")
+}
+
 func getFolderId(r *http.Request) (primitive.ObjectID, error) {
 	folderId := r.FormValue("folder_id")
 	if folderId == "" {
@@ -324,3 +986,20 @@ func getFolderId(r *http.Request) (primitive.ObjectID, error) {
 
 	return folderObjectId, nil
 }
+
+func getTeamId(team *models.Team) primitive.ObjectID {
+	if team == nil {
+		return primitive.NilObjectID
+	}
+
+	return team.Id
+}
+
+// getExpirationDate -> Files in a team expire according to the team`s plan, the others according to the user`s one
+func getExpirationDate(team *models.Team, userPlan string) time.Time {
+	if team == nil {
+		return utils.GetUserExpirationDate(userPlan)
+	}
+
+	return utils.GetTeamExpirationDate(team.Plan)
+}
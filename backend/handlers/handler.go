@@ -2,6 +2,9 @@ package handlers
 
 import (
 	"file_manager/database/models"
+	"file_manager/encryption"
+	"file_manager/oidc"
+	"file_manager/storage"
 	"file_manager/token"
 )
 
@@ -10,18 +13,39 @@ type envelope map[string]any
 type Handler struct {
 	PasetoMaker *token.PasetoMaker
 	Models      *models.Models
+	Storage     storage.Backend
+	KeyRing     *encryption.KeyRing
+
+	OidcProviders map[string]*oidc.Provider // single sign-on, by name
 }
 
-func New(models *models.Models) (*Handler, error) {
+func New(models *models.Models, storage storage.Backend) (*Handler, error) {
 	paseto, err := token.New()
 	if err != nil {
 		return nil, err
 	}
 
+	keyRing, err := encryption.NewKeyRing()
+	if err != nil {
+		return nil, err
+	}
+
+	oidcProviders, err := oidc.LoadProviders()
+	if err != nil {
+		return nil, err
+	}
+
 	var handler = &Handler{
-		PasetoMaker: paseto,
-		Models:      models,
+		PasetoMaker:   paseto,
+		Models:        models,
+		Storage:       storage,
+		KeyRing:       keyRing,
+		OidcProviders: oidcProviders,
 	}
 
+	// the revoked tokens are rejected wherever they are verified
+	paseto.SetSessionChecker(handler.checkSession)
+	paseto.SetApiTokenVerifier(handler.verifyApiToken)
+
 	return handler, nil
 }
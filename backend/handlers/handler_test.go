@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"context"
+	"file_manager/database/models"
+	"file_manager/storage"
+	"file_manager/token"
+	"fmt"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"os"
+	"testing"
+	"time"
+)
+
+// newTestHandler -> A handler on a throwaway database of TEST_MONGO_URI (dropped at the end) and a temporary
+// local storage. The tests needing it are skipped when TEST_MONGO_URI is not set
+func newTestHandler(t *testing.T) (*Handler, *mongo.Database) {
+	t.Helper()
+
+	uri := os.Getenv("TEST_MONGO_URI")
+	if uri == "" {
+		t.Skip("TEST_MONGO_URI is not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("connecting to %s: %s", uri, err)
+	}
+
+	if err := client.Ping(ctx, nil); err != nil {
+		t.Fatalf("pinging %s: %s", uri, err)
+	}
+
+	db := client.Database(fmt.Sprintf("file_manager_test_%d", time.Now().UnixNano()))
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		_ = db.Drop(ctx)
+		_ = client.Disconnect(ctx)
+	})
+
+	t.Setenv("PASETO_SYMMETRIC_KEY", "test key")
+
+	paseto, err := token.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	localStorage, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := &Handler{
+		PasetoMaker: paseto,
+		Models:      models.New(db),
+		Storage:     localStorage,
+	}
+
+	return handler, db
+}
@@ -0,0 +1,348 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"file_manager/database/models"
+	"file_manager/oidc"
+	"file_manager/token"
+	"file_manager/utils"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	oidcStateLifetime  = 10 * time.Minute // time to log in at the provider
+	oidcTicketLifetime = time.Minute
+	oidcStateCookie    = "oidc_state"
+)
+
+// GetOidcProviders -> The single sign-on providers of the login page, and whether the password login is available
+func (handler *Handler) GetOidcProviders(w http.ResponseWriter, r *http.Request) {
+	providers := make([]string, 0, len(handler.OidcProviders))
+	for name := range handler.OidcProviders {
+		providers = append(providers, name)
+	}
+
+	slices.Sort(providers)
+
+	utils.WriteJSONData(w, map[string]any{"providers": providers, "password_login": !isPasswordLoginDisabled()})
+}
+
+// StartOidcLogin -> Sends the browser to the provider (authorization code flow with PKCE). The state, the nonce and
+// the code verifier are kept in a cookie for the callback. client_challenge is the S256 hash of a secret of the frontend,
+// the login ticket of the callback can only be exchanged with that secret. With a link_token (see CreateOidcLinkToken)
+// the identity is linked to its user
+func (handler *Handler) StartOidcLogin(w http.ResponseWriter, r *http.Request) {
+	provider, ok := handler.OidcProviders[httprouter.ParamsFromContext(r.Context()).ByName("provider")]
+	if !ok {
+		redirectOidcError(w, r, "unknown single sign-on provider")
+		return
+	}
+
+	clientChallenge := r.URL.Query().Get("client_challenge")
+	if len(clientChallenge) != 43 {
+		redirectOidcError(w, r, "'client_challenge' is invalid")
+		return
+	}
+
+	statePayload := &token.OidcStatePayload{
+		Provider:        provider.Name,
+		State:           oidc.RandomValue(),
+		Nonce:           oidc.RandomValue(),
+		CodeVerifier:    oidc.RandomValue(),
+		ClientChallenge: clientChallenge,
+	}
+
+	if linkToken := r.URL.Query().Get("link_token"); linkToken != "" {
+		linkPayload, err := handler.PasetoMaker.VerifyOidcLinkToken(linkToken)
+		if err != nil {
+			redirectOidcError(w, r, "single sign-on link has expired, please try again")
+			return
+		}
+
+		statePayload.LinkUserId = linkPayload.UserId
+	}
+
+	authUrl, err := provider.AuthCodeUrl(r.Context(), statePayload.State, statePayload.Nonce, oidc.CodeChallenge(statePayload.CodeVerifier))
+	if err != nil {
+		slog.Error("starting single sign-on", "provider", provider.Name, "error", err)
+		redirectOidcError(w, r, "single sign-on provider is unavailable")
+		return
+	}
+
+	stateToken, err := handler.PasetoMaker.CreateOidcStateToken(statePayload, oidcStateLifetime)
+	if err != nil {
+		redirectOidcError(w, r, "error starting single sign-on")
+		return
+	}
+
+	setOidcStateCookie(w, provider, stateToken, int(oidcStateLifetime.Seconds()))
+
+	http.Redirect(w, r, authUrl, http.StatusFound)
+}
+
+// OidcCallback -> Where the provider sends the browser back. Redeems the code, finds (links or creates) the user of
+// the verified ID token and sends the browser to the frontend with a login ticket (see ExchangeOidcTicket)
+func (handler *Handler) OidcCallback(w http.ResponseWriter, r *http.Request) {
+	provider, ok := handler.OidcProviders[httprouter.ParamsFromContext(r.Context()).ByName("provider")]
+	if !ok {
+		redirectOidcError(w, r, "unknown single sign-on provider")
+		return
+	}
+
+	// the state is used once whatever happens
+	setOidcStateCookie(w, provider, "", -1)
+
+	query := r.URL.Query()
+	if providerError := query.Get("error"); providerError != "" {
+		redirectOidcError(w, r, fmt.Sprintf("single sign-on failed: %s %s", providerError, query.Get("error_description")))
+		return
+	}
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		redirectOidcError(w, r, "single sign-on has expired, please log in again")
+		return
+	}
+
+	statePayload, err := handler.PasetoMaker.VerifyOidcStateToken(cookie.Value)
+	if err != nil {
+		redirectOidcError(w, r, "single sign-on has expired, please log in again")
+		return
+	}
+
+	if statePayload.Provider != provider.Name || subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(statePayload.State)) != 1 {
+		redirectOidcError(w, r, "invalid single sign-on state, please log in again")
+		return
+	}
+
+	claims, err := provider.Exchange(r.Context(), query.Get("code"), statePayload.CodeVerifier, statePayload.Nonce)
+	if err != nil {
+		slog.Error("single sign-on code exchange", "provider", provider.Name, "error", err)
+		redirectOidcError(w, r, "single sign-on failed, please try again")
+		return
+	}
+
+	var user *models.User
+	if statePayload.LinkUserId != "" {
+		user, err = handler.linkOidcUser(provider, claims, statePayload.LinkUserId)
+	} else {
+		user, err = handler.getOidcUser(provider, claims)
+	}
+
+	if err != nil {
+		redirectOidcError(w, r, err.Error())
+		return
+	}
+
+	ticket, err := handler.PasetoMaker.CreateOidcTicket(user.Id.Hex(), statePayload.ClientChallenge, oidcTicketLifetime)
+	if err != nil {
+		redirectOidcError(w, r, "error creating login ticket")
+		return
+	}
+
+	// the fragment is never sent to the servers (nor in the Referer)
+	http.Redirect(w, r, getFrontendUrl()+"/login#oidc_ticket="+url.QueryEscape(ticket), http.StatusFound)
+}
+
+// ExchangeOidcTicket -> The last step of single sign-on: the ticket and the frontend secret are exchanged for the session
+// (or for the two-factor challenge, like Login)
+func (handler *Handler) ExchangeOidcTicket(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Ticket         string `json:"ticket"`
+		ClientVerifier string `json:"client_verifier"`
+	}
+
+	if err := utils.ParseJSON(r.Body, 10000, &input); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ticket, err := handler.PasetoMaker.VerifyOidcTicket(input.Ticket)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, "invalid or expired login ticket")
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(oidc.CodeChallenge(input.ClientVerifier)), []byte(ticket.ClientChallenge)) != 1 {
+		utils.WriteError(w, http.StatusUnauthorized, "invalid or expired login ticket")
+		return
+	}
+
+	userObjectId, err := utils.ToObjectID(ticket.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	filter := bson.M{
+		"_id": userObjectId,
+	}
+
+	user, err := handler.Models.User.Get(filter, getLoginProjection())
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, "user does not exist")
+		return
+	}
+
+	handler.completeLogin(w, user)
+}
+
+// CreateOidcLinkToken -> The first step of linking a single sign-on identity to the logged-in user: the frontend
+// starts the single sign-on (StartOidcLogin) with the returned link_token. The accounts with a password are only
+// linked this way, never by their username (see getOidcUser)
+func (handler *Handler) CreateOidcLinkToken(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	linkToken, err := handler.PasetoMaker.CreateOidcLinkToken(payload.UserId, oidcTicketLifetime)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("error creating link token: %w", err))
+		return
+	}
+
+	utils.WriteJSONData(w, map[string]any{"link_token": linkToken})
+}
+
+// getOidcUser -> The user linked to the identity. Otherwise the password-less user whose username is the verified
+// link claim (linked from now on), or a new user (just-in-time provisioning). A user with a password has to link
+// the identity from its session, the claim alone must not be enough to take the account over
+func (handler *Handler) getOidcUser(provider *oidc.Provider, claims *oidc.Claims) (*models.User, error) {
+	filter := bson.M{
+		"oidc_identities": bson.M{"$elemMatch": bson.M{"provider": provider.Name, "subject": claims.Subject}},
+	}
+
+	user, err := handler.Models.User.Get(filter, getLoginProjection())
+	if err == nil {
+		return user, nil
+	}
+
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("fetch user: %w", err)
+	}
+
+	// an unverified claim could be anyone`s username, it would hand their account over
+	username, verified := claims.VerifiedClaim(provider.LinkClaim)
+	if !verified {
+		return nil, fmt.Errorf("the provider did not verify your '%s', the account can not be linked", provider.LinkClaim)
+	}
+
+	filter = bson.M{
+		"username": username,
+	}
+
+	projection := getLoginProjection()
+	projection["hashed_password"] = 1
+
+	user, err = handler.Models.User.Get(filter, projection)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		userId, createErr := handler.Models.User.Create(username, DefaultPlan, "", "")
+		if createErr != nil {
+			return nil, fmt.Errorf("creating user instance: %w", createErr)
+		}
+
+		slog.Info("user provisioned by single sign-on", "provider", provider.Name, "user_id", userId.Hex())
+
+		user, err = handler.Models.User.Get(bson.M{"_id": userId}, getLoginProjection())
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("fetch user: %w", err)
+	}
+
+	if user.HashedPassword != "" {
+		return nil, fmt.Errorf("an account with this %s exists already, log in with its password and link your %s account",
+			provider.LinkClaim, provider.Name)
+	}
+
+	linked, err := handler.Models.User.LinkOidcIdentity(user.Id, provider.Name, claims.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("linking the account: %w", err)
+	}
+
+	if !linked {
+		return nil, fmt.Errorf("this account is linked to another %s account already", provider.Name)
+	}
+
+	return user, nil
+}
+
+// linkOidcUser -> Links the identity to the user who started the single sign-on with a link token
+func (handler *Handler) linkOidcUser(provider *oidc.Provider, claims *oidc.Claims, userId string) (*models.User, error) {
+	userObjectId, err := utils.ToObjectID(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{
+		"oidc_identities": bson.M{"$elemMatch": bson.M{"provider": provider.Name, "subject": claims.Subject}},
+	}
+
+	user, err := handler.Models.User.Get(filter, getLoginProjection())
+	if err == nil {
+		if user.Id != userObjectId {
+			return nil, fmt.Errorf("this %s account is linked to another account already", provider.Name)
+		}
+
+		return user, nil
+	}
+
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("fetch user: %w", err)
+	}
+
+	linked, err := handler.Models.User.LinkOidcIdentity(userObjectId, provider.Name, claims.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("linking the account: %w", err)
+	}
+
+	if !linked {
+		return nil, fmt.Errorf("your account is linked to another %s account already", provider.Name)
+	}
+
+	slog.Info("single sign-on identity linked", "provider", provider.Name, "user_id", userId)
+
+	return handler.Models.User.Get(bson.M{"_id": userObjectId}, getLoginProjection())
+}
+
+// setOidcStateCookie -> Only sent to the callback of the provider. SameSite=Lax still sends it on the redirect back
+// from the provider (a top-level navigation)
+func setOidcStateCookie(w http.ResponseWriter, provider *oidc.Provider, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     "/api/auth/oidc/callback/" + provider.Name,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(provider.RedirectUrl, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// redirectOidcError -> The single sign-on endpoints are browser navigations, the errors are shown by the login page
+func redirectOidcError(w http.ResponseWriter, r *http.Request, message string) {
+	http.Redirect(w, r, getFrontendUrl()+"/login#oidc_error="+url.QueryEscape(message), http.StatusFound)
+}
+
+// getFrontendUrl -> FRONTEND_URL, where the single sign-on ends
+func getFrontendUrl() string {
+	frontendUrl := strings.TrimSuffix(os.Getenv("FRONTEND_URL"), "/")
+	if frontendUrl == "" {
+		return "http://localhost:5000"
+	}
+
+	return frontendUrl
+}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"file_manager/database/models"
+	"file_manager/utils"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log/slog"
+)
+
+// reconcileBatchSize -> Users and teams are reconciled in batches
+const reconcileBatchSize = 100
+
+// chargeStorage -> Reserves size in the used storage of the team, or in the user`s one when team is nil. The reservation
+// is atomic, so it fails instead of exceeding the quota, and must be returned with returnStorage when the upload fails
+func (handler *Handler) chargeStorage(userId primitive.ObjectID, team *models.Team, userPlan string, size int64) error {
+	if team == nil {
+		totalStorage, err := utils.GetUserTotalStorage(userPlan)
+		if err != nil {
+			return err
+		}
+
+		if err := handler.Models.User.ReserveStorage(userId, size, totalStorage); err != nil {
+			if errors.Is(err, models.ErrStorageExceeded) {
+				return fmt.Errorf("your file size (%d bytes) exceeds your remaining storage (plan's total storage limit is %d bytes)",
+					size, totalStorage)
+			}
+
+			return fmt.Errorf("updating user storage: %w", err)
+		}
+
+		return nil
+	}
+
+	totalStorage, err := utils.GetTeamTotalStorage(team.Plan)
+	if err != nil {
+		return err
+	}
+
+	if err := handler.Models.Team.ReserveStorage(team.Id, size, totalStorage); err != nil {
+		if errors.Is(err, models.ErrStorageExceeded) {
+			return fmt.Errorf("your file size (%d bytes) exceeds the team's remaining storage (plan's total storage limit is %d bytes)",
+				size, totalStorage)
+		}
+
+		return fmt.Errorf("updating team storage: %w", err)
+	}
+
+	return nil
+}
+
+// returnStorage -> Gives the freed space back to the team, or to the user when teamId is nil
+func (handler *Handler) returnStorage(userId, teamId primitive.ObjectID, size int64) error {
+	if size == 0 {
+		return nil
+	}
+
+	if teamId != primitive.NilObjectID {
+		if err := handler.Models.Team.AddStorageUsed(teamId, -size); err != nil {
+			return fmt.Errorf("updating team storage: %w", err)
+		}
+
+		return nil
+	}
+
+	if err := handler.Models.User.AddUploadSize(userId, -size); err != nil {
+		return fmt.Errorf("updating user storage: %w", err)
+	}
+
+	return nil
+}
+
+// discardUpload -> Undoes a stored and charged upload whose file could not be created
+func (handler *Handler) discardUpload(content *models.FileContent, userId, teamId primitive.ObjectID) {
+	if err := handler.releaseContent(context.Background(), content); err != nil {
+		slog.Error("releasing discarded upload", "checksum", content.Checksum, "error", err)
+	}
+
+	if err := handler.returnStorage(userId, teamId, content.Size); err != nil {
+		slog.Error("returning storage of discarded upload", "error", err)
+	}
+}
+
+// ReconcileStorage -> Recomputes the used storage of every user and team from their files (trashed ones and previous
+// versions included, as they count against the quota until they are removed). Uploads running meanwhile may be
+// miscounted, so it is meant to run while the server is stopped or idle
+func (handler *Handler) ReconcileStorage(ctx context.Context) (int, error) {
+	var fixed int
+
+	projection := bson.M{
+		"_id":               1,
+		"total_upload_size": 1,
+	}
+
+	for page := int64(1); ; page++ {
+		users, err := handler.Models.User.GetAll(bson.M{}, projection, page, reconcileBatchSize)
+		if err != nil {
+			return fixed, err
+		}
+
+		for _, user := range users {
+			filter := bson.M{
+				"owner_id": user.Id,
+				"team_id":  primitive.NilObjectID,
+			}
+
+			usage, err := handler.getStorageUsage(ctx, filter)
+			if err != nil {
+				return fixed, fmt.Errorf("computing storage of user %s: %w", user.Id.Hex(), err)
+			}
+
+			if usage == user.TotalUploadSize {
+				continue
+			}
+
+			if err := handler.Models.User.SetUploadSize(user.Id, usage); err != nil {
+				return fixed, err
+			}
+
+			slog.Info("reconciled user storage", "id", user.Id.Hex(), "from", user.TotalUploadSize, "to", usage)
+			fixed++
+		}
+
+		if len(users) < reconcileBatchSize {
+			break
+		}
+	}
+
+	projection = bson.M{
+		"_id":          1,
+		"storage_used": 1,
+	}
+
+	for page := int64(1); ; page++ {
+		teams, err := handler.Models.Team.GetAll(bson.M{}, projection, page, reconcileBatchSize)
+		if err != nil {
+			return fixed, err
+		}
+
+		for _, team := range teams {
+			usage, err := handler.getStorageUsage(ctx, bson.M{"team_id": team.Id})
+			if err != nil {
+				return fixed, fmt.Errorf("computing storage of team %s: %w", team.Id.Hex(), err)
+			}
+
+			if usage == team.StorageUsed {
+				continue
+			}
+
+			if err := handler.Models.Team.SetStorageUsed(team.Id, usage); err != nil {
+				return fixed, err
+			}
+
+			slog.Info("reconciled team storage", "id", team.Id.Hex(), "from", team.StorageUsed, "to", usage)
+			fixed++
+		}
+
+		if len(teams) < reconcileBatchSize {
+			return fixed, nil
+		}
+	}
+}
+
+// getStorageUsage -> Files uploaded before the size was stored are measured in the storage
+func (handler *Handler) getStorageUsage(ctx context.Context, filter bson.M) (int64, error) {
+	usage, err := handler.Models.File.GetStorageUsage(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	legacyFilter := bson.M{
+		"size":     bson.M{"$in": bson.A{0, nil}},
+		"checksum": bson.M{"$in": bson.A{"", nil}},
+	}
+
+	for key, value := range filter {
+		legacyFilter[key] = value
+	}
+
+	projection := bson.M{
+		"address":  1,
+		"checksum": 1,
+		"size":     1,
+	}
+
+	legacyFiles, err := handler.Models.File.Find(legacyFilter, projection)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, file := range legacyFiles {
+		size, err := handler.getFileSize(ctx, &file)
+		if err != nil {
+			return 0, err
+		}
+
+		usage += size
+	}
+
+	return usage, nil
+}
@@ -0,0 +1,305 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"file_manager/database/models"
+	"file_manager/token"
+	"file_manager/utils"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	accessTokenLifetime  = 15 * time.Minute
+	refreshTokenLifetime = 30 * 24 * time.Hour // renewed on every refresh
+	recentLoginWindow    = 5 * time.Minute     // how long after the login the session counts as a fresh authentication
+)
+
+// tokenPair -> What Login, RefreshToken and UpdateUserPlan return
+type tokenPair struct {
+	AccessToken    string    `json:"token"`
+	RefreshToken   string    `json:"refresh_token,omitempty"`
+	TokenExpiresAt time.Time `json:"token_expires_at"`
+}
+
+// RefreshToken -> Rotates the refresh token and issues a new access token (with the current plan).
+// Presenting a refresh token which was rotated already means it was stolen (or replayed), the whole session is revoked
+func (handler *Handler) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	if err := utils.ParseJSON(r.Body, 1000, &input); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	sessionId, tokenHash, err := parseRefreshToken(input.RefreshToken)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	filter := bson.M{
+		"_id":       sessionId,
+		"expire_at": bson.M{"$gt": time.Now()},
+	}
+
+	projection := bson.M{
+		"user_id":            1,
+		"refresh_token_hash": 1,
+		"used_token_hashes":  1,
+	}
+
+	session, err := handler.Models.Session.Get(filter, projection)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, "invalid refresh token")
+		return
+	}
+
+	if tokenHash != session.RefreshTokenHash {
+		for _, usedHash := range session.UsedTokenHashes {
+			if usedHash == tokenHash {
+				handler.revokeReusedSession(sessionId)
+				break
+			}
+		}
+
+		utils.WriteError(w, http.StatusUnauthorized, "invalid refresh token")
+		return
+	}
+
+	filter = bson.M{
+		"_id": session.UserId,
+	}
+
+	projection = bson.M{
+		"username": 1,
+		"plan":     1,
+	}
+
+	user, err := handler.Models.User.Get(filter, projection)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	refreshToken, newTokenHash := newRefreshToken(sessionId)
+
+	accessToken, payload, err := handler.PasetoMaker.CreateToken(user.Username, user.Id.Hex(), user.Plan, sessionId.Hex(), accessTokenLifetime)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("error creating token: %w", err))
+		return
+	}
+
+	err = handler.Models.Session.Rotate(sessionId, tokenHash, newTokenHash, payload.ID.String(), time.Now().Add(refreshTokenLifetime))
+	if errors.Is(err, models.ErrRefreshTokenUsed) {
+		// a concurrent refresh with the same token won, one of the two was not the legitimate client
+		handler.revokeReusedSession(sessionId)
+		utils.WriteError(w, http.StatusUnauthorized, "invalid refresh token")
+		return
+	}
+
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.WriteJSONData(w, tokenPair{AccessToken: accessToken, RefreshToken: refreshToken, TokenExpiresAt: payload.ExpiryAt})
+}
+
+// Logout -> Revokes the current session: its access token and its refresh token
+func (handler *Handler) Logout(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	sessionId, err := utils.ToObjectID(payload.SessionId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	filter := bson.M{
+		"_id":             sessionId,
+		"access_token_id": payload.ID.String(),
+	}
+
+	if _, err := handler.Models.Session.DeleteAll(filter); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	utils.WriteJSON(w, "logged out successfully")
+}
+
+// LogoutAll -> Revokes every session of the user, the current one included
+func (handler *Handler) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	userObjectId, err := utils.ToObjectID(payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	filter := bson.M{
+		"user_id": userObjectId,
+	}
+
+	revoked, err := handler.Models.Session.DeleteAll(filter)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	utils.WriteJSONData(w, map[string]any{"revoked_sessions": revoked})
+}
+
+// createSession -> Starts a session (on login) and returns its first tokens
+func (handler *Handler) createSession(userId primitive.ObjectID, username, plan string) (*tokenPair, error) {
+	sessionId := primitive.NewObjectID()
+	refreshToken, tokenHash := newRefreshToken(sessionId)
+
+	accessToken, payload, err := handler.PasetoMaker.CreateToken(username, userId.Hex(), plan, sessionId.Hex(), accessTokenLifetime)
+	if err != nil {
+		return nil, fmt.Errorf("error creating token: %w", err)
+	}
+
+	if err := handler.Models.Session.Create(sessionId, userId, tokenHash, payload.ID.String(), time.Now().Add(refreshTokenLifetime)); err != nil {
+		return nil, fmt.Errorf("creating session instance: %w", err)
+	}
+
+	return &tokenPair{AccessToken: accessToken, RefreshToken: refreshToken, TokenExpiresAt: payload.ExpiryAt}, nil
+}
+
+// checkRecentLogin -> The session of the token has to be started by a login in the last minutes (the refreshes do not
+// count). It stands for the password of the password-less (single sign-on) users
+func (handler *Handler) checkRecentLogin(payload *token.Payload) error {
+	sessionId, err := utils.ToObjectID(payload.SessionId)
+	if err != nil {
+		return err
+	}
+
+	userObjectId, err := utils.ToObjectID(payload.UserId)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{
+		"_id":     sessionId,
+		"user_id": userObjectId,
+	}
+
+	session, err := handler.Models.Session.Get(filter, bson.M{"created_at": 1})
+	if err != nil {
+		return err
+	}
+
+	if time.Since(session.CreatedAt) > recentLoginWindow {
+		return errors.New("log in again with single sign-on to confirm it is you")
+	}
+
+	return nil
+}
+
+// reissueAccessToken -> Replaces the access token of the session (revoking the old one), the refresh token stays
+func (handler *Handler) reissueAccessToken(payload *token.Payload, plan string) (*tokenPair, error) {
+	sessionId, err := utils.ToObjectID(payload.SessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, newPayload, err := handler.PasetoMaker.CreateToken(payload.Username, payload.UserId, plan, payload.SessionId, accessTokenLifetime)
+	if err != nil {
+		return nil, fmt.Errorf("error creating token: %w", err)
+	}
+
+	filter := bson.M{
+		"_id":             sessionId,
+		"access_token_id": payload.ID.String(),
+	}
+
+	updates := bson.M{
+		"access_token_id": newPayload.ID.String(),
+	}
+
+	updated, err := handler.Models.Session.UpdateAll(filter, updates)
+	if err != nil {
+		return nil, err
+	}
+
+	if updated == 0 {
+		return nil, errors.New("session has been revoked")
+	}
+
+	return &tokenPair{AccessToken: accessToken, TokenExpiresAt: newPayload.ExpiryAt}, nil
+}
+
+// checkSession -> The session checker of the PasetoMaker: only the latest access token of a live session is accepted
+func (handler *Handler) checkSession(payload *token.Payload) error {
+	sessionId, err := utils.ToObjectID(payload.SessionId)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{
+		"_id":             sessionId,
+		"access_token_id": payload.ID.String(),
+		"expire_at":       bson.M{"$gt": time.Now()},
+	}
+
+	if _, err := handler.Models.Session.Get(filter, bson.M{"_id": 1}); err != nil {
+		return errors.New("session has been revoked")
+	}
+
+	return nil
+}
+
+func (handler *Handler) revokeReusedSession(sessionId primitive.ObjectID) {
+	slog.Warn("refresh token reused, revoking session", "session_id", sessionId.Hex())
+
+	if _, err := handler.Models.Session.DeleteAll(bson.M{"_id": sessionId}); err != nil {
+		slog.Error("revoking session", "session_id", sessionId.Hex(), "error", err)
+	}
+}
+
+// newRefreshToken -> "<session id>.<random secret>", only the hash of the whole token is stored
+func newRefreshToken(sessionId primitive.ObjectID) (string, string) {
+	refreshToken := sessionId.Hex() + "." + rand.Text()
+	return refreshToken, hashToken(refreshToken)
+}
+
+func parseRefreshToken(refreshToken string) (primitive.ObjectID, string, error) {
+	sessionIdStr, _, found := strings.Cut(refreshToken, ".")
+	if !found {
+		return primitive.NilObjectID, "", errors.New("invalid refresh token")
+	}
+
+	sessionId, err := utils.ToObjectID(sessionIdStr)
+	if err != nil {
+		return primitive.NilObjectID, "", errors.New("invalid refresh token")
+	}
+
+	return sessionId, hashToken(refreshToken), nil
+}
+
+// hashToken -> The refresh and the api tokens are random (128 bits of entropy), a plain sha256 is enough for them
+func hashToken(value string) string {
+	hash := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,825 @@
+package handlers
+
+import (
+	"errors"
+	"file_manager/database/models"
+	"file_manager/utils"
+	"fmt"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+)
+
+// receivedShare -> An entry of the "Shared with me" listing
+type receivedShare struct {
+	Id         primitive.ObjectID `json:"id"`
+	OwnerId    primitive.ObjectID `json:"owner_id"`
+	Permission string             `json:"permission"`
+	File       *sharedItem        `json:"file,omitempty"`
+	Folder     *sharedItem        `json:"folder,omitempty"`
+}
+
+// CreateShare -> Shares a file or a folder of the personal space with a user or a team. Team items are shared through their team
+func (handler *Handler) CreateShare(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	userObjectId, err := utils.ToObjectID(payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var input struct {
+		FileId     string `json:"file_id"`
+		FolderId   string `json:"folder_id"`
+		UserId     string `json:"user_id"`
+		TeamId     string `json:"team_id"`
+		Permission string `json:"permission"`
+	}
+
+	if err := utils.ParseJSON(r.Body, 1000, &input); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := validateSharePermission(input.Permission); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if (input.FileId == "") == (input.FolderId == "") {
+		utils.WriteError(w, http.StatusBadRequest, "either 'file_id' or 'folder_id' must be sent")
+		return
+	}
+
+	if (input.UserId == "") == (input.TeamId == "") {
+		utils.WriteError(w, http.StatusBadRequest, "either 'user_id' or 'team_id' must be sent")
+		return
+	}
+
+	var fileObjectId, folderObjectId primitive.ObjectID
+	if input.FileId != "" {
+		fileObjectId, err = handler.getShareableItem(input.FileId, userObjectId, true)
+	} else {
+		folderObjectId, err = handler.getShareableItem(input.FolderId, userObjectId, false)
+	}
+
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var granteeUserId, granteeTeamId primitive.ObjectID
+	if input.UserId != "" {
+		granteeUserId, err = utils.ToObjectID(input.UserId)
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if granteeUserId == userObjectId {
+			utils.WriteError(w, http.StatusBadRequest, "you can`t share an item with yourself")
+			return
+		}
+
+		filter := bson.M{
+			"_id": granteeUserId,
+		}
+
+		if _, err := handler.Models.User.Get(filter, bson.M{"_id": 1}); err != nil {
+			utils.WriteError(w, http.StatusNotFound, err)
+			return
+		}
+	} else {
+		granteeTeamId, err = utils.ToObjectID(input.TeamId)
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		filter := bson.M{
+			"_id": granteeTeamId,
+		}
+
+		if _, err := handler.Models.Team.Get(filter, bson.M{"_id": 1}); err != nil {
+			utils.WriteError(w, http.StatusNotFound, err)
+			return
+		}
+	}
+
+	filter := bson.M{
+		"file_id":   fileObjectId,
+		"folder_id": folderObjectId,
+		"user_id":   granteeUserId,
+		"team_id":   granteeTeamId,
+	}
+
+	// the unset ids are not stored at all
+	for key, value := range filter {
+		if value == primitive.NilObjectID {
+			filter[key] = bson.M{"$exists": false}
+		}
+	}
+
+	if _, err := handler.Models.Share.Get(filter, bson.M{"_id": 1}); err == nil {
+		utils.WriteError(w, http.StatusBadRequest, "this item is shared with them already, change the permission of that share instead")
+		return
+	}
+
+	shareId, err := handler.Models.Share.Create(userObjectId, fileObjectId, folderObjectId, granteeUserId, granteeTeamId, input.Permission)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("creating share instance: %w", err))
+		return
+	}
+
+	utils.WriteJSONData(w, map[string]any{"id": shareId})
+}
+
+// GetShares -> Returns the shares the user created
+func (handler *Handler) GetShares(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	userObjectId, err := utils.ToObjectID(payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	page, pageSize, err := utils.GetPaginationParams(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	filter := bson.M{
+		"owner_id": userObjectId,
+	}
+
+	shares, err := handler.Models.Share.GetAll(filter, bson.M{}, page, pageSize)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	utils.WriteJSONData(w, map[string]any{"shares": shares})
+}
+
+// GetReceivedShares -> "Shared with me": the items shared with the user or with one of the user`s teams
+func (handler *Handler) GetReceivedShares(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	userObjectId, err := utils.ToObjectID(payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	page, pageSize, err := utils.GetPaginationParams(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	filter, err := handler.getShareGranteeFilter(userObjectId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	shares, err := handler.Models.Share.GetAll(filter, bson.M{}, page, pageSize)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var fileIds, folderIds []primitive.ObjectID
+	for _, share := range shares {
+		if share.FileId != primitive.NilObjectID {
+			fileIds = append(fileIds, share.FileId)
+		} else {
+			folderIds = append(folderIds, share.FolderId)
+		}
+	}
+
+	filter = bson.M{
+		"_id":        bson.M{"$in": fileIds},
+		"team_id":    primitive.NilObjectID,
+		"deleted_at": bson.M{"$exists": false},
+	}
+
+	projection := bson.M{
+		"name":          1,
+		"size":          1,
+		"content_type":  1,
+		"original_name": 1,
+		"extension":     1,
+		"created_at":    1,
+	}
+
+	files, err := handler.Models.File.Find(filter, projection)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	filter = bson.M{
+		"_id":        bson.M{"$in": folderIds},
+		"team_id":    primitive.NilObjectID,
+		"deleted_at": bson.M{"$exists": false},
+	}
+
+	projection = bson.M{
+		"name":       1,
+		"created_at": 1,
+	}
+
+	folders, err := handler.Models.Folder.Find(filter, projection)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	items := make(map[primitive.ObjectID]*sharedItem, len(files)+len(folders))
+	for _, file := range files {
+		items[file.Id] = &sharedItem{
+			Id:          file.Id,
+			Name:        getDownloadName(&file),
+			Size:        file.Size,
+			ContentType: file.ContentType,
+			CreatedAt:   file.CreatedAt,
+		}
+	}
+
+	for _, folder := range folders {
+		items[folder.Id] = &sharedItem{
+			Id:        folder.Id,
+			Name:      folder.Name,
+			CreatedAt: folder.CreatedAt,
+		}
+	}
+
+	// the trashed (or moved to a team) items are left out
+	received := make([]receivedShare, 0, len(shares))
+	for _, share := range shares {
+		entry := receivedShare{
+			Id:         share.Id,
+			OwnerId:    share.OwnerId,
+			Permission: share.Permission,
+		}
+
+		if share.FileId != primitive.NilObjectID {
+			entry.File = items[share.FileId]
+		} else {
+			entry.Folder = items[share.FolderId]
+		}
+
+		if entry.File == nil && entry.Folder == nil {
+			continue
+		}
+
+		received = append(received, entry)
+	}
+
+	utils.WriteJSONData(w, map[string]any{"shares": received})
+}
+
+// UpdateShare -> Changes the permission of a share, it applies to the next request of the grantee
+func (handler *Handler) UpdateShare(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	var input struct {
+		Permission string `json:"permission"`
+	}
+
+	if err := utils.ParseJSON(r.Body, 1000, &input); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := validateSharePermission(input.Permission); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	shareObjectId, err := handler.getOwnShareId(r, payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	updates := bson.M{
+		"permission": input.Permission,
+	}
+
+	if err := handler.Models.Share.Update(shareObjectId, updates); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	utils.WriteJSON(w, "share updated successfully")
+}
+
+// DeleteShare -> Revokes a share. The access is checked against the shares on every request, so it ends right away
+func (handler *Handler) DeleteShare(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	shareObjectId, err := handler.getOwnShareId(r, payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	filter := bson.M{
+		"_id": shareObjectId,
+	}
+
+	if _, err := handler.Models.Share.DeleteAll(filter); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	utils.WriteJSON(w, "share deleted successfully")
+}
+
+// GetSharedWithMeFolder -> Lists a folder shared with the user (or one of its subfolders)
+func (handler *Handler) GetSharedWithMeFolder(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	userObjectId, err := utils.ToObjectID(payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	folder, permission, err := handler.getSharedWithMeFolder(r, userObjectId, models.SharePermissionRead)
+	if err != nil {
+		utils.WriteError(w, http.StatusForbidden, err)
+		return
+	}
+
+	page, pageSize, err := utils.GetPaginationParams(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	filter := bson.M{
+		"folder_id":  folder.Id,
+		"deleted_at": bson.M{"$exists": false},
+	}
+
+	files, err := handler.Models.File.GetAll(filter, page, pageSize)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	filter = bson.M{
+		"parent_id":  folder.Id,
+		"deleted_at": bson.M{"$exists": false},
+	}
+
+	folders, err := handler.Models.Folder.GetAll(filter, page, pageSize)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	sharedFiles := make([]sharedItem, 0, len(files))
+	for _, file := range files {
+		sharedFiles = append(sharedFiles, sharedItem{
+			Id:          file.Id,
+			Name:        getDownloadName(&file),
+			Size:        file.Size,
+			ContentType: file.ContentType,
+			CreatedAt:   file.CreatedAt,
+		})
+	}
+
+	sharedFolders := make([]sharedItem, 0, len(folders))
+	for _, subfolder := range folders {
+		sharedFolders = append(sharedFolders, sharedItem{
+			Id:        subfolder.Id,
+			Name:      subfolder.Name,
+			CreatedAt: subfolder.CreatedAt,
+		})
+	}
+
+	response := map[string]any{
+		"folder_id":   folder.Id,
+		"folder_name": folder.Name,
+		"parent_id":   folder.ParentId,
+		"permission":  permission,
+		"folders":     sharedFolders,
+		"files":       sharedFiles,
+	}
+
+	utils.WriteJSONData(w, response)
+}
+
+// DownloadSharedWithMeFile -> Downloads a file shared with the user (directly or through one of its folders)
+func (handler *Handler) DownloadSharedWithMeFile(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	userObjectId, err := utils.ToObjectID(payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	fileId, err := utils.ParseIdParam(r.Context())
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	fileObjectId, err := utils.ToObjectID(fileId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	filter := bson.M{
+		"_id":        fileObjectId,
+		"deleted_at": bson.M{"$exists": false},
+	}
+
+	fileInstance, err := handler.Models.File.Get(filter, bson.M{})
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, err := handler.checkFileShareAccess(userObjectId, fileInstance, models.SharePermissionRead); err != nil {
+		utils.WriteError(w, http.StatusForbidden, err)
+		return
+	}
+
+	file, _, err := handler.openContent(r.Context(), &fileInstance.FileContent)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	defer file.Close()
+
+	writeContent(w, r, fileInstance, file, "attachment")
+}
+
+// UploadToSharedFolder -> Uploads a file into a folder shared with write permission. The file belongs to the folder`s
+// owner and counts against the owner`s quota
+func (handler *Handler) UploadToSharedFolder(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	userObjectId, err := utils.ToObjectID(payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	folder, _, err := handler.getSharedWithMeFolder(r, userObjectId, models.SharePermissionWrite)
+	if err != nil {
+		utils.WriteError(w, http.StatusForbidden, err)
+		return
+	}
+
+	ownerPlan, err := handler.getOwnerPlan(folder.OwnerId, userObjectId, payload.UserPlan)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	maxUploadSize := utils.GetUserMaxUploadSize(ownerPlan)
+
+	// extra megabyte for the multipart boundaries and the plain fields
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize+MegaBytes)
+
+	content, err := handler.storeUserFile(r, maxUploadSize, folder.OwnerId, ownerPlan)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	fileName := r.FormValue("file_name")
+	if fileName == "" {
+		fileName = content.OriginalName
+	}
+
+	if fileName == "" {
+		fileName = uuid.New().String()
+	}
+
+	expireAt := utils.GetUserExpirationDate(ownerPlan)
+
+	if _, err := handler.Models.File.Create(folder.OwnerId, primitive.NilObjectID, folder.Id, fileName, *content, expireAt); err != nil {
+		handler.discardUpload(content, folder.OwnerId, primitive.NilObjectID)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("creating file instance: %w", err))
+		return
+	}
+
+	utils.WriteJSON(w, "file uploaded successfully")
+}
+
+// getSharedWithMeFolder -> The folder of the id param, if the user has the permission on it through a share
+func (handler *Handler) getSharedWithMeFolder(r *http.Request, userId primitive.ObjectID, permission string) (*models.Folder, string, error) {
+	folderId, err := utils.ParseIdParam(r.Context())
+	if err != nil {
+		return nil, "", err
+	}
+
+	folderObjectId, err := utils.ToObjectID(folderId)
+	if err != nil {
+		return nil, "", err
+	}
+
+	filter := bson.M{
+		"_id":        folderObjectId,
+		"deleted_at": bson.M{"$exists": false},
+	}
+
+	projection := bson.M{
+		"_id":       1,
+		"owner_id":  1,
+		"team_id":   1,
+		"parent_id": 1,
+		"name":      1,
+	}
+
+	folder, err := handler.Models.Folder.Get(filter, projection)
+	if err != nil {
+		return nil, "", err
+	}
+
+	granted, err := handler.checkFolderShareAccess(userId, folder, permission)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return folder, granted, nil
+}
+
+// getShareableItem -> The id of the user`s own file (or folder) in the personal space
+func (handler *Handler) getShareableItem(id string, userId primitive.ObjectID, isFile bool) (primitive.ObjectID, error) {
+	objectId, err := utils.ToObjectID(id)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	filter := bson.M{
+		"_id":        objectId,
+		"owner_id":   userId,
+		"team_id":    primitive.NilObjectID,
+		"deleted_at": bson.M{"$exists": false},
+	}
+
+	projection := bson.M{
+		"_id": 1,
+	}
+
+	if isFile {
+		_, err = handler.Models.File.Get(filter, projection)
+	} else {
+		_, err = handler.Models.Folder.Get(filter, projection)
+	}
+
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	return objectId, nil
+}
+
+// getOwnShareId -> The id param, if the user created this share
+func (handler *Handler) getOwnShareId(r *http.Request, userId string) (primitive.ObjectID, error) {
+	userObjectId, err := utils.ToObjectID(userId)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	shareId, err := utils.ParseIdParam(r.Context())
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	shareObjectId, err := utils.ToObjectID(shareId)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	filter := bson.M{
+		"_id":      shareObjectId,
+		"owner_id": userObjectId,
+	}
+
+	if _, err := handler.Models.Share.Get(filter, bson.M{"_id": 1}); err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	return shareObjectId, nil
+}
+
+// checkFileShareAccess -> The owner has every permission, the others need a share of the file or of a folder above it.
+// Returns the granted permission
+func (handler *Handler) checkFileShareAccess(userId primitive.ObjectID, file *models.File, permission string) (string, error) {
+	if file.OwnerId == userId {
+		return models.SharePermissionWrite, nil
+	}
+
+	// only the personal items are shared
+	if file.TeamId != primitive.NilObjectID {
+		return "", errors.New("this item is not shared with you")
+	}
+
+	var folderIds []primitive.ObjectID
+	if file.FolderId != primitive.NilObjectID {
+		path, err := handler.getFolderPath(file.FolderId)
+		if err != nil {
+			return "", err
+		}
+
+		for _, folder := range path {
+			folderIds = append(folderIds, folder.Id)
+		}
+	}
+
+	granted, err := handler.getSharePermission(userId, file.Id, folderIds)
+	if err != nil {
+		return "", err
+	}
+
+	return granted, checkSharePermission(granted, permission)
+}
+
+// checkFolderShareAccess -> Like checkFileShareAccess, with the shares of the folder or of a folder above it
+func (handler *Handler) checkFolderShareAccess(userId primitive.ObjectID, folder *models.Folder, permission string) (string, error) {
+	if folder.OwnerId == userId {
+		return models.SharePermissionWrite, nil
+	}
+
+	if folder.TeamId != primitive.NilObjectID {
+		return "", errors.New("this item is not shared with you")
+	}
+
+	path, err := handler.getFolderPath(folder.Id)
+	if err != nil {
+		return "", err
+	}
+
+	folderIds := make([]primitive.ObjectID, 0, len(path))
+	for _, pathFolder := range path {
+		folderIds = append(folderIds, pathFolder.Id)
+	}
+
+	granted, err := handler.getSharePermission(userId, primitive.NilObjectID, folderIds)
+	if err != nil {
+		return "", err
+	}
+
+	return granted, checkSharePermission(granted, permission)
+}
+
+// getSharePermission -> The strongest permission the user (or one of the user`s teams) has through the shares of the file
+// or of the folders. Empty if none of them is shared with the user
+func (handler *Handler) getSharePermission(userId, fileId primitive.ObjectID, folderIds []primitive.ObjectID) (string, error) {
+	granteeFilter, err := handler.getShareGranteeFilter(userId)
+	if err != nil {
+		return "", err
+	}
+
+	items := bson.A{
+		bson.M{"folder_id": bson.M{"$in": folderIds}},
+	}
+
+	if fileId != primitive.NilObjectID {
+		items = append(items, bson.M{"file_id": fileId})
+	}
+
+	filter := bson.M{
+		"$and": bson.A{granteeFilter, bson.M{"$or": items}},
+	}
+
+	projection := bson.M{
+		"permission": 1,
+	}
+
+	shares, err := handler.Models.Share.Find(filter, projection)
+	if err != nil {
+		return "", err
+	}
+
+	var permission string
+	for _, share := range shares {
+		if share.Permission == models.SharePermissionWrite {
+			return models.SharePermissionWrite, nil
+		}
+
+		permission = share.Permission
+	}
+
+	return permission, nil
+}
+
+// getShareGranteeFilter -> Matches the shares with the user and with the user`s teams
+func (handler *Handler) getShareGranteeFilter(userId primitive.ObjectID) (bson.M, error) {
+	filter := bson.M{
+		"users": userId,
+	}
+
+	projection := bson.M{
+		"_id": 1,
+	}
+
+	teams, err := handler.Models.Team.Find(filter, projection)
+	if err != nil {
+		return nil, err
+	}
+
+	teamIds := make([]primitive.ObjectID, 0, len(teams))
+	for _, team := range teams {
+		teamIds = append(teamIds, team.Id)
+	}
+
+	granteeFilter := bson.M{
+		"$or": bson.A{
+			bson.M{"user_id": userId},
+			bson.M{"team_id": bson.M{"$in": teamIds}},
+		},
+	}
+
+	return granteeFilter, nil
+}
+
+// getOwnerPlan -> The plan of the item`s owner, the owner`s quota and limits apply to the uploads of the grantees
+func (handler *Handler) getOwnerPlan(ownerId, userId primitive.ObjectID, userPlan string) (string, error) {
+	if ownerId == userId {
+		return userPlan, nil
+	}
+
+	filter := bson.M{
+		"_id": ownerId,
+	}
+
+	projection := bson.M{
+		"plan": 1,
+	}
+
+	owner, err := handler.Models.User.Get(filter, projection)
+	if err != nil {
+		return "", err
+	}
+
+	return owner.Plan, nil
+}
+
+func checkSharePermission(granted, required string) error {
+	if granted == "" {
+		return errors.New("this item is not shared with you")
+	}
+
+	if required == models.SharePermissionWrite && granted != models.SharePermissionWrite {
+		return errors.New("this item is shared with you as read-only")
+	}
+
+	return nil
+}
+
+func validateSharePermission(permission string) error {
+	if permission != models.SharePermissionRead && permission != models.SharePermissionWrite {
+		return fmt.Errorf("'permission' must be '%s' or '%s'", models.SharePermissionRead, models.SharePermissionWrite)
+	}
+
+	return nil
+}
@@ -1,13 +1,16 @@
 package handlers
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/json"
 	"errors"
+	"file_manager/database/models"
 	"file_manager/utils"
 	"fmt"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log/slog"
 	"net/http"
 	"slices"
 	"time"
@@ -17,6 +20,8 @@ const (
 	MegaBytes int64 = 1 << 20
 )
 
+var teamFileAllowedTypes = []string{"image/jpeg", "image/png", "application/zip"}
+
 // GetTeams -> Returns List
 func (handler *Handler) GetTeams(w http.ResponseWriter, r *http.Request) {
 	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
@@ -110,12 +115,6 @@ func (handler *Handler) CreateTeam(w http.ResponseWriter, r *http.Request) {
 	}
 
 	r.Body = http.MaxBytesReader(w, r.Body, 5*MegaBytes)
-	name := r.FormValue("name")
-	if name == "" {
-		name = rand.Text()
-	}
-
-	description := r.FormValue("description")
 
 	teamId := primitive.NewObjectID()
 	avatarAddress, err := handler.uploadAvatar(r, 5<<20, teamId.Hex())
@@ -124,6 +123,14 @@ func (handler *Handler) CreateTeam(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// plain fields are parsed while streaming the body
+	name := r.FormValue("name")
+	if name == "" {
+		name = rand.Text()
+	}
+
+	description := r.FormValue("description")
+
 	userObjectId, err := utils.ToObjectID(payload.UserId)
 	if err != nil {
 		utils.WriteError(w, http.StatusBadRequest, err)
@@ -151,7 +158,7 @@ func (handler *Handler) uploadAvatar(r *http.Request, maxUploadSize int64, teamI
 	}
 
 	uploadDir := "uploads/team_files/avatars/" + teamId + "/"
-	return file.UploadToDisk(uploadDir)
+	return file.UploadToStorage(r.Context(), handler.Storage, uploadDir)
 }
 
 func (handler *Handler) UpdateTeamPlan(w http.ResponseWriter, r *http.Request) {
@@ -266,6 +273,14 @@ func (handler *Handler) DeleteTeam(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	filter = bson.M{
+		"team_id": teamObjectId,
+	}
+
+	if _, err := handler.Models.Share.DeleteAll(filter); err != nil {
+		slog.Error("removing team shares", "error", err)
+	}
+
 	utils.WriteJSON(w, "team deleted successfully")
 }
 
@@ -404,83 +419,100 @@ func (handler *Handler) UploadTeamFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fileName := r.FormValue("name")
-	if fileName == "" {
-		fileName = rand.Text()
+	userObjectId, err := utils.ToObjectID(payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
 	}
 
-	userObjectId, err := utils.ToObjectID(payload.UserId)
+	maxUploadSize := utils.GetTeamMaxUploadSize(teamInstance.Plan)
+
+	// extra megabyte for the multipart boundaries and the plain fields
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize+MegaBytes)
+
+	content, err := handler.storeTeamFile(r, maxUploadSize, teamInstance)
 	if err != nil {
 		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
 
+	// plain fields are parsed while streaming the body, so they are available only after the file is stored
+	fileName := r.FormValue("name")
+	if fileName == "" {
+		fileName = content.OriginalName
+	}
+
+	if fileName == "" {
+		fileName = rand.Text()
+	}
+
 	folderObjectId, err := getFolderId(r)
 	if err != nil {
+		handler.discardUpload(content, userObjectId, teamObjectId)
 		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
 
 	if folderObjectId != primitive.NilObjectID {
 		if err := handler.ValidateFolderId(folderObjectId, userObjectId); err != nil {
+			handler.discardUpload(content, userObjectId, teamObjectId)
 			utils.WriteError(w, http.StatusBadRequest, err)
 			return
 		}
 	}
 
-	maxUploadSize := utils.GetTeamMaxUploadSize(teamInstance.Plan)
-
-	uploadDir := utils.GetTeamUploadDir(teamIdStr)
-
-	fileAddress, totalUploadSize, err := handler.storeTeamFile(r, maxUploadSize, teamInstance.StorageUsed, teamInstance.Plan, uploadDir)
-	if err != nil {
-		utils.WriteError(w, http.StatusBadRequest, err)
-		return
-	}
-
 	expireAt := utils.GetTeamExpirationDate(teamInstance.Plan)
 
-	if _, err := handler.Models.File.Create(userObjectId, teamObjectId, folderObjectId, fileName, fileAddress, expireAt); err != nil {
+	if _, err := handler.Models.File.Create(userObjectId, teamObjectId, folderObjectId, fileName, *content, expireAt); err != nil {
+		handler.discardUpload(content, userObjectId, teamObjectId)
 		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("creating file instance: %w", err))
 		return
 	}
 
-	updates := bson.M{
-		"storage_used": totalUploadSize,
-		"updated_at":   time.Now(),
-	}
-
-	if err := handler.Models.Team.Update(teamObjectId, updates); err != nil {
-		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("updating team instance: %w", err))
-		return
-	}
-
 	utils.WriteJSON(w, "file uploaded successfully")
 }
 
-func (handler *Handler) storeTeamFile(r *http.Request, maxUploadSize, totalUsedStorage int64, plan, uploadDir string) (string, int64, error) {
-	allowedTypes := []string{"image/jpeg", "image/png", "application/zip"}
-
-	file, err := utils.ReadFile(r, maxUploadSize, allowedTypes)
+// storeTeamFile -> Stores the uploaded file and reserves its size in the team`s storage.
+// The content must be discarded (see discardUpload) if its file can`t be created
+func (handler *Handler) storeTeamFile(r *http.Request, maxUploadSize int64, team *models.Team) (*models.FileContent, error) {
+	totalStorage, err := utils.GetTeamTotalStorage(team.Plan)
 	if err != nil {
-		return "", 0, err
+		return nil, err
 	}
 
-	defer file.File.Close()
+	remainedStorage := max(totalStorage-team.StorageUsed, 0)
 
-	newTotalStorage, err := handler.isTeamEligibleToUpload(plan, totalUsedStorage, file.Size)
+	// the size is not known before streaming, so the stream itself is limited
+	file, err := utils.ReadFile(r, min(maxUploadSize, remainedStorage), teamFileAllowedTypes)
 	if err != nil {
-		return "", 0, err
+		return nil, err
 	}
 
-	fileAddress, err := file.UploadToDisk(uploadDir)
+	blob, err := handler.storeBlob(r.Context(), file.File)
 	if err != nil {
-		return "", 0, err
+		if errors.Is(err, utils.ErrFileTooLarge) {
+			return nil, fmt.Errorf("your file exceeds either the team plan's max upload size (%d bytes) or its remaining storage (%d bytes)",
+				maxUploadSize, remainedStorage)
+		}
+
+		return nil, err
+	}
+
+	if err := file.ReadRemainingFields(); err != nil {
+		_ = handler.releaseBlob(context.Background(), blob.Id)
+		return nil, err
+	}
+
+	// another upload may have used the remaining storage meanwhile, the reservation fails then
+	if err := handler.chargeStorage(primitive.NilObjectID, team, "", blob.Size); err != nil {
+		_ = handler.releaseBlob(context.Background(), blob.Id)
+		return nil, err
 	}
 
-	return fileAddress, file.Size + newTotalStorage, nil
+	return newFileContent(blob, file.Name, file.ContentType), nil
 }
 
+// isTeamEligibleToUpload -> Only a pre-check, the storage is reserved with chargeStorage
 func (handler *Handler) isTeamEligibleToUpload(plan string, usedStorage, fileSize int64) (int64, error) {
 	totalStorage, err := utils.GetTeamTotalStorage(plan)
 	if err != nil {
@@ -0,0 +1,435 @@
+package handlers
+
+import (
+	"context"
+	"file_manager/database/models"
+	"file_manager/utils"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// GetTrash -> Returns the trashed files and folders of the user
+func (handler *Handler) GetTrash(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	userObjectId, err := utils.ToObjectID(payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	page, pageSize, err := utils.GetPaginationParams(r)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	filter := bson.M{
+		"owner_id":   userObjectId,
+		"deleted_at": bson.M{"$exists": true},
+	}
+
+	files, err := handler.Models.File.GetAll(filter, page, pageSize)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	folders, err := handler.Models.Folder.GetAll(filter, page, pageSize)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	response := map[string]any{
+		"files":   files,
+		"folders": folders,
+	}
+
+	utils.WriteJSONData(w, response)
+}
+
+// RestoreFile -> A file whose folder is trashed (or purged) is restored to the root
+func (handler *Handler) RestoreFile(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	file, err := handler.getTrashedFile(r, payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	updates := bson.M{}
+	if file.FolderId != primitive.NilObjectID {
+		filter := bson.M{
+			"_id":        file.FolderId,
+			"deleted_at": bson.M{"$exists": false},
+		}
+
+		projection := bson.M{
+			"_id": 1,
+		}
+
+		if _, err := handler.Models.Folder.Get(filter, projection); err != nil {
+			updates["folder_id"] = primitive.NilObjectID
+		}
+	}
+
+	filter := bson.M{
+		"_id":        file.Id,
+		"deleted_at": bson.M{"$exists": true},
+	}
+
+	restored, err := handler.Models.File.Restore(filter, updates)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if restored == 0 {
+		utils.WriteError(w, http.StatusBadRequest, "file is not in the trash")
+		return
+	}
+
+	utils.WriteJSON(w, "file restored successfully")
+}
+
+// PurgeFile -> Deletes a trashed file permanently
+func (handler *Handler) PurgeFile(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	file, err := handler.getTrashedFile(r, payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := handler.removeFile(r.Context(), file); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	utils.WriteJSON(w, "file deleted permanently")
+}
+
+// RestoreFolder -> Restores the folder with the subfolders and files which were trashed together with it.
+// If its parent is trashed (or purged) the folder is restored to the root
+func (handler *Handler) RestoreFolder(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	folder, err := handler.getTrashedFolder(r, payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	folderIds, _, err := handler.getFolderSubtree(folder.Id, bson.M{"deleted_at": folder.DeletedAt})
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	updates := bson.M{}
+	if folder.ParentId != primitive.NilObjectID {
+		filter := bson.M{
+			"_id":        folder.ParentId,
+			"deleted_at": bson.M{"$exists": false},
+		}
+
+		projection := bson.M{
+			"_id": 1,
+		}
+
+		if _, err := handler.Models.Folder.Get(filter, projection); err != nil {
+			updates["parent_id"] = primitive.NilObjectID
+		}
+	}
+
+	filter := bson.M{
+		"_id":        folder.Id,
+		"deleted_at": folder.DeletedAt,
+	}
+
+	if _, err := handler.Models.Folder.Restore(filter, updates); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	filter = bson.M{
+		"_id":        bson.M{"$in": folderIds},
+		"deleted_at": folder.DeletedAt,
+	}
+
+	if _, err := handler.Models.Folder.Restore(filter, nil); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	filter = bson.M{
+		"folder_id":  bson.M{"$in": folderIds},
+		"deleted_at": folder.DeletedAt,
+	}
+
+	if _, err := handler.Models.File.Restore(filter, nil); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	utils.WriteJSON(w, "folder restored successfully")
+}
+
+// PurgeFolder -> Deletes a trashed folder with all of its subfolders and files permanently
+func (handler *Handler) PurgeFolder(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	folder, err := handler.getTrashedFolder(r, payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := handler.purgeFolder(r.Context(), folder.Id); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	utils.WriteJSON(w, "folder deleted permanently")
+}
+
+// EmptyTrash -> Deletes every trashed file and folder of the user permanently
+func (handler *Handler) EmptyTrash(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	userObjectId, err := utils.ToObjectID(payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	filter := bson.M{
+		"owner_id":   userObjectId,
+		"deleted_at": bson.M{"$exists": true},
+	}
+
+	if err := handler.purgeMatchingFolders(r.Context(), filter); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := handler.removeMatchingFiles(r.Context(), filter); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	utils.WriteJSON(w, "trash emptied successfully")
+}
+
+func (handler *Handler) purgeExpiredTrash() {
+	filter := bson.M{
+		"purge_at": bson.M{"$lt": time.Now()},
+	}
+
+	if err := handler.purgeMatchingFolders(context.Background(), filter); err != nil {
+		slog.Error("purging trashed folders", "error", err)
+	}
+
+	if err := handler.removeMatchingFiles(context.Background(), filter); err != nil {
+		slog.Error("purging trashed files", "error", err)
+	}
+}
+
+func (handler *Handler) purgeMatchingFolders(ctx context.Context, filter bson.M) error {
+	for {
+		folders, err := handler.Models.Folder.GetAll(filter, 1, expiredFilesBatchSize)
+		if err != nil {
+			return err
+		}
+
+		var purged int
+		for _, folder := range folders {
+			if err := handler.purgeFolder(ctx, folder.Id); err != nil {
+				slog.Error("purging folder", "id", folder.Id.Hex(), "error", err)
+				continue
+			}
+
+			purged++
+		}
+
+		if len(folders) < expiredFilesBatchSize || purged == 0 {
+			return nil
+		}
+	}
+}
+
+// purgeFolder -> Nothing can be uploaded into (or moved under) a trashed folder, so all of its subfolders and files are trashed too
+func (handler *Handler) purgeFolder(ctx context.Context, folderId primitive.ObjectID) error {
+	filter := bson.M{
+		"parent_id": folderId,
+	}
+
+	projection := bson.M{
+		"_id": 1,
+	}
+
+	subfolders, err := handler.Models.Folder.Find(filter, projection)
+	if err != nil {
+		return err
+	}
+
+	for _, subfolder := range subfolders {
+		if err := handler.purgeFolder(ctx, subfolder.Id); err != nil {
+			return err
+		}
+	}
+
+	filter = bson.M{
+		"folder_id": folderId,
+	}
+
+	if err := handler.removeMatchingFiles(ctx, filter); err != nil {
+		return err
+	}
+
+	// the folder is kept if one of its files could not be removed, so the files are retried later
+	files, err := handler.Models.File.GetAll(filter, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	if len(files) != 0 {
+		return fmt.Errorf("some files of folder %s could not be removed", folderId.Hex())
+	}
+
+	if err := handler.Models.Folder.Delete(folderId); err != nil {
+		return err
+	}
+
+	filter = bson.M{
+		"folder_id": folderId,
+	}
+
+	if _, err := handler.Models.FolderSettings.DeleteAll(filter); err != nil {
+		return fmt.Errorf("removing folder settings: %w", err)
+	}
+
+	if _, err := handler.Models.Share.DeleteAll(filter); err != nil {
+		return fmt.Errorf("removing folder shares: %w", err)
+	}
+
+	return nil
+}
+
+// getTrashPurgeDate -> The retention depends on the team`s plan for team files, on the user`s plan otherwise
+func (handler *Handler) getTrashPurgeDate(teamId primitive.ObjectID, userPlan string) (time.Time, error) {
+	if teamId == primitive.NilObjectID {
+		return utils.GetUserTrashPurgeDate(userPlan), nil
+	}
+
+	filter := bson.M{
+		"_id": teamId,
+	}
+
+	projection := bson.M{
+		"plan": 1,
+	}
+
+	teamInstance, err := handler.Models.Team.Get(filter, projection)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return utils.GetTeamTrashPurgeDate(teamInstance.Plan), nil
+}
+
+func (handler *Handler) getTrashedFile(r *http.Request, userId string) (*models.File, error) {
+	fileId, err := utils.ParseIdParam(r.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	fileObjectId, err := utils.ToObjectID(fileId)
+	if err != nil {
+		return nil, err
+	}
+
+	userObjectId, err := utils.ToObjectID(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{
+		"_id":        fileObjectId,
+		"owner_id":   userObjectId,
+		"deleted_at": bson.M{"$exists": true},
+	}
+
+	projection := bson.M{
+		"owner_id":  1,
+		"team_id":   1,
+		"folder_id": 1,
+		"address":   1,
+		"checksum":  1,
+		"size":      1,
+	}
+
+	return handler.Models.File.Get(filter, projection)
+}
+
+func (handler *Handler) getTrashedFolder(r *http.Request, userId string) (*models.Folder, error) {
+	folderId, err := utils.ParseIdParam(r.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	folderObjectId, err := utils.ToObjectID(folderId)
+	if err != nil {
+		return nil, err
+	}
+
+	userObjectId, err := utils.ToObjectID(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{
+		"_id":        folderObjectId,
+		"owner_id":   userObjectId,
+		"deleted_at": bson.M{"$exists": true},
+	}
+
+	projection := bson.M{
+		"_id":        1,
+		"parent_id":  1,
+		"deleted_at": 1,
+	}
+
+	return handler.Models.Folder.Get(filter, projection)
+}
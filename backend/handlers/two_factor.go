@@ -0,0 +1,430 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"errors"
+	"file_manager/database/models"
+	"file_manager/utils"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	twoFactorChallengeLifetime = 5 * time.Minute
+	maxTotpFailures            = 5
+	totpLockDuration           = 15 * time.Minute
+	recoveryCodesCount         = 10
+	recoveryCodeGroups         = 4 // of 5 base32 characters: 100 random bits, too many to guess even from the sha256 hashes
+)
+
+var errSecondFactorRequired = errors.New("a two-factor code is required")
+
+// EnrollTwoFactor -> Generates a new TOTP secret. It is not used before EnableTwoFactor verifies a first code,
+// so enrolling again (e.g. the QR code was lost) simply replaces it
+func (handler *Handler) EnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	userObjectId, err := utils.ToObjectID(payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	filter := bson.M{
+		"_id": userObjectId,
+	}
+
+	projection := bson.M{
+		"username":     1,
+		"totp_enabled": 1,
+	}
+
+	user, err := handler.Models.User.Get(filter, projection)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if user.TotpEnabled {
+		utils.WriteError(w, http.StatusBadRequest, "two-factor authentication is enabled already, disable it first")
+		return
+	}
+
+	secret, err := utils.GenerateTotpSecret()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	wrappedSecret, err := handler.KeyRing.WrapKey(secret)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	updates := bson.M{
+		"totp_secret": wrappedSecret,
+		"totp_key_id": handler.KeyRing.ActiveKeyId(),
+	}
+
+	if err := handler.Models.User.Update(userObjectId, updates); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	response := map[string]any{
+		"secret":      utils.EncodeTotpSecret(secret),
+		"otpauth_uri": utils.GetTotpUri(user.Username, secret),
+	}
+
+	utils.WriteJSONData(w, response)
+}
+
+// EnableTwoFactor -> Turns two-factor authentication on once a code of the enrolled secret is verified.
+// Returns the recovery codes, they are shown only this once
+func (handler *Handler) EnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	userObjectId, err := utils.ToObjectID(payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	if err := utils.ParseJSON(r.Body, 1000, &input); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	filter := bson.M{
+		"_id": userObjectId,
+	}
+
+	projection := bson.M{
+		"totp_enabled": 1,
+		"totp_secret":  1,
+		"totp_key_id":  1,
+	}
+
+	user, err := handler.Models.User.Get(filter, projection)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if user.TotpEnabled {
+		utils.WriteError(w, http.StatusBadRequest, "two-factor authentication is enabled already")
+		return
+	}
+
+	if user.TotpSecret == "" {
+		utils.WriteError(w, http.StatusBadRequest, "enroll first")
+		return
+	}
+
+	secret, err := handler.KeyRing.UnwrapKey(user.TotpKeyId, user.TotpSecret)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	step, valid := utils.ValidateTotp(secret, strings.TrimSpace(input.Code), time.Now())
+	if !valid {
+		utils.WriteError(w, http.StatusBadRequest, "invalid two-factor code")
+		return
+	}
+
+	if _, err := handler.Models.User.UseTotpStep(userObjectId, step); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	recoveryCodes, hashedCodes := newRecoveryCodes()
+
+	updates := bson.M{
+		"totp_enabled":   true,
+		"recovery_codes": hashedCodes,
+	}
+
+	if err := handler.Models.User.Update(userObjectId, updates); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	utils.WriteJSONData(w, map[string]any{"recovery_codes": recoveryCodes})
+}
+
+// DisableTwoFactor -> Needs the password (none for the single sign-on users) and a code (or a recovery code)
+func (handler *Handler) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	userObjectId, err := utils.ToObjectID(payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var input struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+
+	if err := utils.ParseJSON(r.Body, 1000, &input); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	filter := bson.M{
+		"_id": userObjectId,
+	}
+
+	projection := bson.M{
+		"hashed_password": 1,
+		"salt":            1,
+	}
+
+	user, err := handler.Models.User.Get(filter, projection)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// the single sign-on users have no password, the second factor (checked below) stands for it
+	if user.HashedPassword != "" {
+		if _, err := utils.CheckPassword(input.Password, user.HashedPassword, user.Salt); err != nil {
+			utils.WriteError(w, http.StatusBadRequest, "password is incorrect")
+			return
+		}
+	}
+
+	if err := handler.checkSecondFactor(userObjectId, input.Code); err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	updates := bson.M{
+		"totp_enabled":   false,
+		"totp_secret":    "",
+		"totp_key_id":    "",
+		"recovery_codes": []string{},
+	}
+
+	if err := handler.Models.User.Update(userObjectId, updates); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	utils.WriteJSON(w, "two-factor authentication disabled successfully")
+}
+
+// RegenerateRecoveryCodes -> Replaces all the recovery codes (the remaining old ones stop working)
+func (handler *Handler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	userObjectId, err := utils.ToObjectID(payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	if err := utils.ParseJSON(r.Body, 1000, &input); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	filter := bson.M{
+		"_id": userObjectId,
+	}
+
+	user, err := handler.Models.User.Get(filter, bson.M{"totp_enabled": 1})
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !user.TotpEnabled {
+		utils.WriteError(w, http.StatusBadRequest, "two-factor authentication is not enabled")
+		return
+	}
+
+	if err := handler.checkSecondFactor(userObjectId, input.Code); err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	recoveryCodes, hashedCodes := newRecoveryCodes()
+
+	if err := handler.Models.User.Update(userObjectId, bson.M{"recovery_codes": hashedCodes}); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	utils.WriteJSONData(w, map[string]any{"recovery_codes": recoveryCodes})
+}
+
+// VerifyTwoFactorLogin -> The second login step: exchanges the challenge of Login and a code (or a recovery code)
+// for the session tokens
+func (handler *Handler) VerifyTwoFactorLogin(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+	}
+
+	if err := utils.ParseJSON(r.Body, 10000, &input); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("error reading json: %w", err))
+		return
+	}
+
+	challenge, err := handler.PasetoMaker.VerifyChallengeToken(input.ChallengeToken)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, "invalid or expired challenge, please log in again")
+		return
+	}
+
+	userObjectId, err := utils.ToObjectID(challenge.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := handler.checkSecondFactor(userObjectId, input.Code); err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	filter := bson.M{
+		"_id": userObjectId,
+	}
+
+	projection := bson.M{
+		"username":   1,
+		"plan":       1,
+		"avatar_url": 1,
+	}
+
+	user, err := handler.Models.User.Get(filter, projection)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	handler.writeLoginResponse(w, user)
+}
+
+// checkSecondFactor -> Verifies a fresh TOTP code (each time step is accepted once) or uses up a recovery code.
+// Passes for the users without two-factor authentication. Too many wrong codes lock it for a while
+func (handler *Handler) checkSecondFactor(userId primitive.ObjectID, code string) error {
+	filter := bson.M{
+		"_id": userId,
+	}
+
+	projection := bson.M{
+		"totp_enabled":      1,
+		"totp_secret":       1,
+		"totp_key_id":       1,
+		"totp_locked_until": 1,
+	}
+
+	user, err := handler.Models.User.Get(filter, projection)
+	if err != nil {
+		return err
+	}
+
+	if !user.TotpEnabled {
+		return nil
+	}
+
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return errSecondFactorRequired
+	}
+
+	if time.Now().Before(user.TotpLockedUntil) {
+		return fmt.Errorf("too many wrong codes, try again after %s", user.TotpLockedUntil.Format(time.RFC3339))
+	}
+
+	if verified, err := handler.verifySecondFactorCode(user, code); err != nil || verified {
+		return err
+	}
+
+	if err := handler.Models.User.RecordTotpFailure(userId, maxTotpFailures, totpLockDuration); err != nil {
+		slog.Error("recording two-factor failure", "user_id", userId.Hex(), "error", err)
+	}
+
+	return errors.New("invalid two-factor code")
+}
+
+// verifySecondFactorCode -> The 6 digit codes are TOTP codes, the rest recovery codes
+func (handler *Handler) verifySecondFactorCode(user *models.User, code string) (bool, error) {
+	if len(code) != 6 || strings.Trim(code, "0123456789") != "" {
+		return handler.Models.User.UseRecoveryCode(user.Id, hashToken(normalizeRecoveryCode(code)))
+	}
+
+	secret, err := handler.KeyRing.UnwrapKey(user.TotpKeyId, user.TotpSecret)
+	if err != nil {
+		return false, err
+	}
+
+	step, valid := utils.ValidateTotp(secret, code, time.Now())
+	if !valid {
+		return false, nil
+	}
+
+	// false for a replayed code
+	return handler.Models.User.UseTotpStep(user.Id, step)
+}
+
+// newRecoveryCodes -> Returns the codes ("XXXXX-XXXXX-XXXXX-XXXXX") and their hashes
+func newRecoveryCodes() ([]string, []string) {
+	codes := make([]string, 0, recoveryCodesCount)
+	hashes := make([]string, 0, recoveryCodesCount)
+
+	for range recoveryCodesCount {
+		text := rand.Text() // 26 base32 characters
+
+		groups := make([]string, 0, recoveryCodeGroups)
+		for i := range recoveryCodeGroups {
+			groups = append(groups, text[i*5:(i+1)*5])
+		}
+
+		code := strings.Join(groups, "-")
+
+		codes = append(codes, code)
+		hashes = append(hashes, hashToken(normalizeRecoveryCode(code)))
+	}
+
+	return codes, hashes
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestNewRecoveryCodes(t *testing.T) {
+	codeFormat := regexp.MustCompile(`^[A-Z2-7]{5}(-[A-Z2-7]{5}){3}$`)
+
+	codes, hashes := newRecoveryCodes()
+	if len(codes) != recoveryCodesCount || len(hashes) != recoveryCodesCount {
+		t.Fatalf("newRecoveryCodes() = %d codes, %d hashes, want %d", len(codes), len(hashes), recoveryCodesCount)
+	}
+
+	seen := make(map[string]bool)
+	for i, code := range codes {
+		if !codeFormat.MatchString(code) {
+			t.Errorf("newRecoveryCodes() code = %q, want XXXXX-XXXXX-XXXXX-XXXXX", code)
+		}
+
+		if seen[code] {
+			t.Errorf("newRecoveryCodes() gives %q twice", code)
+		}
+		seen[code] = true
+
+		// the codes are typed back in any case, with or without the dashes
+		typed := strings.ToLower(strings.ReplaceAll(code, "-", " "))
+		if hashes[i] != hashToken(normalizeRecoveryCode(typed)) {
+			t.Errorf("the hash of %q does not match the typed %q", code, typed)
+		}
+	}
+}
@@ -0,0 +1,559 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"file_manager/database/models"
+	"file_manager/encryption"
+	"file_manager/storage"
+	"file_manager/utils"
+	"fmt"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// unfinished sessions are removed after this duration of inactivity
+const uploadSessionLifetime = 24 * time.Hour
+
+func (handler *Handler) CreateUploadSession(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	var input struct {
+		FileName  string `json:"file_name"`
+		TotalSize int64  `json:"total_size"`
+		FolderId  string `json:"folder_id"`
+		TeamId    string `json:"team_id"`
+	}
+
+	if err := utils.ParseJSON(r.Body, 1000, &input); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if input.TotalSize <= 0 {
+		utils.WriteError(w, http.StatusBadRequest, "'total_size' must be greater than zero")
+		return
+	}
+
+	if input.FileName == "" {
+		input.FileName = uuid.New().String()
+	}
+
+	userObjectId, err := utils.ToObjectID(payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var folderObjectId primitive.ObjectID
+	if input.FolderId != "" {
+		folderObjectId, err = utils.ToObjectID(input.FolderId)
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	if err := handler.ValidateFolderId(folderObjectId, userObjectId); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var teamObjectId primitive.ObjectID
+	if input.TeamId != "" {
+		teamObjectId, err = utils.ToObjectID(input.TeamId)
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	// checking the quota before any byte is uploaded, it will be checked again on finalize
+	if err := handler.checkUploadSessionQuota(teamObjectId, userObjectId, payload.UserPlan, input.TotalSize); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// the chunks are encrypted as well, the plain text is never stored
+	_, wrappedKey, err := handler.KeyRing.NewDataKey()
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	expireAt := time.Now().Add(uploadSessionLifetime)
+
+	sessionId, err := handler.Models.UploadSession.Create(userObjectId, teamObjectId, folderObjectId, input.FileName,
+		input.TotalSize, handler.KeyRing.ActiveKeyId(), wrappedKey, expireAt)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("creating upload session: %w", err))
+		return
+	}
+
+	response := map[string]any{
+		"id":         sessionId.Hex(),
+		"offset":     0,
+		"total_size": input.TotalSize,
+		"expire_at":  expireAt,
+	}
+
+	utils.WriteJSONData(w, response)
+}
+
+// GetUploadSession -> Returns the current offset, so the client knows where to resume from
+func (handler *Handler) GetUploadSession(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	session, err := handler.getUserUploadSession(r, payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(session.TotalSize, 10))
+	w.Header().Set("Cache-Control", "no-store")
+
+	utils.WriteJSONData(w, session)
+}
+
+// UploadChunk -> The request body is the raw chunk, the 'Upload-Offset' header must match the current offset
+func (handler *Handler) UploadChunk(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	session, err := handler.getUserUploadSession(r, payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, "'Upload-Offset' header is missing or invalid")
+		return
+	}
+
+	if offset != session.Offset {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+		utils.WriteError(w, http.StatusConflict, fmt.Sprintf("offset mismatch. Current offset is: %d", session.Offset))
+		return
+	}
+
+	remainedSize := session.TotalSize - session.Offset
+	if remainedSize <= 0 {
+		utils.WriteError(w, http.StatusBadRequest, "all the bytes are uploaded already. Please finalize the session")
+		return
+	}
+
+	bodyReader := bufio.NewReader(http.MaxBytesReader(w, r.Body, remainedSize))
+
+	// the first chunk decides the content type of the whole file
+	var contentType string
+	if session.Offset == 0 {
+		header, err := bodyReader.Peek(512)
+		if err != nil && len(header) == 0 {
+			utils.WriteError(w, http.StatusBadRequest, "chunk is empty")
+			return
+		}
+
+		contentType, err = utils.ValidateContentType(header, getAllowedTypes(session.TeamId))
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	sessionKey, err := handler.getUploadSessionKey(session)
+	if err != nil {
+		utils.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	chunkKey := getUploadSessionDir(session.Id.Hex()) + fmt.Sprintf("%020d-%s", session.Offset, rand.Text())
+	chunkReader := &utils.CountingReader{Reader: bodyReader}
+
+	var storedReader io.Reader = chunkReader
+	if sessionKey != nil {
+		storedReader, err = encryption.NewEncryptingReader(chunkReader, getChunkDataKey(sessionKey, chunkKey))
+		if err != nil {
+			utils.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	if err := handler.Storage.Put(r.Context(), chunkKey, storedReader, -1); err != nil {
+		handler.deleteObject(chunkKey)
+		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("storing chunk: %w", err))
+		return
+	}
+
+	if chunkReader.Count == 0 {
+		handler.deleteObject(chunkKey)
+		utils.WriteError(w, http.StatusBadRequest, "chunk is empty")
+		return
+	}
+
+	expireAt := time.Now().Add(uploadSessionLifetime)
+
+	if err := handler.Models.UploadSession.AppendChunk(session.Id, session.Offset, chunkReader.Count, chunkKey, contentType,
+		expireAt); err != nil {
+
+		handler.deleteObject(chunkKey)
+		utils.WriteError(w, http.StatusConflict, err)
+		return
+	}
+
+	newOffset := session.Offset + chunkReader.Count
+	w.Header().Set("Upload-Offset", strconv.FormatInt(newOffset, 10))
+
+	utils.WriteJSONData(w, map[string]any{"offset": newOffset})
+}
+
+// FinalizeUploadSession -> Concatenates the chunks into the final file and creates the file instance
+func (handler *Handler) FinalizeUploadSession(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	session, err := handler.getUserUploadSession(r, payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if session.Offset != session.TotalSize {
+		utils.WriteError(w, http.StatusBadRequest, fmt.Sprintf("upload is not complete yet. %d of %d bytes received",
+			session.Offset, session.TotalSize))
+		return
+	}
+
+	// concurrent finalizes (or a retry after a timeout) would each charge the storage and create the file
+	claimed, err := handler.Models.UploadSession.ClaimFinalize(session.Id)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !claimed {
+		utils.WriteError(w, http.StatusConflict, "upload session is being finalized already")
+		return
+	}
+
+	if session.TeamId != primitive.NilObjectID {
+		err = handler.finalizeTeamUploadSession(r.Context(), session)
+	} else {
+		err = handler.finalizeUserUploadSession(r.Context(), session, payload.UserPlan)
+	}
+
+	if err != nil {
+		if err := handler.Models.UploadSession.ReleaseFinalize(session.Id); err != nil {
+			slog.Error("releasing upload session", "id", session.Id.Hex(), "error", err)
+		}
+
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := handler.removeUploadSession(context.Background(), session.Id); err != nil {
+		slog.Error("removing finalized upload session", "id", session.Id.Hex(), "error", err)
+	}
+
+	utils.WriteJSON(w, "file uploaded successfully")
+}
+
+func (handler *Handler) DeleteUploadSession(w http.ResponseWriter, r *http.Request) {
+	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	session, err := handler.getUserUploadSession(r, payload.UserId)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if session.Finalizing {
+		utils.WriteError(w, http.StatusConflict, "upload session is being finalized")
+		return
+	}
+
+	if err := handler.removeUploadSession(r.Context(), session.Id); err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	utils.WriteJSON(w, "upload session deleted successfully")
+}
+
+// StartUploadSessionsCleaner -> Periodically removes the sessions which are never finished
+func (handler *Handler) StartUploadSessionsCleaner(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			handler.cleanExpiredUploadSessions()
+		}
+	}()
+}
+
+func (handler *Handler) cleanExpiredUploadSessions() {
+	filter := bson.M{
+		"expire_at": bson.M{"$lt": time.Now()},
+	}
+
+	projection := bson.M{
+		"_id": 1,
+	}
+
+	sessions, err := handler.Models.UploadSession.GetAll(filter, projection)
+	if err != nil {
+		slog.Error("retrieving expired upload sessions", "error", err)
+		return
+	}
+
+	for _, session := range sessions {
+		if err := handler.removeUploadSession(context.Background(), session.Id); err != nil {
+			slog.Error("removing expired upload session", "id", session.Id.Hex(), "error", err)
+		}
+	}
+}
+
+// finalizeUserUploadSession -> The size is reserved before assembling, so a concurrent upload can`t take the storage meanwhile
+func (handler *Handler) finalizeUserUploadSession(ctx context.Context, session *models.UploadSession, userPlan string) error {
+	if err := handler.chargeStorage(session.OwnerId, nil, userPlan, session.TotalSize); err != nil {
+		return err
+	}
+
+	content, err := handler.assembleUploadSession(ctx, session)
+	if err != nil {
+		_ = handler.returnStorage(session.OwnerId, primitive.NilObjectID, session.TotalSize)
+		return err
+	}
+
+	expireAt := utils.GetUserExpirationDate(userPlan)
+
+	if _, err := handler.Models.File.Create(session.OwnerId, primitive.NilObjectID, session.FolderId, session.FileName,
+		*content, expireAt); err != nil {
+
+		handler.discardUpload(content, session.OwnerId, primitive.NilObjectID)
+		return fmt.Errorf("creating file instance: %w", err)
+	}
+
+	return nil
+}
+
+func (handler *Handler) finalizeTeamUploadSession(ctx context.Context, session *models.UploadSession) error {
+	teamInstance, err := handler.getTeamForUpload(session.TeamId, session.OwnerId)
+	if err != nil {
+		return err
+	}
+
+	if err := handler.chargeStorage(session.OwnerId, teamInstance, "", session.TotalSize); err != nil {
+		return err
+	}
+
+	content, err := handler.assembleUploadSession(ctx, session)
+	if err != nil {
+		_ = handler.returnStorage(session.OwnerId, session.TeamId, session.TotalSize)
+		return err
+	}
+
+	expireAt := utils.GetTeamExpirationDate(teamInstance.Plan)
+
+	if _, err := handler.Models.File.Create(session.OwnerId, session.TeamId, session.FolderId, session.FileName,
+		*content, expireAt); err != nil {
+
+		handler.discardUpload(content, session.OwnerId, session.TeamId)
+		return fmt.Errorf("creating file instance: %w", err)
+	}
+
+	return nil
+}
+
+func (handler *Handler) assembleUploadSession(ctx context.Context, session *models.UploadSession) (*models.FileContent, error) {
+	sessionKey, err := handler.getUploadSessionKey(session)
+	if err != nil {
+		return nil, err
+	}
+
+	chunksReader := storage.NewMultiObjectReader(ctx, session.Chunks, func(ctx context.Context, chunkKey string) (io.ReadCloser, error) {
+		return handler.openUploadChunk(ctx, sessionKey, chunkKey)
+	})
+	defer chunksReader.Close()
+
+	blob, err := handler.storeBlob(ctx, chunksReader)
+	if err != nil {
+		return nil, fmt.Errorf("assembling the uploaded chunks: %w", err)
+	}
+
+	return newFileContent(blob, session.FileName, session.ContentType), nil
+}
+
+// checkUploadSessionQuota -> Same checks as UploadUserFile and UploadTeamFile
+func (handler *Handler) checkUploadSessionQuota(teamId, userId primitive.ObjectID, userPlan string, totalSize int64) error {
+	if teamId == primitive.NilObjectID {
+		if maxUploadSize := utils.GetUserMaxUploadSize(userPlan); totalSize > maxUploadSize {
+			return fmt.Errorf("your file size (%d bytes) exceeds your plan's max upload size (%d bytes)", totalSize, maxUploadSize)
+		}
+
+		_, err := handler.IsUserEligibleToUpload(userId.Hex(), userPlan, totalSize)
+		return err
+	}
+
+	teamInstance, err := handler.getTeamForUpload(teamId, userId)
+	if err != nil {
+		return err
+	}
+
+	if maxUploadSize := utils.GetTeamMaxUploadSize(teamInstance.Plan); totalSize > maxUploadSize {
+		return fmt.Errorf("your file size (%d bytes) exceeds the team plan's max upload size (%d bytes)", totalSize, maxUploadSize)
+	}
+
+	_, err = handler.isTeamEligibleToUpload(teamInstance.Plan, teamInstance.StorageUsed, totalSize)
+	return err
+}
+
+func (handler *Handler) getTeamForUpload(teamId, userId primitive.ObjectID) (*models.Team, error) {
+	filter := bson.M{
+		"_id": teamId,
+	}
+
+	projection := bson.M{
+		"plan":         1,
+		"storage_used": 1,
+		"users":        1,
+	}
+
+	teamInstance, err := handler.Models.Team.Get(filter, projection)
+	if err != nil {
+		return nil, err
+	}
+
+	if !slices.Contains(teamInstance.Users, userId) {
+		return nil, errors.New("you are not member of this team")
+	}
+
+	return teamInstance, nil
+}
+
+func (handler *Handler) getUserUploadSession(r *http.Request, userId string) (*models.UploadSession, error) {
+	sessionId, err := utils.ParseIdParam(r.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	sessionObjectId, err := utils.ToObjectID(sessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	userObjectId, err := utils.ToObjectID(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{
+		"_id":      sessionObjectId,
+		"owner_id": userObjectId,
+	}
+
+	return handler.Models.UploadSession.Get(filter, bson.M{})
+}
+
+// getUploadSessionKey -> The data key of the session`s chunks, nil for the sessions created before they were encrypted
+func (handler *Handler) getUploadSessionKey(session *models.UploadSession) ([]byte, error) {
+	if session.WrappedKey == "" {
+		return nil, nil
+	}
+
+	return handler.KeyRing.UnwrapKey(session.KeyId, session.WrappedKey)
+}
+
+// openUploadChunk -> The plain text of a stored chunk, sessionKey is nil for the plain text chunks
+func (handler *Handler) openUploadChunk(ctx context.Context, sessionKey []byte, chunkKey string) (io.ReadCloser, error) {
+	object, err := handler.Storage.Get(ctx, chunkKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if sessionKey == nil {
+		return object, nil
+	}
+
+	reader, err := encryption.NewDecryptingReader(object, getChunkDataKey(sessionKey, chunkKey))
+	if err != nil {
+		object.Close()
+		return nil, err
+	}
+
+	return reader, nil
+}
+
+// getChunkDataKey -> Every chunk is a separate encrypted stream (its nonces start over), so each one needs its own key.
+// The chunk keys are unique within the session
+func getChunkDataKey(sessionKey []byte, chunkKey string) []byte {
+	dataKey := sha256.Sum256(append(slices.Clone(sessionKey), chunkKey...))
+	return dataKey[:]
+}
+
+// removeUploadSession -> Removes every stored chunk (including the orphan ones) and the session itself
+func (handler *Handler) removeUploadSession(ctx context.Context, sessionId primitive.ObjectID) error {
+	chunks, err := handler.Storage.List(ctx, getUploadSessionDir(sessionId.Hex()))
+	if err != nil {
+		return err
+	}
+
+	for _, chunk := range chunks {
+		if err := handler.Storage.Delete(ctx, chunk.Key); err != nil {
+			return err
+		}
+	}
+
+	return handler.Models.UploadSession.Delete(sessionId)
+}
+
+// deleteObject -> Best effort removal, used for rolling back partially stored objects
+func (handler *Handler) deleteObject(key string) {
+	if err := handler.Storage.Delete(context.Background(), key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		slog.Error("removing stored object", "key", key, "error", err)
+	}
+}
+
+func getAllowedTypes(teamId primitive.ObjectID) []string {
+	if teamId != primitive.NilObjectID {
+		return teamFileAllowedTypes
+	}
+
+	return userFileAllowedTypes
+}
+
+func getUploadSessionDir(sessionId string) string {
+	return "uploads/sessions/" + sessionId + "/"
+}
@@ -1,16 +1,14 @@
 package handlers
 
 import (
-	"encoding/hex"
+	"context"
 	"errors"
 	"file_manager/utils"
 	"fmt"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 	"log/slog"
 	"net/http"
-	"os"
 )
 
 func (handler *Handler) GetUser(w http.ResponseWriter, r *http.Request) {
@@ -79,7 +77,23 @@ func (handler *Handler) UpdateUserPlan(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	utils.WriteJSON(w, "user`s plan updated successfully")
+	// the access tokens carry the plan: the other sessions have to refresh theirs, this one gets a new one right away
+	filter := bson.M{
+		"user_id":         userObjectId,
+		"access_token_id": bson.M{"$ne": payload.ID.String()},
+	}
+
+	if _, err := handler.Models.Session.UpdateAll(filter, bson.M{"access_token_id": ""}); err != nil {
+		slog.Error("revoking access tokens", "user_id", payload.UserId, "error", err)
+	}
+
+	tokens, err := handler.reissueAccessToken(payload, input.Plan)
+	if err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	utils.WriteJSONData(w, tokens)
 }
 
 func (handler *Handler) IsUserEligibleToUpload(userId, userPlan string, fileSize int64) (int64, error) {
@@ -106,6 +120,20 @@ func (handler *Handler) IsUserEligibleToUpload(userId, userPlan string, fileSize
 	return newTotalStorage, nil
 }
 
+func (handler *Handler) getUserRemainedStorage(userId, userPlan string) (int64, error) {
+	totalStorage, err := utils.GetUserTotalStorage(userPlan)
+	if err != nil {
+		return 0, err
+	}
+
+	usedStorage, err := handler.getUsedStorage(userId)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check used storage: %w", err)
+	}
+
+	return max(totalStorage-usedStorage, 0), nil
+}
+
 func (handler *Handler) UploadUserAvatar(w http.ResponseWriter, r *http.Request) {
 	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
 	if err != nil {
@@ -114,10 +142,7 @@ func (handler *Handler) UploadUserAvatar(w http.ResponseWriter, r *http.Request)
 	}
 
 	var maxAvatarSize int64 = 5 << 20 // 5 MB
-	if err := r.ParseMultipartForm(maxAvatarSize); err != nil {
-		utils.WriteError(w, http.StatusBadRequest, err)
-		return
-	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxAvatarSize+MegaBytes)
 
 	userObjectId, err := utils.ToObjectID(payload.UserId)
 	if err != nil {
@@ -149,7 +174,7 @@ func (handler *Handler) UploadUserAvatar(w http.ResponseWriter, r *http.Request)
 
 	uploadDir := getUserAvatarUploadDir(payload.UserId)
 
-	fileAddress, err := file.UploadToDisk(uploadDir)
+	fileAddress, err := file.UploadToStorage(r.Context(), handler.Storage, uploadDir)
 	if err != nil {
 		utils.WriteError(w, http.StatusBadRequest, err)
 		return
@@ -160,7 +185,7 @@ func (handler *Handler) UploadUserAvatar(w http.ResponseWriter, r *http.Request)
 	}
 
 	if user.AvatarUrl != "" {
-		if err := os.Remove(user.AvatarUrl); err != nil {
+		if err := handler.Storage.Delete(r.Context(), user.AvatarUrl); err != nil {
 			utils.WriteError(w, http.StatusBadRequest, err)
 			return
 		}
@@ -194,8 +219,9 @@ func (handler *Handler) SearchUserContents(w http.ResponseWriter, r *http.Reques
 	}
 
 	filter := bson.M{
-		"name":     bson.M{"$regex": searchQuery},
-		"owner_id": userObjectId,
+		"name":       bson.M{"$regex": searchQuery},
+		"owner_id":   userObjectId,
+		"deleted_at": bson.M{"$exists": false},
 	}
 
 	files, err := handler.Models.File.GetAll(filter, 1, 10)
@@ -204,28 +230,10 @@ func (handler *Handler) SearchUserContents(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	shortUrls := map[string]any{}
-
-	for _, file := range files {
-		filter := bson.M{
-			"file_id": file.Id,
-		}
-
-		projection := bson.M{
-			"short_url": 1,
-		}
-
-		setting, err := handler.Models.FileSettings.Get(filter, projection)
-		if err != nil {
-			if errors.Is(err, mongo.ErrNoDocuments) {
-				continue
-			}
-
-			utils.WriteError(w, http.StatusBadRequest, err)
-			return
-		}
-
-		shortUrls[file.Id.Hex()] = setting.ShortUrl
+	shareLinks, err := handler.getShareLinks(files)
+	if err != nil {
+		utils.WriteError(w, http.StatusBadRequest, err)
+		return
 	}
 
 	folders, err := handler.Models.Folder.GetAll(filter, 1, 10)
@@ -235,9 +243,9 @@ func (handler *Handler) SearchUserContents(w http.ResponseWriter, r *http.Reques
 	}
 
 	response := map[string]any{
-		"files":     files,
-		"folders":   folders,
-		"shortUrls": shortUrls,
+		"files":       files,
+		"folders":     folders,
+		"share_links": shareLinks,
 	}
 	
 	utils.WriteJSONData(w, response)
@@ -269,6 +277,8 @@ func (handler *Handler) getUsedStorage(userId string) (int64, error) {
 	return userInstance.TotalUploadSize, nil
 }
 
+// DeleteUserAccount -> Needs the password, or for the single sign-on users a login of the last minutes (unless they
+// have the second factor). The second factor is needed as well when it is enabled
 func (handler *Handler) DeleteUserAccount(w http.ResponseWriter, r *http.Request) {
 	payload, err := utils.CheckAuth(r, handler.PasetoMaker)
 	if err != nil {
@@ -284,6 +294,7 @@ func (handler *Handler) DeleteUserAccount(w http.ResponseWriter, r *http.Request
 
 	var input struct {
 		Password string `json:"password"`
+		Code     string `json:"code"` // for the users with two-factor authentication
 	}
 
 	filter := bson.M{
@@ -294,6 +305,7 @@ func (handler *Handler) DeleteUserAccount(w http.ResponseWriter, r *http.Request
 		"hashed_password": 1,
 		"salt":            1,
 		"avatar_url":      1,
+		"totp_enabled":    1,
 	}
 
 	user, err := handler.Models.User.Get(filter, projection)
@@ -307,61 +319,93 @@ func (handler *Handler) DeleteUserAccount(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	if input.Password == "" {
-		utils.WriteError(w, http.StatusBadRequest, "you must enter your password")
+	switch {
+	case user.HashedPassword != "":
+		if input.Password == "" {
+			utils.WriteError(w, http.StatusBadRequest, "you must enter your password")
+			return
+		}
+
+		needsRehash, err := utils.CheckPassword(input.Password, user.HashedPassword, user.Salt)
+		if err != nil {
+			utils.WriteError(w, http.StatusBadRequest, "password is incorrect")
+			return
+		}
+
+		if needsRehash {
+			handler.rehashPassword(input.Password, userObjectId, handler.Models.User.Update)
+		}
+
+	// the single sign-on users have no password, the second factor (checked below) or a fresh login stands for it
+	case !user.TotpEnabled:
+		if err := handler.checkRecentLogin(payload); err != nil {
+			utils.WriteError(w, http.StatusForbidden, err)
+			return
+		}
+	}
+
+	// a fresh second factor, a stolen session is not enough
+	if err := handler.checkSecondFactor(userObjectId, input.Code); err != nil {
+		utils.WriteError(w, http.StatusUnauthorized, err)
 		return
 	}
 
-	decodedHashPassword, err := hex.DecodeString(user.HashedPassword)
-	if err != nil {
+	// the files first, their space is given back to the user (or the team)
+	if err := handler.removeUserFiles(r.Context(), userObjectId); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	decodedSalt, err := hex.DecodeString(user.Salt)
-	if err != nil {
+	if err := handler.Models.User.Delete(userObjectId); err != nil {
 		utils.WriteError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	if !utils.ValidateHash([]byte(input.Password), decodedHashPassword, decodedSalt) {
-		utils.WriteError(w, http.StatusBadRequest, "password is incorrect")
-		return
+	if err := handler.Storage.Delete(r.Context(), user.AvatarUrl); err != nil {
+		slog.Error("removing user avatar", "error", err)
 	}
 
-	if err := handler.Models.User.Delete(userObjectId); err != nil {
-		utils.WriteError(w, http.StatusBadRequest, err)
-		return
+	// the shares the user created and the ones with the user
+	filter = bson.M{
+		"$or": bson.A{
+			bson.M{"owner_id": userObjectId},
+			bson.M{"user_id": userObjectId},
+		},
 	}
 
-	if err := handler.removeUserFilesAndAvatar(userObjectId, user.AvatarUrl); err != nil {
-		utils.WriteError(w, http.StatusBadRequest, err)
-		return
+	if _, err := handler.Models.Share.DeleteAll(filter); err != nil {
+		slog.Error("removing user shares", "error", err)
 	}
 
-	utils.WriteJSON(w, "user deleted successfully")
-}
+	if _, err := handler.Models.Session.DeleteAll(bson.M{"user_id": userObjectId}); err != nil {
+		slog.Error("removing user sessions", "error", err)
+	}
 
-func (handler *Handler) removeUserFilesAndAvatar(userId primitive.ObjectID, userAvatarUrl string) error {
-	if err := os.Remove(userAvatarUrl); err != nil {
-		slog.Error("removing user avatar", "error", err)
+	if _, err := handler.Models.ApiToken.DeleteAll(bson.M{"user_id": userObjectId}); err != nil {
+		slog.Error("removing user api tokens", "error", err)
 	}
 
+	utils.WriteJSON(w, "user deleted successfully")
+}
+
+// removeUserFiles -> Removes every file of the user (trashed ones too) with its versions, like the expired files
+func (handler *Handler) removeUserFiles(ctx context.Context, userId primitive.ObjectID) error {
 	filter := bson.M{
 		"owner_id": userId,
 	}
 
-	files, err := handler.Models.File.GetAll(filter, 1, 6)
+	if err := handler.removeMatchingFiles(ctx, filter); err != nil {
+		return err
+	}
+
+	// the failed ones are left, the account is kept until they are removed as well
+	remaining, err := handler.Models.File.GetAll(filter, 1, 1)
 	if err != nil {
-		slog.Error("retrieving user file addresses", "error", err)
 		return err
 	}
 
-	for _, file := range files {
-		if err := os.Remove(file.Address); err != nil {
-			slog.Error(fmt.Sprintf("removing user file: %s", file.Address), "error", err)
-			continue
-		}
+	if len(remaining) != 0 {
+		return errors.New("some files could not be removed, please try again")
 	}
 
 	return nil
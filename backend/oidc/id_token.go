@@ -0,0 +1,298 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	clockSkew          = time.Minute
+	keysRefreshBackoff = time.Minute // unknown key ids refetch the JWKS at most this often (key rotation)
+)
+
+// Claims -> The claims of a verified ID token
+type Claims struct {
+	Subject string
+	Values  map[string]any
+}
+
+// VerifiedClaim -> The claim (e.g. "email") when the provider verified it: "<claim>_verified" is true
+func (claims *Claims) VerifiedClaim(name string) (string, bool) {
+	value, _ := claims.Values[name].(string)
+	verified, _ := claims.Values[name+"_verified"].(bool)
+
+	return value, value != "" && verified
+}
+
+type keySet struct {
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// verifyIdToken -> Checks the signature (RS256 or ES256) and the claims of the ID token (OpenID Connect Core 1.0 section 3.1.3.7)
+func (provider *Provider) verifyIdToken(ctx context.Context, providerMetadata *metadata, idToken, nonce string) (*Claims, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("id_token is not a JWS")
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("id_token header: %w", err)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, fmt.Errorf("id_token header: %w", err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("id_token signature: %w", err)
+	}
+
+	key, err := provider.getKey(ctx, providerMetadata, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+
+	// the algorithm has to match the key type, "none" and the HMAC ones are never accepted
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" {
+			return nil, fmt.Errorf("id_token algorithm %q is not supported", header.Alg)
+		}
+
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			return nil, errors.New("invalid id_token signature")
+		}
+
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" || len(signature) != 64 {
+			return nil, fmt.Errorf("id_token algorithm %q is not supported", header.Alg)
+		}
+
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(key, digest[:], r, s) {
+			return nil, errors.New("invalid id_token signature")
+		}
+
+	default:
+		return nil, errors.New("unsupported signing key")
+	}
+
+	payloadJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("id_token payload: %w", err)
+	}
+
+	values := make(map[string]any)
+	if err := json.Unmarshal(payloadJSON, &values); err != nil {
+		return nil, fmt.Errorf("id_token payload: %w", err)
+	}
+
+	if err := provider.validateClaims(values, nonce); err != nil {
+		return nil, err
+	}
+
+	subject, _ := values["sub"].(string)
+	if subject == "" {
+		return nil, errors.New("id_token has no subject")
+	}
+
+	return &Claims{Subject: subject, Values: values}, nil
+}
+
+func (provider *Provider) validateClaims(values map[string]any, nonce string) error {
+	if issuer, _ := values["iss"].(string); strings.TrimSuffix(issuer, "/") != provider.Issuer {
+		return fmt.Errorf("id_token issuer %q is not %q", issuer, provider.Issuer)
+	}
+
+	// "aud" is either a string or an array, with several audiences "azp" must be the client
+	var audiences []string
+	switch aud := values["aud"].(type) {
+	case string:
+		audiences = []string{aud}
+	case []any:
+		for _, audience := range aud {
+			if audience, ok := audience.(string); ok {
+				audiences = append(audiences, audience)
+			}
+		}
+	}
+
+	validAudience := false
+	for _, audience := range audiences {
+		if audience == provider.ClientId {
+			validAudience = true
+		}
+	}
+
+	if !validAudience {
+		return errors.New("id_token was not issued for this client")
+	}
+
+	if azp, ok := values["azp"].(string); (ok || len(audiences) > 1) && azp != provider.ClientId {
+		return errors.New("id_token was not issued for this client")
+	}
+
+	now := time.Now()
+
+	expiry, ok := values["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(expiry), 0).Add(clockSkew)) {
+		return errors.New("id_token has expired")
+	}
+
+	if issuedAt, ok := values["iat"].(float64); ok && time.Unix(int64(issuedAt), 0).After(now.Add(clockSkew)) {
+		return errors.New("id_token is issued in the future")
+	}
+
+	if tokenNonce, _ := values["nonce"].(string); tokenNonce != nonce {
+		return errors.New("id_token nonce does not match")
+	}
+
+	return nil
+}
+
+// getKey -> The signing key with the id, the JWKS is refetched when the key is unknown (the provider rotated its keys)
+func (provider *Provider) getKey(ctx context.Context, providerMetadata *metadata, kid string) (crypto.PublicKey, error) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+
+	if provider.keys != nil {
+		if key, ok := provider.keys.find(kid); ok {
+			return key, nil
+		}
+
+		if time.Since(provider.keys.fetchedAt) < keysRefreshBackoff {
+			return nil, fmt.Errorf("unknown id_token signing key %q", kid)
+		}
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+
+	if err := provider.getJSON(ctx, providerMetadata.JwksUri, &jwks); err != nil {
+		return nil, fmt.Errorf("fetching the signing keys of %s: %w", provider.Issuer, err)
+	}
+
+	keys := &keySet{keys: make(map[string]crypto.PublicKey), fetchedAt: time.Now()}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		// the keys of unsupported types are skipped, the tokens they sign are rejected by the lookup
+		if key, err := jwk.publicKey(); err == nil {
+			keys.keys[jwk.Kid] = key
+		}
+	}
+
+	provider.keys = keys
+
+	key, ok := keys.find(kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown id_token signing key %q", kid)
+	}
+
+	return key, nil
+}
+
+// find -> A token without a key id can only use the single key of the set
+func (keys *keySet) find(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(keys.keys) == 1 {
+		for _, key := range keys.keys {
+			return key, true
+		}
+	}
+
+	key, ok := keys.keys[kid]
+	return key, ok
+}
+
+func (jwk *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}
+		if key.N.BitLen() < 2048 {
+			return nil, errors.New("RSA key is too short")
+		}
+
+		return key, nil
+
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return nil, fmt.Errorf("curve %q is not supported", jwk.Crv)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("invalid P-256 key")
+		}
+
+		// ecdh checks the point is on the curve
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+
+	default:
+		return nil, fmt.Errorf("key type %q is not supported", jwk.Kty)
+	}
+}
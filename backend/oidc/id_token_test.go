@@ -0,0 +1,194 @@
+package oidc
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyIdToken(t *testing.T) {
+	idp := newTestIdp(t)
+	provider := idp.provider()
+
+	providerMetadata, err := provider.getMetadata(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validToken := idp.sign(map[string]any{"alg": "RS256", "kid": "rsa-1"}, idp.validClaims())
+	parts := strings.Split(validToken, ".")
+
+	tests := []struct {
+		name    string
+		idToken string
+		wantErr string
+	}{
+		{"RS256", validToken, ""},
+		{"ES256", idp.sign(map[string]any{"alg": "ES256", "kid": "ec-1"}, idp.validClaims()), ""},
+		{"none", idp.sign(map[string]any{"alg": "none", "kid": "rsa-1"}, idp.validClaims()), "not supported"},
+		{"no signature", parts[0] + "." + parts[1] + ".", "invalid id_token signature"},
+		{"HS256 with the RSA key id", idp.sign(map[string]any{"alg": "HS256", "kid": "rsa-1"}, idp.validClaims()), "not supported"},
+		{"HS256 with the EC key id", idp.sign(map[string]any{"alg": "HS256", "kid": "ec-1"}, idp.validClaims()), "not supported"},
+		{"RS256 with the EC key id", idp.sign(map[string]any{"alg": "RS256", "kid": "ec-1"}, idp.validClaims()), "not supported"},
+		{"ES256 with the RSA key id", idp.sign(map[string]any{"alg": "ES256", "kid": "rsa-1"}, idp.validClaims()), "not supported"},
+		{"encryption key id", idp.sign(map[string]any{"alg": "RS256", "kid": "enc-1"}, idp.validClaims()), "unknown id_token signing key"},
+		{"unknown key id", idp.sign(map[string]any{"alg": "RS256", "kid": "other"}, idp.validClaims()), "unknown id_token signing key"},
+		{"no key id with several keys", idp.sign(map[string]any{"alg": "RS256"}, idp.validClaims()), "unknown id_token signing key"},
+		{"other payload", parts[0] + "." + idp.tamperedPayload() + "." + parts[2], "invalid id_token signature"},
+		{"not a JWS", parts[0] + "." + parts[1], "not a JWS"},
+		{"no subject", idp.sign(map[string]any{"alg": "RS256", "kid": "rsa-1"}, without(idp.validClaims(), "sub")), "no subject"},
+		{"expired", idp.sign(map[string]any{"alg": "RS256", "kid": "rsa-1"},
+			with(idp.validClaims(), "exp", time.Now().Add(-2*time.Minute).Unix())), "expired"},
+		{"other nonce", idp.sign(map[string]any{"alg": "RS256", "kid": "rsa-1"}, with(idp.validClaims(), "nonce", "other")),
+			"nonce does not match"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims, err := provider.verifyIdToken(context.Background(), providerMetadata, test.idToken, "nonce")
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("verifyIdToken() error = %v, want %q", err, test.wantErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if claims.Subject != "subject-1" {
+				t.Errorf("verifyIdToken() subject = %s, want subject-1", claims.Subject)
+			}
+		})
+	}
+}
+
+func TestValidateClaims(t *testing.T) {
+	idp := newTestIdp(t)
+	provider := idp.provider()
+
+	tests := []struct {
+		name    string
+		values  map[string]any
+		nonce   string
+		wantErr string
+	}{
+		{"valid", idp.validClaims(), "nonce", ""},
+		{"issuer with a trailing slash", with(idp.validClaims(), "iss", idp.server.URL+"/"), "nonce", ""},
+		{"other issuer", with(idp.validClaims(), "iss", "https://attacker.example.com"), "nonce", "issuer"},
+		{"no issuer", without(idp.validClaims(), "iss"), "nonce", "issuer"},
+		{"other audience", with(idp.validClaims(), "aud", "other-client"), "nonce", "not issued for this client"},
+		{"no audience", without(idp.validClaims(), "aud"), "nonce", "not issued for this client"},
+		{"audience list", with(idp.validClaims(), "aud", []any{testClientId}), "nonce", ""},
+		{"audience list without the client", with(idp.validClaims(), "aud", []any{"other-client"}), "nonce",
+			"not issued for this client"},
+		{"several audiences without azp", with(idp.validClaims(), "aud", []any{testClientId, "other-client"}), "nonce",
+			"not issued for this client"},
+		{"several audiences with azp", with(with(idp.validClaims(), "aud", []any{testClientId, "other-client"}), "azp", testClientId),
+			"nonce", ""},
+		{"several audiences with another azp", with(with(idp.validClaims(), "aud", []any{testClientId, "other-client"}), "azp",
+			"other-client"), "nonce", "not issued for this client"},
+		{"another azp", with(idp.validClaims(), "azp", "other-client"), "nonce", "not issued for this client"},
+		{"expired", with(idp.validClaims(), "exp", time.Now().Add(-2*time.Minute).Unix()), "nonce", "expired"},
+		{"expired within the clock skew", with(idp.validClaims(), "exp", time.Now().Add(-30*time.Second).Unix()), "nonce", ""},
+		{"no expiry", without(idp.validClaims(), "exp"), "nonce", "expired"},
+		{"issued in the future", with(idp.validClaims(), "iat", time.Now().Add(2*time.Minute).Unix()), "nonce", "in the future"},
+		{"other nonce", with(idp.validClaims(), "nonce", "other"), "nonce", "nonce does not match"},
+		{"no nonce", without(idp.validClaims(), "nonce"), "nonce", "nonce does not match"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// the claims come from the JSON payload, where the numbers are float64
+			values := make(map[string]any)
+			for name, value := range test.values {
+				if number, ok := value.(int64); ok {
+					value = float64(number)
+				}
+
+				values[name] = value
+			}
+
+			err := provider.validateClaims(values, test.nonce)
+			if test.wantErr == "" && err != nil {
+				t.Fatalf("validateClaims() error = %v", err)
+			}
+
+			if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Fatalf("validateClaims() error = %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+// TestGetKeyRotation -> Unknown key ids refetch the JWKS (the provider rotated its keys), but only once per backoff
+func TestGetKeyRotation(t *testing.T) {
+	idp := newTestIdp(t)
+	provider := idp.provider()
+
+	providerMetadata, err := provider.getMetadata(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verify := func(kid string) error {
+		idToken := idp.sign(map[string]any{"alg": "RS256", "kid": kid}, idp.validClaims())
+		_, err := provider.verifyIdToken(context.Background(), providerMetadata, idToken, "nonce")
+		return err
+	}
+
+	fetches := func() int {
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+
+		return idp.jwksFetches
+	}
+
+	if err := verify("rsa-1"); err != nil || fetches() != 1 {
+		t.Fatalf("verifyIdToken() error = %v, %d JWKS fetches", err, fetches())
+	}
+
+	// the known keys are not fetched again
+	if err := verify("rsa-1"); err != nil || fetches() != 1 {
+		t.Fatalf("verifyIdToken() error = %v, %d JWKS fetches", err, fetches())
+	}
+
+	idp.mu.Lock()
+	idp.rsaKid = "rsa-2"
+	idp.mu.Unlock()
+
+	// within the backoff, the unknown key ids do not refetch the JWKS
+	if err := verify("rsa-2"); err == nil || fetches() != 1 {
+		t.Fatalf("verifyIdToken() error = %v, %d JWKS fetches", err, fetches())
+	}
+
+	provider.keys.fetchedAt = time.Now().Add(-keysRefreshBackoff)
+
+	if err := verify("rsa-2"); err != nil || fetches() != 2 {
+		t.Fatalf("verifyIdToken() error = %v, %d JWKS fetches", err, fetches())
+	}
+
+	// the rotated key is gone
+	if err := verify("rsa-1"); err == nil {
+		t.Error("verifyIdToken() accepts the rotated key")
+	}
+}
+
+// tamperedPayload -> The payload of a valid token for another subject
+func (idp *testIdp) tamperedPayload() string {
+	idToken := idp.sign(map[string]any{"alg": "RS256", "kid": "rsa-1"}, with(idp.validClaims(), "sub", "admin"))
+	return strings.Split(idToken, ".")[1]
+}
+
+func with(values map[string]any, name string, value any) map[string]any {
+	values[name] = value
+	return values
+}
+
+func without(values map[string]any, name string) map[string]any {
+	delete(values, name)
+	return values
+}
@@ -0,0 +1,252 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultScopes    = "openid email profile"
+	defaultLinkClaim = "email"
+	requestTimeout   = 10 * time.Second
+)
+
+// Provider -> An OpenID Connect provider (authorization code flow with PKCE), configured by the env variables:
+// OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID, OIDC_<NAME>_CLIENT_SECRET (empty for the public clients),
+// OIDC_<NAME>_SCOPES ("openid email profile" by default) and OIDC_<NAME>_LINK_CLAIM ("email" by default)
+type Provider struct {
+	Name         string
+	Issuer       string
+	ClientId     string
+	ClientSecret string
+	RedirectUrl  string
+	Scopes       []string
+	LinkClaim    string // the verified claim matched against the usernames, to link the existing accounts
+
+	httpClient *http.Client
+
+	mu       sync.Mutex
+	metadata *metadata // discovered on first use, so the server starts while the provider is down
+	keys     *keySet
+}
+
+// metadata -> The part of the discovery document the login needs
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksUri               string `json:"jwks_uri"`
+}
+
+// LoadProviders -> The providers listed in OIDC_PROVIDERS (comma separated names), none when it is empty.
+// Their redirect url is BACKEND_URL/api/auth/oidc/callback/<name>, it has to be registered at the provider
+func LoadProviders() (map[string]*Provider, error) {
+	providers := make(map[string]*Provider)
+
+	names := strings.TrimSpace(os.Getenv("OIDC_PROVIDERS"))
+	if names == "" {
+		return providers, nil
+	}
+
+	backendUrl := strings.TrimSuffix(os.Getenv("BACKEND_URL"), "/")
+	if backendUrl == "" {
+		return nil, errors.New("BACKEND_URL env variable is required by the OIDC providers")
+	}
+
+	for _, name := range strings.Split(names, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		envPrefix := "OIDC_" + strings.ToUpper(name) + "_"
+
+		provider := &Provider{
+			Name:         name,
+			Issuer:       strings.TrimSuffix(os.Getenv(envPrefix+"ISSUER"), "/"),
+			ClientId:     os.Getenv(envPrefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(envPrefix + "CLIENT_SECRET"),
+			RedirectUrl:  backendUrl + "/api/auth/oidc/callback/" + url.PathEscape(name),
+			Scopes:       strings.Fields(getEnvOrDefault(envPrefix+"SCOPES", defaultScopes)),
+			LinkClaim:    getEnvOrDefault(envPrefix+"LINK_CLAIM", defaultLinkClaim),
+			httpClient:   &http.Client{Timeout: requestTimeout},
+		}
+
+		if provider.Issuer == "" || provider.ClientId == "" {
+			return nil, fmt.Errorf("%sISSUER and %sCLIENT_ID env variables are required", envPrefix, envPrefix)
+		}
+
+		providers[name] = provider
+	}
+
+	return providers, nil
+}
+
+// AuthCodeUrl -> Where the user is sent to log in, the code challenge is the S256 one of the verifier (see CodeChallenge)
+func (provider *Provider) AuthCodeUrl(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	providerMetadata, err := provider.getMetadata(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", provider.ClientId)
+	query.Set("redirect_uri", provider.RedirectUrl)
+	query.Set("scope", strings.Join(provider.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge)
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(providerMetadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return providerMetadata.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange -> Redeems the authorization code and returns the claims of the verified ID token
+func (provider *Provider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*Claims, error) {
+	providerMetadata, err := provider.getMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", provider.RedirectUrl)
+	form.Set("code_verifier", codeVerifier)
+
+	// the public clients identify themselves by the client_id only
+	if provider.ClientSecret == "" {
+		form.Set("client_id", provider.ClientId)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, providerMetadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+
+	if provider.ClientSecret != "" {
+		// client_secret_basic, the credentials are form-urlencoded first (RFC 6749 section 2.3.1)
+		request.SetBasicAuth(url.QueryEscape(provider.ClientId), url.QueryEscape(provider.ClientSecret))
+	}
+
+	response, err := provider.httpClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("token request: %w", err)
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("reading token response: %w", err)
+	}
+
+	var tokenResponse struct {
+		IdToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+
+	if err := json.Unmarshal(body, &tokenResponse); err != nil {
+		return nil, fmt.Errorf("token response (status %d): %w", response.StatusCode, err)
+	}
+
+	if response.StatusCode != http.StatusOK || tokenResponse.Error != "" {
+		return nil, fmt.Errorf("token request failed (status %d): %s %s", response.StatusCode, tokenResponse.Error, tokenResponse.ErrorDescription)
+	}
+
+	if tokenResponse.IdToken == "" {
+		return nil, errors.New("token response has no id_token, is the 'openid' scope requested?")
+	}
+
+	return provider.verifyIdToken(ctx, providerMetadata, tokenResponse.IdToken, nonce)
+}
+
+func (provider *Provider) getMetadata(ctx context.Context) (*metadata, error) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+
+	if provider.metadata != nil {
+		return provider.metadata, nil
+	}
+
+	var providerMetadata metadata
+	if err := provider.getJSON(ctx, provider.Issuer+"/.well-known/openid-configuration", &providerMetadata); err != nil {
+		return nil, fmt.Errorf("discovery of %s: %w", provider.Issuer, err)
+	}
+
+	// OpenID Connect Discovery 1.0 section 4.3
+	if strings.TrimSuffix(providerMetadata.Issuer, "/") != provider.Issuer {
+		return nil, fmt.Errorf("discovery of %s returned the issuer %q", provider.Issuer, providerMetadata.Issuer)
+	}
+
+	if providerMetadata.AuthorizationEndpoint == "" || providerMetadata.TokenEndpoint == "" || providerMetadata.JwksUri == "" {
+		return nil, fmt.Errorf("discovery of %s: the endpoints are missing", provider.Issuer)
+	}
+
+	provider.metadata = &providerMetadata
+	return provider.metadata, nil
+}
+
+func (provider *Provider) getJSON(ctx context.Context, endpoint string, output any) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Accept", "application/json")
+
+	response, err := provider.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", response.StatusCode)
+	}
+
+	return json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(output)
+}
+
+// RandomValue -> For the state, the nonce and the code verifier: 256 bits, base64url encoded (43 characters)
+func RandomValue() string {
+	value := make([]byte, 32)
+	rand.Read(value)
+
+	return base64.RawURLEncoding.EncodeToString(value)
+}
+
+// CodeChallenge -> The S256 PKCE challenge of the verifier (RFC 7636 section 4.2)
+func CodeChallenge(codeVerifier string) string {
+	hash := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+func getEnvOrDefault(key, defaultValue string) string {
+	if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+		return value
+	}
+
+	return defaultValue
+}
@@ -0,0 +1,302 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testClientId     = "file-manager"
+	testClientSecret = "secret"
+	testRedirectUrl  = "http://localhost:8000/api/auth/oidc/callback/test"
+)
+
+// testIdp -> A minimal in-process OpenID Connect provider: discovery, JWKS (one RSA and one EC key) and a token
+// endpoint which redeems the codes of authorize
+type testIdp struct {
+	server *httptest.Server
+
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+
+	mu          sync.Mutex
+	jwksFetches int
+	rsaKid      string // the kid of the RSA key in the JWKS, changed to rotate the key
+	codes       map[string]testCode
+}
+
+type testCode struct {
+	codeChallenge string
+	claims        map[string]any
+}
+
+func newTestIdp(t *testing.T) *testIdp {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp := &testIdp{rsaKey: rsaKey, ecKey: ecKey, rsaKid: "rsa-1", codes: make(map[string]testCode)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", idp.discovery)
+	mux.HandleFunc("GET /jwks", idp.jwks)
+	mux.HandleFunc("POST /token", idp.token)
+
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+
+	return idp
+}
+
+func (idp *testIdp) provider() *Provider {
+	return &Provider{
+		Name:         "test",
+		Issuer:       idp.server.URL,
+		ClientId:     testClientId,
+		ClientSecret: testClientSecret,
+		RedirectUrl:  testRedirectUrl,
+		Scopes:       strings.Fields(defaultScopes),
+		LinkClaim:    defaultLinkClaim,
+		httpClient:   idp.server.Client(),
+	}
+}
+
+func (idp *testIdp) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 idp.server.URL,
+		"authorization_endpoint": idp.server.URL + "/authorize",
+		"token_endpoint":         idp.server.URL + "/token",
+		"jwks_uri":               idp.server.URL + "/jwks",
+	})
+}
+
+func (idp *testIdp) jwks(w http.ResponseWriter, r *http.Request) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+
+	idp.jwksFetches++
+
+	ecPublic := idp.ecKey.PublicKey
+	json.NewEncoder(w).Encode(map[string]any{
+		"keys": []map[string]string{
+			{
+				"kid": idp.rsaKid, "kty": "RSA", "use": "sig",
+				"n": base64.RawURLEncoding.EncodeToString(idp.rsaKey.N.Bytes()),
+				"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(idp.rsaKey.E)).Bytes()),
+			},
+			{
+				"kid": "ec-1", "kty": "EC", "crv": "P-256",
+				"x": base64.RawURLEncoding.EncodeToString(ecPublic.X.FillBytes(make([]byte, 32))),
+				"y": base64.RawURLEncoding.EncodeToString(ecPublic.Y.FillBytes(make([]byte, 32))),
+			},
+			// the encryption keys are skipped
+			{"kid": "enc-1", "kty": "RSA", "use": "enc", "n": "AQAB", "e": "AQAB"},
+		},
+	})
+}
+
+// authorize -> What the login at the provider would do: a code for the claims, bound to the PKCE challenge
+func (idp *testIdp) authorize(codeChallenge string, claims map[string]any) string {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+
+	code := RandomValue()
+	idp.codes[code] = testCode{codeChallenge: codeChallenge, claims: claims}
+
+	return code
+}
+
+func (idp *testIdp) token(w http.ResponseWriter, r *http.Request) {
+	clientId, clientSecret, ok := r.BasicAuth()
+	if !ok || clientId != testClientId || clientSecret != testClientSecret {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	idp.mu.Lock()
+	code, ok := idp.codes[r.PostFormValue("code")]
+	delete(idp.codes, r.PostFormValue("code"))
+	idp.mu.Unlock()
+
+	if !ok || r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("redirect_uri") != testRedirectUrl ||
+		CodeChallenge(r.PostFormValue("code_verifier")) != code.codeChallenge {
+
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant", "error_description": "the code is invalid"})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"id_token":     idp.sign(map[string]any{"alg": "RS256", "kid": idp.rsaKid}, code.claims),
+	})
+}
+
+// sign -> A JWS of the claims, signed as the header says: RS256 and ES256 with the keys of the JWKS, HS256 with the
+// client secret and "none" not at all
+func (idp *testIdp) sign(header, claims map[string]any) string {
+	headerJSON, _ := json.Marshal(header)
+	claimsJSON, _ := json.Marshal(claims)
+
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	var signature []byte
+	switch header["alg"] {
+	case "RS256":
+		signature, _ = rsa.SignPKCS1v15(rand.Reader, idp.rsaKey, crypto.SHA256, digest[:])
+
+	case "ES256":
+		r, s, _ := ecdsa.Sign(rand.Reader, idp.ecKey, digest[:])
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+
+	case "HS256":
+		mac := hmac.New(sha256.New, []byte(testClientSecret))
+		mac.Write([]byte(signingInput))
+		signature = mac.Sum(nil)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// validClaims -> The claims of a valid ID token for the provider, with the nonce "nonce"
+func (idp *testIdp) validClaims() map[string]any {
+	return map[string]any{
+		"iss":            idp.server.URL,
+		"sub":            "subject-1",
+		"aud":            testClientId,
+		"exp":            time.Now().Add(time.Minute).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          "nonce",
+		"email":          "alice@example.com",
+		"email_verified": true,
+	}
+}
+
+func TestExchange(t *testing.T) {
+	idp := newTestIdp(t)
+	provider := idp.provider()
+	codeVerifier := RandomValue()
+
+	tests := []struct {
+		name         string
+		code         func() string
+		codeVerifier string
+		clientSecret string
+		nonce        string
+		wantErr      string
+	}{
+		{"valid", func() string { return idp.authorize(CodeChallenge(codeVerifier), idp.validClaims()) },
+			codeVerifier, testClientSecret, "nonce", ""},
+		{"wrong code verifier", func() string { return idp.authorize(CodeChallenge(codeVerifier), idp.validClaims()) },
+			RandomValue(), testClientSecret, "nonce", "invalid_grant"},
+		{"unknown code", func() string { return "unknown" }, codeVerifier, testClientSecret, "nonce", "invalid_grant"},
+		{"wrong client secret", func() string { return idp.authorize(CodeChallenge(codeVerifier), idp.validClaims()) },
+			codeVerifier, "wrong", "nonce", "invalid_client"},
+		{"other nonce", func() string { return idp.authorize(CodeChallenge(codeVerifier), idp.validClaims()) },
+			codeVerifier, testClientSecret, "other", "nonce does not match"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider.ClientSecret = test.clientSecret
+
+			claims, err := provider.Exchange(context.Background(), test.code(), test.codeVerifier, test.nonce)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("Exchange() error = %v, want %q", err, test.wantErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if email, verified := claims.VerifiedClaim("email"); claims.Subject != "subject-1" || email != "alice@example.com" || !verified {
+				t.Errorf("Exchange() claims = %+v", claims)
+			}
+		})
+	}
+}
+
+func TestExchangeCodeUsedOnce(t *testing.T) {
+	idp := newTestIdp(t)
+	provider := idp.provider()
+
+	codeVerifier := RandomValue()
+	code := idp.authorize(CodeChallenge(codeVerifier), idp.validClaims())
+
+	if _, err := provider.Exchange(context.Background(), code, codeVerifier, "nonce"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := provider.Exchange(context.Background(), code, codeVerifier, "nonce"); err == nil {
+		t.Error("Exchange() redeems a code twice")
+	}
+}
+
+func TestAuthCodeUrl(t *testing.T) {
+	idp := newTestIdp(t)
+
+	authUrl, err := idp.provider().AuthCodeUrl(context.Background(), "state", "nonce", CodeChallenge("verifier"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, parameter := range []string{"response_type=code", "client_id=" + testClientId, "state=state", "nonce=nonce",
+		"code_challenge=" + CodeChallenge("verifier"), "code_challenge_method=S256", "scope=openid+email+profile"} {
+
+		if !strings.Contains(authUrl, parameter) {
+			t.Errorf("AuthCodeUrl() = %s, %s is missing", authUrl, parameter)
+		}
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	idp := newTestIdp(t)
+
+	provider := idp.provider()
+	provider.Issuer = idp.server.URL + "/other"
+
+	if _, err := provider.AuthCodeUrl(context.Background(), "state", "nonce", "challenge"); err == nil {
+		t.Error("AuthCodeUrl() accepts the discovery document of another issuer")
+	}
+}
+
+// TestCodeChallenge -> S256: base64url(sha256(verifier)) without padding, the value is the one of openssl
+func TestCodeChallenge(t *testing.T) {
+	if challenge := CodeChallenge("dBjftJeZ4CVP-mJ92K9qqDGmbJpp0Z-KwXXRaeEPaYg"); challenge != "4jRC5-vbeV3JK7CZYOKOUpIgJdCVcO_dpNVMysRlnG8" {
+		t.Errorf("CodeChallenge() = %s", challenge)
+	}
+
+	if value := RandomValue(); len(value) != 43 || value == RandomValue() {
+		t.Errorf("RandomValue() = %s", value)
+	}
+}
@@ -0,0 +1,189 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+type LocalBackend struct {
+	root string
+}
+
+func NewLocal(root string) (*LocalBackend, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+
+	return &LocalBackend{root: root}, nil
+}
+
+func (local *LocalBackend) Put(ctx context.Context, key string, reader io.Reader, size int64) error {
+	fullPath, err := local.fullPath(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return err
+	}
+
+	// writing into a temp file first, so readers never see a half written object
+	tmpFile, err := os.CreateTemp(filepath.Dir(fullPath), ".upload-*")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := io.Copy(tmpFile, reader); err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), fullPath)
+}
+
+func (local *LocalBackend) Get(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	fullPath, err := local.fullPath(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return nil, convertError(err)
+	}
+
+	return file, nil
+}
+
+func (local *LocalBackend) Delete(ctx context.Context, key string) error {
+	fullPath, err := local.fullPath(key)
+	if err != nil {
+		return err
+	}
+
+	return convertError(os.Remove(fullPath))
+}
+
+func (local *LocalBackend) Move(ctx context.Context, srcKey, dstKey string) error {
+	srcPath, err := local.fullPath(srcKey)
+	if err != nil {
+		return err
+	}
+
+	dstPath, err := local.fullPath(dstKey)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+		return err
+	}
+
+	return convertError(os.Rename(srcPath, dstPath))
+}
+
+func (local *LocalBackend) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	fullPath, err := local.fullPath(key)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return nil, convertError(err)
+	}
+
+	if info.IsDir() {
+		return nil, ErrNotFound
+	}
+
+	objectInfo := &ObjectInfo{
+		Key:     key,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+
+	return objectInfo, nil
+}
+
+func (local *LocalBackend) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	// walking from the deepest directory of the prefix, then filtering by the full prefix
+	dirKey := prefix
+	if !strings.HasSuffix(dirKey, "/") {
+		dirKey = path.Dir(dirKey)
+	}
+
+	walkRoot := local.root
+	if cleanDir := path.Clean("/" + dirKey); cleanDir != "/" {
+		walkRoot = filepath.Join(local.root, filepath.FromSlash(cleanDir))
+	}
+
+	var objects []ObjectInfo
+	err := filepath.WalkDir(walkRoot, func(fullPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+
+			return err
+		}
+
+		if entry.IsDir() {
+			return nil
+		}
+
+		relativePath, err := filepath.Rel(local.root, fullPath)
+		if err != nil {
+			return err
+		}
+
+		key := filepath.ToSlash(relativePath)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		objects = append(objects, ObjectInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return objects, nil
+}
+
+// fullPath -> Converts the key into a path under root, keys can`t escape the root directory
+func (local *LocalBackend) fullPath(key string) (string, error) {
+	cleanKey := path.Clean("/" + key)
+	if cleanKey == "/" {
+		return "", errors.New("storage key is empty")
+	}
+
+	return filepath.Join(local.root, filepath.FromSlash(cleanKey)), nil
+}
+
+func convertError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+
+	return err
+}
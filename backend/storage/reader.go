@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"context"
+	"io"
+)
+
+// OpenFunc -> Opens the content of an object, e.g. decrypted
+type OpenFunc func(ctx context.Context, key string) (io.ReadCloser, error)
+
+// multiObjectReader -> Reads several objects one after another, opening only one object at a time
+type multiObjectReader struct {
+	ctx     context.Context
+	open    OpenFunc
+	keys    []string
+	current io.ReadCloser
+}
+
+func NewMultiObjectReader(ctx context.Context, keys []string, open OpenFunc) io.ReadCloser {
+	return &multiObjectReader{
+		ctx:  ctx,
+		open: open,
+		keys: keys,
+	}
+}
+
+func (reader *multiObjectReader) Read(p []byte) (int, error) {
+	for {
+		if reader.current == nil {
+			if len(reader.keys) == 0 {
+				return 0, io.EOF
+			}
+
+			object, err := reader.open(reader.ctx, reader.keys[0])
+			if err != nil {
+				return 0, err
+			}
+
+			reader.keys = reader.keys[1:]
+			reader.current = object
+		}
+
+		n, err := reader.current.Read(p)
+		if err == io.EOF {
+			reader.current.Close()
+			reader.current = nil
+
+			if n > 0 {
+				return n, nil
+			}
+
+			continue
+		}
+
+		return n, err
+	}
+}
+
+func (reader *multiObjectReader) Close() error {
+	if reader.current == nil {
+		return nil
+	}
+
+	return reader.current.Close()
+}
@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+type S3Config struct {
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	Bucket    string
+	Region    string
+	UseSSL    bool
+}
+
+// S3Backend -> Works with any S3-compatible service (AWS S3, MinIO, ...)
+type S3Backend struct {
+	client *minio.Client
+	bucket string
+}
+
+func NewS3(config *S3Config) (*S3Backend, error) {
+	options := &minio.Options{
+		Creds:  credentials.NewStaticV4(config.AccessKey, config.SecretKey, ""),
+		Secure: config.UseSSL,
+		Region: config.Region,
+	}
+
+	client, err := minio.New(config.Endpoint, options)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	exists, err := client.BucketExists(ctx, config.Bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		makeBucketOptions := minio.MakeBucketOptions{Region: config.Region}
+		if err := client.MakeBucket(ctx, config.Bucket, makeBucketOptions); err != nil {
+			return nil, err
+		}
+	}
+
+	s3Backend := &S3Backend{
+		client: client,
+		bucket: config.Bucket,
+	}
+
+	return s3Backend, nil
+}
+
+func (s3 *S3Backend) Put(ctx context.Context, key string, reader io.Reader, size int64) error {
+	_, err := s3.client.PutObject(ctx, s3.bucket, key, reader, size, minio.PutObjectOptions{})
+	return err
+}
+
+func (s3 *S3Backend) Get(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	object, err := s3.client.GetObject(ctx, s3.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, convertS3Error(err)
+	}
+
+	// GetObject is lazy, Stat makes sure the object really exists
+	if _, err := object.Stat(); err != nil {
+		object.Close()
+		return nil, convertS3Error(err)
+	}
+
+	return object, nil
+}
+
+func (s3 *S3Backend) Delete(ctx context.Context, key string) error {
+	return s3.client.RemoveObject(ctx, s3.bucket, key, minio.RemoveObjectOptions{})
+}
+
+// Move -> S3 has no rename, so the object is copied on the server side and then removed
+func (s3 *S3Backend) Move(ctx context.Context, srcKey, dstKey string) error {
+	destination := minio.CopyDestOptions{Bucket: s3.bucket, Object: dstKey}
+	source := minio.CopySrcOptions{Bucket: s3.bucket, Object: srcKey}
+
+	// ComposeObject (unlike CopyObject) also works for objects bigger than 5 GB
+	if _, err := s3.client.ComposeObject(ctx, destination, source); err != nil {
+		return convertS3Error(err)
+	}
+
+	return s3.Delete(ctx, srcKey)
+}
+
+func (s3 *S3Backend) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	info, err := s3.client.StatObject(ctx, s3.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, convertS3Error(err)
+	}
+
+	objectInfo := &ObjectInfo{
+		Key:     info.Key,
+		Size:    info.Size,
+		ModTime: info.LastModified,
+	}
+
+	return objectInfo, nil
+}
+
+func (s3 *S3Backend) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	listOptions := minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	}
+
+	var objects []ObjectInfo
+	for info := range s3.client.ListObjects(ctx, s3.bucket, listOptions) {
+		if info.Err != nil {
+			return nil, info.Err
+		}
+
+		objects = append(objects, ObjectInfo{Key: info.Key, Size: info.Size, ModTime: info.LastModified})
+	}
+
+	return objects, nil
+}
+
+func convertS3Error(err error) error {
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+func getS3Config() (*S3Config, error) {
+	config := &S3Config{
+		Endpoint:  os.Getenv("S3_ENDPOINT"),
+		AccessKey: os.Getenv("S3_ACCESS_KEY"),
+		SecretKey: os.Getenv("S3_SECRET_KEY"),
+		Bucket:    os.Getenv("S3_BUCKET"),
+		Region:    os.Getenv("S3_REGION"),
+	}
+
+	if config.Endpoint == "" || config.Bucket == "" {
+		return nil, errors.New("S3_ENDPOINT and S3_BUCKET env variables are required for the s3 storage driver")
+	}
+
+	if useSSL := os.Getenv("S3_USE_SSL"); useSSL != "" {
+		parsed, err := strconv.ParseBool(useSSL)
+		if err != nil {
+			return nil, err
+		}
+
+		config.UseSSL = parsed
+	}
+
+	return config, nil
+}
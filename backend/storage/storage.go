@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+var ErrNotFound = errors.New("object does not exist")
+
+type ObjectInfo struct {
+	Key     string    `json:"key"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// Backend -> Every place that stores or reads file bytes goes through this interface.
+// Keys are slash separated paths (e.g. "uploads/user_files/<id>/files/<name>")
+type Backend interface {
+	// Put -> size may be -1 when the length is not known in advance
+	Put(ctx context.Context, key string, reader io.Reader, size int64) error
+	// Get -> Objects are seekable, so ranges can be served without reading the whole object
+	Get(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	Delete(ctx context.Context, key string) error
+	// Move -> Replaces the destination object if it exists
+	Move(ctx context.Context, srcKey, dstKey string) error
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+}
+
+// New -> Returns the backend selected by the STORAGE_DRIVER env variable (local, s3)
+func New() (Backend, error) {
+	driver := os.Getenv("STORAGE_DRIVER")
+
+	switch driver {
+	case "", "local":
+		return NewLocal(getLocalRoot())
+	case "s3":
+		config, err := getS3Config()
+		if err != nil {
+			return nil, err
+		}
+
+		return NewS3(config)
+	default:
+		return nil, fmt.Errorf("invalid storage driver: %s. Must be either local or s3", driver)
+	}
+}
+
+func getLocalRoot() string {
+	root := os.Getenv("STORAGE_LOCAL_ROOT")
+	if root == "" {
+		root = "./" // default
+	}
+
+	return root
+}
@@ -0,0 +1,44 @@
+package token
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"time"
+)
+
+// ChallengePayload -> Issued by the first login step (the password) of the users with two-factor authentication,
+// it is exchanged for the session tokens together with a code
+type ChallengePayload struct {
+	ID       uuid.UUID `json:"id"`
+	UserId   string    `json:"user_id"`
+	ExpiryAt time.Time `json:"expiry_at"`
+}
+
+func (maker *PasetoMaker) CreateChallengeToken(userId string, duration time.Duration) (string, error) {
+	tokenId, err := uuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+
+	payload := &ChallengePayload{
+		ID:       tokenId,
+		UserId:   userId,
+		ExpiryAt: time.Now().Add(duration),
+	}
+
+	return maker.paseto.Encrypt(maker.challengeKey, payload, nil)
+}
+
+func (maker *PasetoMaker) VerifyChallengeToken(token string) (*ChallengePayload, error) {
+	payload := &ChallengePayload{}
+
+	if err := maker.paseto.Decrypt(token, maker.challengeKey, payload, nil); err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(payload.ExpiryAt) {
+		return nil, errors.New("challenge has expired, please log in again")
+	}
+
+	return payload, nil
+}
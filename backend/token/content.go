@@ -0,0 +1,45 @@
+package token
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"time"
+)
+
+// ContentPayload -> Grants the access to the content of a shared file, through the link it was issued for
+type ContentPayload struct {
+	ID       uuid.UUID `json:"id"`
+	ShortUrl string    `json:"short_url"`
+	FileId   string    `json:"file_id"`
+	ExpiryAt time.Time `json:"expiry_at"`
+}
+
+func (maker *PasetoMaker) CreateContentToken(shortUrl, fileId string, duration time.Duration) (string, error) {
+	tokenId, err := uuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+
+	payload := &ContentPayload{
+		ID:       tokenId,
+		ShortUrl: shortUrl,
+		FileId:   fileId,
+		ExpiryAt: time.Now().Add(duration),
+	}
+
+	return maker.paseto.Encrypt(maker.contentKey, payload, nil)
+}
+
+func (maker *PasetoMaker) VerifyContentToken(token string) (*ContentPayload, error) {
+	payload := &ContentPayload{}
+
+	if err := maker.paseto.Decrypt(token, maker.contentKey, payload, nil); err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(payload.ExpiryAt) {
+		return nil, errors.New("content url has expired")
+	}
+
+	return payload, nil
+}
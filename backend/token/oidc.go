@@ -0,0 +1,114 @@
+package token
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"time"
+)
+
+// OidcStatePayload -> What the single sign-on callback needs to finish the login it started,
+// it is kept in a cookie of the browser which started it (so it can not be used to log someone else in)
+type OidcStatePayload struct {
+	Provider        string    `json:"provider"`
+	State           string    `json:"state"`
+	Nonce           string    `json:"nonce"`
+	CodeVerifier    string    `json:"code_verifier"`
+	ClientChallenge string    `json:"client_challenge"`
+	LinkUserId      string    `json:"link_user_id,omitempty"` // set when a logged-in user links the identity (see OidcLinkPayload)
+	ExpiryAt        time.Time `json:"expiry_at"`
+}
+
+// OidcTicketPayload -> Issued by the single sign-on callback, the frontend exchanges it for the session tokens.
+// Only the holder of the client verifier (whose hash is ClientChallenge) can exchange it
+type OidcTicketPayload struct {
+	ID              uuid.UUID `json:"id"`
+	UserId          string    `json:"user_id"`
+	ClientChallenge string    `json:"client_challenge"`
+	ExpiryAt        time.Time `json:"expiry_at"`
+}
+
+// OidcLinkPayload -> Issued to a logged-in user, the single sign-on started with it links the identity to that user
+type OidcLinkPayload struct {
+	ID       uuid.UUID `json:"id"`
+	UserId   string    `json:"user_id"`
+	ExpiryAt time.Time `json:"expiry_at"`
+}
+
+func (maker *PasetoMaker) CreateOidcStateToken(payload *OidcStatePayload, duration time.Duration) (string, error) {
+	payload.ExpiryAt = time.Now().Add(duration)
+
+	return maker.paseto.Encrypt(maker.oidcStateKey, payload, nil)
+}
+
+func (maker *PasetoMaker) VerifyOidcStateToken(token string) (*OidcStatePayload, error) {
+	payload := &OidcStatePayload{}
+
+	if err := maker.paseto.Decrypt(token, maker.oidcStateKey, payload, nil); err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(payload.ExpiryAt) {
+		return nil, errors.New("single sign-on has expired, please log in again")
+	}
+
+	return payload, nil
+}
+
+func (maker *PasetoMaker) CreateOidcTicket(userId, clientChallenge string, duration time.Duration) (string, error) {
+	ticketId, err := uuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+
+	payload := &OidcTicketPayload{
+		ID:              ticketId,
+		UserId:          userId,
+		ClientChallenge: clientChallenge,
+		ExpiryAt:        time.Now().Add(duration),
+	}
+
+	return maker.paseto.Encrypt(maker.ticketKey, payload, nil)
+}
+
+func (maker *PasetoMaker) VerifyOidcTicket(token string) (*OidcTicketPayload, error) {
+	payload := &OidcTicketPayload{}
+
+	if err := maker.paseto.Decrypt(token, maker.ticketKey, payload, nil); err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(payload.ExpiryAt) {
+		return nil, errors.New("single sign-on has expired, please log in again")
+	}
+
+	return payload, nil
+}
+
+func (maker *PasetoMaker) CreateOidcLinkToken(userId string, duration time.Duration) (string, error) {
+	tokenId, err := uuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+
+	payload := &OidcLinkPayload{
+		ID:       tokenId,
+		UserId:   userId,
+		ExpiryAt: time.Now().Add(duration),
+	}
+
+	return maker.paseto.Encrypt(maker.oidcLinkKey, payload, nil)
+}
+
+func (maker *PasetoMaker) VerifyOidcLinkToken(token string) (*OidcLinkPayload, error) {
+	payload := &OidcLinkPayload{}
+
+	if err := maker.paseto.Decrypt(token, maker.oidcLinkKey, payload, nil); err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(payload.ExpiryAt) {
+		return nil, errors.New("single sign-on link has expired, please try again")
+	}
+
+	return payload, nil
+}
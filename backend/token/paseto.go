@@ -7,12 +7,23 @@ import (
 	"github.com/aead/chacha20poly1305"
 	"github.com/o1egl/paseto"
 	"os"
+	"strings"
 	"time"
 )
 
 type PasetoMaker struct {
 	paseto       *paseto.V2
 	symmetricKey []byte
+	contentKey   []byte // content tokens have their own key, so they can never pass as the auth tokens (and vice versa)
+	challengeKey []byte // same for the two-factor challenge tokens
+	oidcStateKey []byte // and for the single sign-on states
+	ticketKey    []byte // and login tickets
+	oidcLinkKey  []byte // and the single sign-on link tokens
+
+	// sessionChecker -> Rejects the revoked tokens, the maker itself knows nothing of the stored sessions
+	sessionChecker func(payload *Payload) error
+	// apiTokenVerifier -> Looks the personal access tokens up, they are not PASETO tokens
+	apiTokenVerifier func(token string) (*Payload, error)
 }
 
 func New() (*PasetoMaker, error) {
@@ -25,24 +36,59 @@ func New() (*PasetoMaker, error) {
 		return nil, fmt.Errorf("SymmetricKey too short should be: %v", chacha20poly1305.KeySize)
 	}
 
+	contentKey := sha256.Sum256(append(key[:], "content"...))
+	challengeKey := sha256.Sum256(append(key[:], "challenge"...))
+	oidcStateKey := sha256.Sum256(append(key[:], "oidc state"...))
+	ticketKey := sha256.Sum256(append(key[:], "ticket"...))
+	oidcLinkKey := sha256.Sum256(append(key[:], "oidc link"...))
+
 	maker := &PasetoMaker{
 		paseto:       paseto.NewV2(),
 		symmetricKey: key[:],
+		contentKey:   contentKey[:],
+		challengeKey: challengeKey[:],
+		oidcStateKey: oidcStateKey[:],
+		ticketKey:    ticketKey[:],
+		oidcLinkKey:  oidcLinkKey[:],
 	}
 
 	return maker, nil
 }
 
-func (maker *PasetoMaker) CreateToken(username, userId, userPlan string, duration time.Duration) (string, error) {
-	payload, err := NewPayload(username, userId, userPlan, duration)
+// SetSessionChecker -> Every verified token is checked against its session as well (see VerifyToken)
+func (maker *PasetoMaker) SetSessionChecker(checker func(payload *Payload) error) {
+	maker.sessionChecker = checker
+}
+
+// SetApiTokenVerifier -> The tokens starting with ApiTokenPrefix are verified by it (see VerifyToken)
+func (maker *PasetoMaker) SetApiTokenVerifier(verifier func(token string) (*Payload, error)) {
+	maker.apiTokenVerifier = verifier
+}
+
+// CreateToken -> Returns the payload too, its ID is what the session keeps track of
+func (maker *PasetoMaker) CreateToken(username, userId, userPlan, sessionId string, duration time.Duration) (string, *Payload, error) {
+	payload, err := NewPayload(username, userId, userPlan, sessionId, duration)
+	if err != nil {
+		return "", nil, err
+	}
+
+	token, err := maker.paseto.Encrypt(maker.symmetricKey, payload, nil)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
-	return maker.paseto.Encrypt(maker.symmetricKey, payload, nil)
+	return token, payload, nil
 }
 
 func (maker *PasetoMaker) VerifyToken(token string) (*Payload, error) {
+	if strings.HasPrefix(token, ApiTokenPrefix) {
+		if maker.apiTokenVerifier == nil {
+			return nil, errors.New("api tokens are not supported")
+		}
+
+		return maker.apiTokenVerifier(token)
+	}
+
 	payload := &Payload{}
 
 	if err := maker.paseto.Decrypt(token, maker.symmetricKey, payload, nil); err != nil {
@@ -53,6 +99,12 @@ func (maker *PasetoMaker) VerifyToken(token string) (*Payload, error) {
 		return nil, err
 	}
 
+	if maker.sessionChecker != nil {
+		if err := maker.sessionChecker(payload); err != nil {
+			return nil, err
+		}
+	}
+
 	return payload, nil
 }
 
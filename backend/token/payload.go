@@ -11,11 +11,16 @@ type Payload struct {
 	Username  string    `json:"username"`
 	UserId    string    `json:"user_id"`
 	UserPlan  string    `json:"user_plan"`
+	SessionId string    `json:"session_id"`
 	CreatedAt time.Time `json:"created_at"`
 	ExpiryAt  time.Time `json:"expiry_at"`
+
+	// set for the personal access tokens only, see HasScope
+	ApiTokenId string   `json:"api_token_id,omitempty"`
+	Scopes     []string `json:"scopes,omitempty"`
 }
 
-func NewPayload(username, userId, userPlan string, duration time.Duration) (*Payload, error) {
+func NewPayload(username, userId, userPlan, sessionId string, duration time.Duration) (*Payload, error) {
 	tokenId, err := uuid.NewRandom()
 	if err != nil {
 		return nil, err
@@ -26,6 +31,7 @@ func NewPayload(username, userId, userPlan string, duration time.Duration) (*Pay
 		Username:  username,
 		UserId:    userId,
 		UserPlan:  userPlan,
+		SessionId: sessionId,
 		CreatedAt: time.Now(),
 		ExpiryAt:  time.Now().Add(duration),
 	}
@@ -0,0 +1,45 @@
+package token
+
+import (
+	"fmt"
+	"slices"
+)
+
+// ApiTokenPrefix -> The personal access tokens start with it, the session tokens are PASETO ("v2.local.")
+const ApiTokenPrefix = "fm_pat_"
+
+// The scopes of the personal access tokens. The session tokens are not limited
+const (
+	ScopeFilesRead     = "files:read"     // listing, downloading and searching the files and the folders
+	ScopeFilesWrite    = "files:write"    // uploading, renaming, moving, copying and deleting them, the trash
+	ScopeSharesManage  = "shares:manage"  // share links, shares with users and teams, approvals
+	ScopeTeamsRead     = "teams:read"     // listing the teams
+	ScopeTeamsAdmin    = "teams:admin"    // creating and deleting the teams, their members and their plans
+	ScopeAccountManage = "account:manage" // the avatar
+)
+
+var Scopes = []string{ScopeFilesRead, ScopeFilesWrite, ScopeSharesManage, ScopeTeamsRead, ScopeTeamsAdmin, ScopeAccountManage}
+
+func ValidateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("at least one scope is required, available: %v", Scopes)
+	}
+
+	for _, scope := range scopes {
+		if !slices.Contains(Scopes, scope) {
+			return fmt.Errorf("unknown scope '%s', available: %v", scope, Scopes)
+		}
+	}
+
+	return nil
+}
+
+// HasScope -> The session tokens have every scope. The personal access tokens only the granted ones,
+// and none at all on the endpoints without a scope (the account, the sessions and the tokens themselves)
+func (payload *Payload) HasScope(scope string) bool {
+	if payload.ApiTokenId == "" {
+		return true
+	}
+
+	return scope != "" && slices.Contains(payload.Scopes, scope)
+}
@@ -1,11 +1,20 @@
 package utils
 
 import (
+	"context"
 	"errors"
 	"file_manager/token"
+	"fmt"
 	"net/http"
 )
 
+type requiredScopeKey struct{}
+
+// WithRequiredScope -> The scope the personal access tokens need on this request, CheckAuth enforces it
+func WithRequiredScope(ctx context.Context, scope string) context.Context {
+	return context.WithValue(ctx, requiredScopeKey{}, scope)
+}
+
 func CheckAuth(r *http.Request, paseto *token.PasetoMaker) (*token.Payload, error) {
 	authToken := r.Header.Get("Authorization")
 	if authToken == "" {
@@ -17,5 +26,14 @@ func CheckAuth(r *http.Request, paseto *token.PasetoMaker) (*token.Payload, erro
 		return nil, errors.New("unauthorized: invalid token")
 	}
 
+	scope, _ := r.Context().Value(requiredScopeKey{}).(string)
+	if !payload.HasScope(scope) {
+		if scope == "" {
+			return nil, errors.New("unauthorized: api tokens can not be used here")
+		}
+
+		return nil, fmt.Errorf("unauthorized: the api token lacks the '%s' scope", scope)
+	}
+
 	return payload, nil
 }
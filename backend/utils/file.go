@@ -1,118 +1,221 @@
 package utils
 
 import (
+	"bufio"
+	"context"
 	"crypto/rand"
-	"encoding/hex"
 	"errors"
+	"file_manager/storage"
 	"fmt"
 	"io"
+	"mime"
 	"mime/multipart"
 	"net/http"
-	"os"
+	"net/url"
+	"path"
 	"slices"
+	"strings"
 )
 
+// maxFieldSize -> Limit for the plain (non file) multipart fields
+const maxFieldSize = 10000
+
+var ErrFileTooLarge = errors.New("file exceeds the maximum allowed size")
+
+// UploadedFile -> A file which is streamed straight from the request body, nothing is buffered
 type UploadedFile struct {
-	File multipart.File
-	Size int64
-	Name string
+	File        io.Reader
+	Size        int64 // known after UploadToStorage
+	Name        string
+	ContentType string
+
+	request         *http.Request
+	multipartReader *multipart.Reader
 }
 
-func ReadFile(r *http.Request, maxMemory int64, allowedFormats []string) (*UploadedFile, error) {
-	if err := r.ParseMultipartForm(maxMemory); err != nil {
+// ReadFile -> Reads the multipart body until the "file" part and sniffs its content type from the first bytes.
+// The plain fields before (and after, once the file is uploaded) the file are available through r.FormValue
+func ReadFile(r *http.Request, maxSize int64, allowedFormats []string) (*UploadedFile, error) {
+	multipartReader, err := r.MultipartReader()
+	if err != nil {
 		return nil, err
 	}
 
-	// getting the file (from http)
-	file, handler, err := r.FormFile("file")
-	if err != nil {
-		return nil, err
+	r.Form = r.URL.Query()
+	r.PostForm = url.Values{}
+
+	for {
+		part, err := multipartReader.NextPart()
+		if err == io.EOF {
+			return nil, http.ErrMissingFile
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if part.FormName() != "file" {
+			if err := addFormField(r, part); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		bufferedPart := bufio.NewReaderSize(part, 512)
+
+		// Peek the first 512 bytes (without consuming them)
+		header, err := bufferedPart.Peek(512)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+
+		contentType, err := ValidateContentType(header, allowedFormats)
+		if err != nil {
+			return nil, err
+		}
+
+		uploadedFile := &UploadedFile{
+			File:            &sizeLimitedReader{reader: bufferedPart, remained: maxSize},
+			Name:            part.FileName(),
+			ContentType:     contentType,
+			request:         r,
+			multipartReader: multipartReader,
+		}
+
+		return uploadedFile, nil
 	}
+}
+
+// UploadToStorage -> Returns the storage key (file address) of the uploaded file
+func (file *UploadedFile) UploadToStorage(ctx context.Context, backend storage.Backend, uploadDir string) (string, error) {
+	fileAddress := uploadDir + GenerateFileName(file.Name)
 
-	uploadedFile := &UploadedFile{
-		File: file,
-		Size: handler.Size,
-		Name: handler.Filename,
+	countingReader := &CountingReader{Reader: file.File}
+	if err := backend.Put(ctx, fileAddress, countingReader, -1); err != nil {
+		_ = backend.Delete(ctx, fileAddress)
+		return "", err
 	}
 
-	if err := uploadedFile.validateFileFormat(allowedFormats); err != nil {
-		return nil, err
+	file.Size = countingReader.Count
+
+	if err := file.ReadRemainingFields(); err != nil {
+		_ = backend.Delete(ctx, fileAddress)
+		return "", err
 	}
 
-	return uploadedFile, nil
+	return fileAddress, nil
 }
 
-func (file *UploadedFile) UploadToDisk(uploadDir string) (string, error) {
-	if err := makeDir(uploadDir); err != nil {
-		return "", err
+// GetFileExtension -> The extension of the client`s file name, or the usual one of the content type if the name has none
+func GetFileExtension(fileName, contentType string) string {
+	if extension := path.Ext(fileName); extension != "" {
+		return strings.ToLower(extension)
 	}
 
-	dst, err := os.Create(uploadDir + file.generateFileName())
-	if err != nil {
-		return "", err
+	if extension, exists := commonExtensions[contentType]; exists {
+		return extension
 	}
-	defer dst.Close()
 
-	if _, err = io.Copy(dst, file.File); err != nil {
-		return "", err
+	// sorted alphabetically, not by popularity
+	extensions, err := mime.ExtensionsByType(contentType)
+	if err != nil || len(extensions) == 0 {
+		return ""
 	}
 
-	fileAddress := dst.Name()
-	return fileAddress, nil
+	return extensions[0]
 }
 
-func (file *UploadedFile) generateFileName() string {
+var commonExtensions = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"application/zip": ".zip",
+	"application/pdf": ".pdf",
+}
+
+func GenerateFileName(name string) string {
 	randomStr := rand.Text()
-	return randomStr + file.Name
+	return randomStr + name
 }
 
-func (file *UploadedFile) validateFileFormat(allowedTypes []string) error {
-	// Read first 512 bytes
-	header := make([]byte, 512)
-	n, err := file.File.Read(header)
-	if err != nil && err != io.EOF {
-		return err
-	}
+// ReadRemainingFields -> Collects the plain fields sent after the file, call it once the file is consumed
+func (file *UploadedFile) ReadRemainingFields() error {
+	for {
+		part, err := file.multipartReader.NextPart()
+		if err == io.EOF {
+			return nil
+		}
 
-	contentType := http.DetectContentType(header[:n])
-	if !slices.Contains(allowedTypes, contentType) {
-		return fmt.Errorf("invalid file type: %s. Must be in :%s", contentType, allowedTypes)
-	}
+		if err != nil {
+			return err
+		}
+
+		if part.FormName() == "file" {
+			return errors.New("only one file can be uploaded per request")
+		}
 
-	// Reset file pointer to the beginning for further reading
-	if seeker, ok := file.File.(io.Seeker); ok {
-		if _, err = seeker.Seek(0, io.SeekStart); err != nil {
+		if err := addFormField(file.request, part); err != nil {
 			return err
 		}
-	} else {
-		return errors.New("cannot seek file")
+	}
+}
+
+func addFormField(r *http.Request, part *multipart.Part) error {
+	value, err := io.ReadAll(io.LimitReader(part, maxFieldSize))
+	if err != nil {
+		return err
 	}
 
+	r.Form.Add(part.FormName(), string(value))
+	r.PostForm.Add(part.FormName(), string(value))
+
 	return nil
 }
 
-func makeDir(uploadDir string) error {
-	return os.MkdirAll(uploadDir, 0755)
+// sizeLimitedReader -> Fails as soon as more than the allowed bytes are read
+type sizeLimitedReader struct {
+	reader   io.Reader
+	remained int64
 }
 
-func DeleteFileFromDisk(address string) error {
-	return os.Remove(address)
-}
+func (limited *sizeLimitedReader) Read(p []byte) (int, error) {
+	if limited.remained < 0 {
+		return 0, ErrFileTooLarge
+	}
 
-func CheckFilePassword(hashedPassword, salt, rawPassword []byte) error {
-	decodedHashPassword, err := hex.DecodeString(string(hashedPassword))
-	if err != nil {
-		return err
+	// reading one extra byte to find out if the file is bigger than the limit
+	if int64(len(p)) > limited.remained+1 {
+		p = p[:limited.remained+1]
 	}
 
-	decodedSalt, err := hex.DecodeString(string(salt))
-	if err != nil {
-		return err
+	n, err := limited.reader.Read(p)
+	limited.remained -= int64(n)
+
+	if limited.remained < 0 {
+		return 0, ErrFileTooLarge
 	}
 
-	if !ValidateHash(rawPassword, decodedHashPassword, decodedSalt) {
-		return errors.New("password is invalid")
+	return n, err
+}
+
+// ValidateContentType -> Sniffs the content type from the first bytes (up to 512) of a file
+func ValidateContentType(header []byte, allowedTypes []string) (string, error) {
+	contentType := http.DetectContentType(header)
+	if !slices.Contains(allowedTypes, contentType) {
+		return "", fmt.Errorf("invalid file type: %s. Must be in :%s", contentType, allowedTypes)
 	}
 
-	return nil
+	return contentType, nil
+}
+
+// CountingReader -> Keeps track of how many bytes have been read through it
+type CountingReader struct {
+	Reader io.Reader
+	Count  int64
+}
+
+func (reader *CountingReader) Read(p []byte) (int, error) {
+	n, err := reader.Reader.Read(p)
+	reader.Count += int64(n)
+	return n, err
 }
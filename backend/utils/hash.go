@@ -3,6 +3,15 @@ package utils
 import (
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"os"
+	"strings"
 )
 
 const (
@@ -10,16 +19,125 @@ const (
 	SaltLength = 16
 )
 
-func Hash256(plainText, salt []byte) [Size]byte {
-	newPassword := append(plainText, salt...)
-	return sha256.Sum256(newPassword)
+// argon2id parameters of the new hashes (RFC 9106, with 64 MiB of memory). The stored hashes carry their own parameters
+const (
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024
+	argon2Threads = 2
+)
+
+var ErrInvalidPassword = errors.New("password is invalid")
+
+// HashPassword -> Hashes the password with argon2id (or bcrypt when PASSWORD_HASH_ALGORITHM is "bcrypt").
+// The result holds the algorithm, its parameters and the salt: $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+func HashPassword(rawPassword string) (string, error) {
+	if getPasswordHashAlgorithm() == "bcrypt" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(rawPassword), bcrypt.DefaultCost)
+		if err != nil {
+			return "", err
+		}
+
+		return string(hash), nil
+	}
+
+	salt, err := GenerateSalt()
+	if err != nil {
+		return "", err
+	}
+
+	hash := argon2.IDKey([]byte(rawPassword), salt, argon2Time, argon2Memory, argon2Threads, Size)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argon2Memory, argon2Time, argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(hash)), nil
+}
+
+// CheckPassword -> Verifies the password (in constant time) against an argon2id, a bcrypt or a legacy hash.
+// The legacy hashes are hex encoded sha256(password + salt), with the hex encoded salt stored apart.
+// The returned bool tells whether the hash should be replaced by a HashPassword one (legacy or outdated parameters)
+func CheckPassword(rawPassword, hashedPassword, salt string) (bool, error) {
+	switch {
+	case strings.HasPrefix(hashedPassword, "$argon2id$"):
+		return checkArgon2Password(rawPassword, hashedPassword)
+
+	case strings.HasPrefix(hashedPassword, "$2"):
+		if err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(rawPassword)); err != nil {
+			if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+				return false, ErrInvalidPassword
+			}
+
+			return false, err
+		}
+
+		cost, err := bcrypt.Cost([]byte(hashedPassword))
+		if err != nil {
+			return false, err
+		}
+
+		return getPasswordHashAlgorithm() != "bcrypt" || cost < bcrypt.DefaultCost, nil
+
+	default:
+		return true, checkLegacyPassword(rawPassword, hashedPassword, salt)
+	}
+}
+
+func checkArgon2Password(rawPassword, hashedPassword string) (bool, error) {
+	// "", "argon2id", "v=19", "m=65536,t=3,p=2", salt, hash
+	parts := strings.Split(hashedPassword, "$")
+	if len(parts) != 6 {
+		return false, errors.New("invalid argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, errors.New("unsupported argon2id version")
+	}
+
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false, fmt.Errorf("invalid argon2id parameters: %w", err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, err
+	}
+
+	hash, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, err
+	}
+
+	currentHash := argon2.IDKey([]byte(rawPassword), salt, time, memory, threads, uint32(len(hash)))
+	if subtle.ConstantTimeCompare(currentHash, hash) != 1 {
+		return false, ErrInvalidPassword
+	}
+
+	outdated := memory != argon2Memory || time != argon2Time || threads != argon2Threads || len(hash) != Size
+	return getPasswordHashAlgorithm() == "bcrypt" || outdated, nil
+}
+
+func checkLegacyPassword(rawPassword, hashedPassword, salt string) error {
+	decodedHash, err := hex.DecodeString(hashedPassword)
+	if err != nil {
+		return err
+	}
+
+	decodedSalt, err := hex.DecodeString(salt)
+	if err != nil {
+		return err
+	}
+
+	currentHash := sha256.Sum256(append([]byte(rawPassword), decodedSalt...))
+	if subtle.ConstantTimeCompare(currentHash[:], decodedHash) != 1 {
+		return ErrInvalidPassword
+	}
+
+	return nil
 }
 
-func ValidateHash(plainText, hashedText, salt []byte) bool {
-	newText := append(plainText, salt...)
-	currentHash := sha256.Sum256(newText)
-	
-	return string(currentHash[:]) == string(hashedText)
+func getPasswordHashAlgorithm() string {
+	return os.Getenv("PASSWORD_HASH_ALGORITHM")
 }
 
 func GenerateSalt() ([]byte, error) {
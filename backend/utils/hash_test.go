@@ -0,0 +1,169 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"testing"
+)
+
+func TestCheckPassword(t *testing.T) {
+	const password = "correct horse battery staple"
+
+	tests := []struct {
+		name        string
+		hashWith    string // PASSWORD_HASH_ALGORITHM when hashing
+		checkWith   string // and when checking
+		hash        func(t *testing.T) (string, string)
+		password    string
+		wantErr     error
+		needsRehash bool
+	}{
+		{"argon2id", "", "", hashPassword(password), password, nil, false},
+		{"argon2id, wrong password", "", "", hashPassword(password), "wrong", ErrInvalidPassword, false},
+		{"argon2id, empty password", "", "", hashPassword(password), "", ErrInvalidPassword, false},
+		{"argon2id, switched to bcrypt", "", "bcrypt", hashPassword(password), password, nil, true},
+		{"argon2id, outdated parameters", "", "", argon2Hash(password, 32*1024, 2, 1), password, nil, true},
+		{"bcrypt", "bcrypt", "bcrypt", hashPassword(password), password, nil, false},
+		{"bcrypt, wrong password", "bcrypt", "bcrypt", hashPassword(password), "wrong", ErrInvalidPassword, false},
+		{"bcrypt, switched to argon2id", "bcrypt", "", hashPassword(password), password, nil, true},
+		{"bcrypt, outdated cost", "", "bcrypt", bcryptHash(password, bcrypt.MinCost), password, nil, true},
+		{"legacy sha256", "", "", legacyHash(password), password, nil, true},
+		{"legacy sha256, wrong password", "", "", legacyHash(password), "wrong", ErrInvalidPassword, true},
+		{"legacy sha256, stored hash", "", "", storedHash("cf80a060df30057864eac927dacb65c3a5022edd1d48a81121729a36372f91df",
+			"000102030405060708090a0b0c0d0e0f"), "password", nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("PASSWORD_HASH_ALGORITHM", test.hashWith)
+			hashedPassword, salt := test.hash(t)
+
+			t.Setenv("PASSWORD_HASH_ALGORITHM", test.checkWith)
+			needsRehash, err := CheckPassword(test.password, hashedPassword, salt)
+
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("CheckPassword() error = %v, want %v", err, test.wantErr)
+			}
+
+			if needsRehash != test.needsRehash {
+				t.Errorf("CheckPassword() needsRehash = %t, want %t", needsRehash, test.needsRehash)
+			}
+		})
+	}
+}
+
+func TestHashPasswordFormat(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		prefix    string
+	}{
+		{"", "$argon2id$v=19$m=65536,t=3,p=2$"},
+		{"bcrypt", fmt.Sprintf("$2a$%02d$", bcrypt.DefaultCost)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.prefix, func(t *testing.T) {
+			t.Setenv("PASSWORD_HASH_ALGORITHM", test.algorithm)
+
+			first, err := HashPassword("password")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			second, err := HashPassword("password")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.HasPrefix(first, test.prefix) {
+				t.Errorf("HashPassword() = %q, want the prefix %q", first, test.prefix)
+			}
+
+			if first == second {
+				t.Error("HashPassword() gives the same hash twice, the salt is not random")
+			}
+		})
+	}
+}
+
+func TestCheckPasswordInvalidHash(t *testing.T) {
+	tests := []struct {
+		name           string
+		hashedPassword string
+		salt           string
+	}{
+		{"argon2id without hash", "$argon2id$v=19$m=65536,t=3,p=2$c2FsdA", ""},
+		{"argon2id of another version", "$argon2id$v=16$m=65536,t=3,p=2$c2FsdA$aGFzaA", ""},
+		{"argon2id with invalid parameters", "$argon2id$v=19$m=x,t=3,p=2$c2FsdA$aGFzaA", ""},
+		{"argon2id with invalid salt", "$argon2id$v=19$m=65536,t=3,p=2$!$aGFzaA", ""},
+		{"legacy, not hex", "not hex", "00"},
+		{"legacy, salt not hex", hex.EncodeToString(make([]byte, sha256.Size)), "not hex"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := CheckPassword("password", test.hashedPassword, test.salt)
+			if err == nil || errors.Is(err, ErrInvalidPassword) {
+				t.Errorf("CheckPassword() error = %v, want a hash error", err)
+			}
+		})
+	}
+}
+
+func hashPassword(password string) func(t *testing.T) (string, string) {
+	return func(t *testing.T) (string, string) {
+		hashedPassword, err := HashPassword(password)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return hashedPassword, ""
+	}
+}
+
+func argon2Hash(password string, memory, time uint32, threads uint8) func(t *testing.T) (string, string) {
+	return func(t *testing.T) (string, string) {
+		salt := []byte("0123456789abcdef")
+		hash := argon2.IDKey([]byte(password), salt, time, memory, threads, Size)
+
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, memory, time, threads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(hash)), ""
+	}
+}
+
+func bcryptHash(password string, cost int) func(t *testing.T) (string, string) {
+	return func(t *testing.T) (string, string) {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return string(hash), ""
+	}
+}
+
+func storedHash(hashedPassword, salt string) func(t *testing.T) (string, string) {
+	return func(t *testing.T) (string, string) {
+		return hashedPassword, salt
+	}
+}
+
+// legacyHash -> hex(sha256(password + salt)), with the hex encoded salt stored apart
+func legacyHash(password string) func(t *testing.T) (string, string) {
+	return func(t *testing.T) (string, string) {
+		salt, err := GenerateSalt()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		hash := sha256.Sum256(append([]byte(password), salt...))
+
+		return hex.EncodeToString(hash[:]), hex.EncodeToString(salt)
+	}
+}
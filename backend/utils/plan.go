@@ -43,6 +43,34 @@ func GetUserExpirationDate(plan string) time.Time {
 	}
 }
 
+// GetUserTrashPurgeDate -> Trashed files and folders are purged at this date
+func GetUserTrashPurgeDate(plan string) time.Time {
+	switch plan {
+	case "free":
+		return time.Now().Add(7 * time.Hour * 24) // 7 Days
+	case "plus":
+		return time.Now().Add(30 * time.Hour * 24) // 30 Days
+	case "premium":
+		return time.Now().Add(90 * time.Hour * 24) // 90 Days
+	default:
+		return time.Now().Add(7 * time.Hour * 24) // 7 Days
+	}
+}
+
+// GetUserMaxFileVersions -> How many previous versions of a file are kept
+func GetUserMaxFileVersions(plan string) int {
+	switch plan {
+	case "free":
+		return 3
+	case "plus":
+		return 10
+	case "premium":
+		return 50
+	default:
+		return 3
+	}
+}
+
 func GetUserTotalStorage(plan string) (int64, error) {
 	switch plan {
 	case "free":
@@ -80,6 +108,30 @@ func GetTeamExpirationDate(plan string) time.Time {
 	}
 }
 
+// GetTeamTrashPurgeDate -> Trashed files and folders are purged at this date
+func GetTeamTrashPurgeDate(plan string) time.Time {
+	switch plan {
+	case "free":
+		return time.Now().Add(14 * time.Hour * 24) // 14 Days
+	case "premium":
+		return time.Now().Add(60 * time.Hour * 24) // 60 Days
+	default:
+		return time.Now().Add(7 * time.Hour * 24) // 7 Days
+	}
+}
+
+// GetTeamMaxFileVersions -> How many previous versions of a file are kept
+func GetTeamMaxFileVersions(plan string) int {
+	switch plan {
+	case "free":
+		return 5
+	case "premium":
+		return 50
+	default:
+		return 3
+	}
+}
+
 func GetTeamTotalStorage(plan string) (int64, error) {
 	switch plan {
 	case "free":
@@ -102,10 +154,6 @@ func GetTeamTotalUsers(plan string) int {
 	return 5
 }
 
-func GetTeamUploadDir(teamId string) string {
-	return "uploads/team_files/files/" + teamId + "/"
-}
-
 // ValidateUserPlan -> free, plus, premium
 func ValidateUserPlan(plan string) error {
 	if plan == "free" || plan == "plus" || plan == "premium" {
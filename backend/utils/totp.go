@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+)
+
+// TOTP (RFC 6238) with the parameters every authenticator app supports: HMAC-SHA1, 6 digits, 30 seconds
+const (
+	TotpSecretSize = 20
+	totpDigits     = 6
+	totpPeriod     = 30
+	totpSkew       = 1 // steps accepted before and after the current one, for the clock drift
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func GenerateTotpSecret() ([]byte, error) {
+	secret := make([]byte, TotpSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	return secret, nil
+}
+
+// EncodeTotpSecret -> The form the users type into their authenticator app
+func EncodeTotpSecret(secret []byte) string {
+	return totpEncoding.EncodeToString(secret)
+}
+
+// GetTotpUri -> The otpauth uri (shown as a QR code) of the secret, the issuer is TOTP_ISSUER ("File Manager" by default)
+func GetTotpUri(accountName string, secret []byte) string {
+	issuer := os.Getenv("TOTP_ISSUER")
+	if issuer == "" {
+		issuer = "File Manager"
+	}
+
+	query := url.Values{}
+	query.Set("secret", EncodeTotpSecret(secret))
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+
+	label := url.PathEscape(issuer + ":" + accountName)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// ValidateTotp -> Returns the time step the code matched, the callers reject the steps already used (replays)
+func ValidateTotp(secret []byte, code string, now time.Time) (int64, bool) {
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	currentStep := now.Unix() / totpPeriod
+
+	for step := currentStep - totpSkew; step <= currentStep+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(getTotpCode(secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// getTotpCode -> HOTP (RFC 4226) of the time step
+func getTotpCode(secret []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, value%1_000_000)
+}
@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the SHA-1 secret of the RFC 6238 test vectors
+var rfc6238Secret = []byte("12345678901234567890")
+
+// TestTotpRfc6238 -> The SHA-1 vectors of RFC 6238 Appendix B, their last 6 digits (the codes have 8 digits there)
+func TestTotpRfc6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},          // 94287082
+		{1111111109, "081804"},  // 07081804
+		{1111111111, "050471"},  // 14050471
+		{1234567890, "005924"},  // 89005924
+		{2000000000, "279037"},  // 69279037
+		{20000000000, "353130"}, // 65353130
+	}
+
+	for _, test := range tests {
+		now := time.Unix(test.unix, 0)
+
+		if code := getTotpCode(rfc6238Secret, test.unix/totpPeriod); code != test.code {
+			t.Errorf("getTotpCode() at %d = %s, want %s", test.unix, code, test.code)
+		}
+
+		step, ok := ValidateTotp(rfc6238Secret, test.code, now)
+		if !ok || step != test.unix/totpPeriod {
+			t.Errorf("ValidateTotp() at %d = %d, %t, want %d, true", test.unix, step, ok, test.unix/totpPeriod)
+		}
+	}
+}
+
+// TestValidateTotpSkew -> The codes of the previous and the next step are accepted (clock drift), no others
+func TestValidateTotpSkew(t *testing.T) {
+	const unix = 1111111109
+	codeStep := int64(unix / totpPeriod)
+	code := getTotpCode(rfc6238Secret, codeStep)
+
+	tests := []struct {
+		name   string
+		offset int64 // steps between the code and the validation time
+		ok     bool
+	}{
+		{"same step", 0, true},
+		{"one step later", 1, true},
+		{"one step earlier", -1, true},
+		{"two steps later", 2, false},
+		{"two steps earlier", -2, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			now := time.Unix((codeStep+test.offset)*totpPeriod, 0)
+
+			step, ok := ValidateTotp(rfc6238Secret, code, now)
+			if ok != test.ok {
+				t.Fatalf("ValidateTotp() = %t, want %t", ok, test.ok)
+			}
+
+			// the matched step of the code is returned (and not the current one), it is what the replay check stores
+			if ok && step != codeStep {
+				t.Errorf("ValidateTotp() step = %d, want %d", step, codeStep)
+			}
+		})
+	}
+
+	// the window ends with the next step: its last second still accepts the code, the second after it does not
+	if _, ok := ValidateTotp(rfc6238Secret, code, time.Unix((codeStep+2)*totpPeriod-1, 0)); !ok {
+		t.Error("ValidateTotp() rejects the code at the end of the next step")
+	}
+
+	if _, ok := ValidateTotp(rfc6238Secret, code, time.Unix((codeStep+2)*totpPeriod, 0)); ok {
+		t.Error("ValidateTotp() accepts the code after the window")
+	}
+}
+
+func TestValidateTotpInvalidCode(t *testing.T) {
+	now := time.Unix(59, 0)
+
+	for _, code := range []string{"", "28708", "2870822", "94287082", "287083", "abcdef"} {
+		if _, ok := ValidateTotp(rfc6238Secret, code, now); ok {
+			t.Errorf("ValidateTotp() accepts %q", code)
+		}
+	}
+}
+
+func TestGetTotpUri(t *testing.T) {
+	t.Setenv("TOTP_ISSUER", "")
+
+	uri, err := url.Parse(GetTotpUri("alice@example.com", rfc6238Secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/File Manager:alice@example.com" {
+		t.Errorf("GetTotpUri() = %s", uri)
+	}
+
+	query := uri.Query()
+	if query.Get("secret") != "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" || query.Get("issuer") != "File Manager" ||
+		query.Get("digits") != "6" || query.Get("period") != "30" || strings.Contains(query.Get("secret"), "=") {
+
+		t.Errorf("GetTotpUri() query = %v", query)
+	}
+}
@@ -3,6 +3,7 @@ package webserver
 import (
 	"errors"
 	"file_manager/handlers"
+	"file_manager/utils"
 	"net/http"
 	"os"
 	"strings"
@@ -76,8 +77,10 @@ func CORSMiddleware(next http.Handler) http.Handler {
 			}
 		}
 
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Upload-Offset, Range, If-None-Match, If-Range")
+		w.Header().Set("Access-Control-Expose-Headers", "Upload-Offset, Upload-Length, Content-Range, Content-Length, "+
+			"Content-Disposition, Accept-Ranges, ETag")
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 
 		// browser preflight requests
@@ -98,3 +101,11 @@ func getAllowedCORS() []string {
 
 	return strings.Split(origins, ",")
 }
+
+// RequireScope -> The personal access tokens need the scope to use the endpoint (utils.CheckAuth checks it).
+// The endpoints without it are for the session tokens only
+func RequireScope(scope string, httpHandler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHandler(w, r.WithContext(utils.WithRequiredScope(r.Context(), scope)))
+	}
+}
@@ -2,8 +2,8 @@ package webserver
 
 import (
 	"file_manager/handlers"
+	"file_manager/token"
 	"github.com/julienschmidt/httprouter"
-	"net/http"
 )
 
 type AppRouter struct {
@@ -20,98 +20,191 @@ func NewRouter(handler *handlers.Handler) *AppRouter {
 	return routerInstance
 }
 
-// do not use OPTIONS method. Allowed Methods: GET, POST, PUT, DELETE
+// do not use OPTIONS method. Allowed Methods: GET, POST, PUT, PATCH, DELETE
+// The routes wrapped with RequireScope accept the personal access tokens with that scope, the others the session tokens only
 func (router *AppRouter) registerRoutes(handler *handlers.Handler) {
-	router.registerStaticRoutes()
+	router.registerStaticRoutes(handler)
 
 	router.registerAuthRoutes(handler)
 	router.registerUserRoutes(handler)
 
 	router.registerFileRoutes(handler)
 	router.registerFileSettingsRoutes(handler)
+	router.registerUploadSessionRoutes(handler)
 
 	router.registerFolderRoutes(handler)
+	router.registerFolderSettingsRoutes(handler)
+	router.registerTrashRoutes(handler)
 
 	router.registerApprovalRoutes(handler)
+	router.registerShareRoutes(handler)
 
 	router.registerTeamRoutes(handler)
+	router.registerApiTokenRoutes(handler)
 }
 
-// registerStaticRoutes -> Static Files
-func (router *AppRouter) registerStaticRoutes() {
-	staticFilesHandler := getStaticFilesHandler()
-	router.CoreRouter.Handler("GET", "/static/*filepath", staticFilesHandler)
+// registerStaticRoutes -> Static Files (avatars only)
+func (router *AppRouter) registerStaticRoutes(handler *handlers.Handler) {
+	router.CoreRouter.HandlerFunc("GET", "/static/*filepath", handler.ServeStaticFile)
 }
 
 // registerAuthRoutes -> Auth
 func (router *AppRouter) registerAuthRoutes(handler *handlers.Handler) {
 	router.CoreRouter.HandlerFunc("POST", "/api/auth/register", handler.Register)
 	router.CoreRouter.HandlerFunc("POST", "/api/auth/login", handler.Login)
+	router.CoreRouter.HandlerFunc("POST", "/api/auth/refresh", handler.RefreshToken)
+	router.CoreRouter.HandlerFunc("POST", "/api/auth/logout", handler.Logout)
+	router.CoreRouter.HandlerFunc("POST", "/api/auth/logout/all", handler.LogoutAll)
+
+	// two-factor authentication (TOTP), verify is the second login step
+	router.CoreRouter.HandlerFunc("POST", "/api/auth/2fa/verify", handler.VerifyTwoFactorLogin)
+	router.CoreRouter.HandlerFunc("POST", "/api/auth/2fa/enroll", handler.EnrollTwoFactor)
+	router.CoreRouter.HandlerFunc("POST", "/api/auth/2fa/enable", handler.EnableTwoFactor)
+	router.CoreRouter.HandlerFunc("POST", "/api/auth/2fa/disable", handler.DisableTwoFactor)
+	router.CoreRouter.HandlerFunc("POST", "/api/auth/2fa/recovery/regenerate", handler.RegenerateRecoveryCodes)
+
+	// single sign-on (OpenID Connect), login and callback are browser navigations
+	router.CoreRouter.HandlerFunc("GET", "/api/auth/oidc/providers", handler.GetOidcProviders)
+	router.CoreRouter.HandlerFunc("GET", "/api/auth/oidc/login/:provider", handler.StartOidcLogin)
+	router.CoreRouter.HandlerFunc("GET", "/api/auth/oidc/callback/:provider", handler.OidcCallback)
+	router.CoreRouter.HandlerFunc("POST", "/api/auth/oidc/exchange", handler.ExchangeOidcTicket)
+	router.CoreRouter.HandlerFunc("POST", "/api/auth/oidc/link", handler.CreateOidcLinkToken)
 }
 
 // registerUserRoutes -> Users
 func (router *AppRouter) registerUserRoutes(handler *handlers.Handler) {
 	router.CoreRouter.HandlerFunc("DELETE", "/api/user/delete", handler.DeleteUserAccount)
 	router.CoreRouter.HandlerFunc("PUT", "/api/user/plan/change", handler.UpdateUserPlan)
-	router.CoreRouter.HandlerFunc("POST", "/api/user/avatar/upload", handler.UploadUserAvatar)
-	router.CoreRouter.HandlerFunc("GET", "/api/user/search", handler.SearchUserContents)
-	router.CoreRouter.HandlerFunc("GET", "/api/user/get", handler.GetUser)
+	router.CoreRouter.HandlerFunc("POST", "/api/user/avatar/upload", RequireScope(token.ScopeAccountManage, handler.UploadUserAvatar))
+	router.CoreRouter.HandlerFunc("GET", "/api/user/search", RequireScope(token.ScopeFilesRead, handler.SearchUserContents))
+	router.CoreRouter.HandlerFunc("GET", "/api/user/get", RequireScope(token.ScopeAccountManage, handler.GetUser))
 }
 
 // registerFileRoutes -> Files
 func (router *AppRouter) registerFileRoutes(handler *handlers.Handler) {
-	router.CoreRouter.HandlerFunc("POST", "/api/file/create", handler.UploadUserFile)
-	router.CoreRouter.HandlerFunc("GET", "/api/file/get", handler.GetFiles)
-	router.CoreRouter.HandlerFunc("DELETE", "/api/file/delete/:id", handler.DeleteFile)
-	router.CoreRouter.HandlerFunc("PUT", "/api/file/rename/:id", handler.RenameFile)
-	router.CoreRouter.HandlerFunc("POST", "/api/file/search", handler.SearchFiles)
-	router.CoreRouter.HandlerFunc("GET", "/api/file/download/:id", handler.DownloadFile)
+	router.CoreRouter.HandlerFunc("POST", "/api/file/create", RequireScope(token.ScopeFilesWrite, handler.UploadUserFile))
+	router.CoreRouter.HandlerFunc("GET", "/api/file/get", RequireScope(token.ScopeFilesRead, handler.GetFiles))
+	router.CoreRouter.HandlerFunc("DELETE", "/api/file/delete/:id", RequireScope(token.ScopeFilesWrite, handler.DeleteFile))
+	router.CoreRouter.HandlerFunc("PUT", "/api/file/rename/:id", RequireScope(token.ScopeFilesWrite, handler.RenameFile))
+	router.CoreRouter.HandlerFunc("PUT", "/api/file/move/:id", RequireScope(token.ScopeFilesWrite, handler.MoveFile))
+	router.CoreRouter.HandlerFunc("POST", "/api/file/copy/:id", RequireScope(token.ScopeFilesWrite, handler.CopyFile))
+	router.CoreRouter.HandlerFunc("POST", "/api/file/search", RequireScope(token.ScopeFilesRead, handler.SearchFiles))
+	router.CoreRouter.HandlerFunc("GET", "/api/file/download/:id", RequireScope(token.ScopeFilesRead, handler.DownloadFile))
+	router.CoreRouter.HandlerFunc("POST", "/api/file/download/:id", RequireScope(token.ScopeFilesRead, handler.DownloadFile))
+
+	// versions (for the file owner or the team members)
+	router.CoreRouter.HandlerFunc("POST", "/api/file/version/upload/:id", RequireScope(token.ScopeFilesWrite, handler.UploadFileVersion))
+	router.CoreRouter.HandlerFunc("GET", "/api/file/version/get/:id", RequireScope(token.ScopeFilesRead, handler.GetFileVersions))
+	router.CoreRouter.HandlerFunc("GET", "/api/file/version/download/:id", RequireScope(token.ScopeFilesRead, handler.DownloadFileVersion))
+	router.CoreRouter.HandlerFunc("PUT", "/api/file/version/restore/:id", RequireScope(token.ScopeFilesWrite, handler.RestoreFileVersion))
 
 	// GET method (for password-less files)
-	router.CoreRouter.HandlerFunc("GET", "/api/file/get/:id", handler.GetFile)
+	router.CoreRouter.HandlerFunc("GET", "/api/file/get/:id", RequireScope(token.ScopeFilesRead, handler.GetFile))
 	// POST method (for password requirable files)
-	router.CoreRouter.HandlerFunc("POST", "/api/file/get/:id", handler.GetFile)
+	router.CoreRouter.HandlerFunc("POST", "/api/file/get/:id", RequireScope(token.ScopeFilesRead, handler.GetFile))
+
+	// inline previews (the only way to see the files of the view-only links), GET and POST like above
+	router.CoreRouter.HandlerFunc("GET", "/api/file/preview/:id", RequireScope(token.ScopeFilesRead, handler.PreviewFile))
+	router.CoreRouter.HandlerFunc("POST", "/api/file/preview/:id", RequireScope(token.ScopeFilesRead, handler.PreviewFile))
+
+	// the short-lived content urls GetFile issues
+	router.CoreRouter.HandlerFunc("GET", "/api/file/content/:token", handler.ServeFileContent)
 }
 
 // registerFileSettingsRoutes -> File Settings
 func (router *AppRouter) registerFileSettingsRoutes(handler *handlers.Handler) {
-	router.CoreRouter.HandlerFunc("POST", "/api/file/settings/create/:id", handler.CreateFileSettings)
-	router.CoreRouter.HandlerFunc("GET", "/api/file/settings/get", handler.GetFilesSettings)
-	router.CoreRouter.HandlerFunc("DELETE", "/api/file/settings/delete/:id", handler.DeleteFileSettings)
+	router.CoreRouter.HandlerFunc("POST", "/api/file/settings/create/:id", RequireScope(token.ScopeSharesManage, handler.CreateFileSettings))
+	router.CoreRouter.HandlerFunc("GET", "/api/file/settings/get", RequireScope(token.ScopeSharesManage, handler.GetFilesSettings))
+	router.CoreRouter.HandlerFunc("PUT", "/api/file/settings/update/:id", RequireScope(token.ScopeSharesManage, handler.UpdateFileSettings))
+	router.CoreRouter.HandlerFunc("DELETE", "/api/file/settings/delete/:id", RequireScope(token.ScopeSharesManage, handler.DeleteFileSettings))
+}
+
+// registerUploadSessionRoutes -> Resumable (chunked) uploads
+func (router *AppRouter) registerUploadSessionRoutes(handler *handlers.Handler) {
+	router.CoreRouter.HandlerFunc("POST", "/api/upload/session/create", RequireScope(token.ScopeFilesWrite, handler.CreateUploadSession))
+	router.CoreRouter.HandlerFunc("GET", "/api/upload/session/get/:id", RequireScope(token.ScopeFilesWrite, handler.GetUploadSession))
+	router.CoreRouter.HandlerFunc("PATCH", "/api/upload/session/upload/:id", RequireScope(token.ScopeFilesWrite, handler.UploadChunk))
+	router.CoreRouter.HandlerFunc("POST", "/api/upload/session/finalize/:id", RequireScope(token.ScopeFilesWrite, handler.FinalizeUploadSession))
+	router.CoreRouter.HandlerFunc("DELETE", "/api/upload/session/delete/:id", RequireScope(token.ScopeFilesWrite, handler.DeleteUploadSession))
 }
 
 // registerFileRoutes -> Folder
 func (router *AppRouter) registerFolderRoutes(handler *handlers.Handler) {
-	router.CoreRouter.HandlerFunc("POST", "/api/folder/create", handler.CreateFolder)
-	router.CoreRouter.HandlerFunc("GET", "/api/folder/get", handler.GetFoldersList)
-	router.CoreRouter.HandlerFunc("GET", "/api/folder/get/:id", handler.GetFolderContents)
-	router.CoreRouter.HandlerFunc("PUT", "/api/folder/rename/:id", handler.RenameFolder)
-	router.CoreRouter.HandlerFunc("DELETE", "/api/folder/delete/:id", handler.DeleteFolder)
+	router.CoreRouter.HandlerFunc("POST", "/api/folder/create", RequireScope(token.ScopeFilesWrite, handler.CreateFolder))
+	router.CoreRouter.HandlerFunc("GET", "/api/folder/get", RequireScope(token.ScopeFilesRead, handler.GetFoldersList))
+	router.CoreRouter.HandlerFunc("GET", "/api/folder/get/:id", RequireScope(token.ScopeFilesRead, handler.GetFolderContents))
+	router.CoreRouter.HandlerFunc("GET", "/api/folder/path/:id", RequireScope(token.ScopeFilesRead, handler.GetFolderPath))
+	router.CoreRouter.HandlerFunc("PUT", "/api/folder/rename/:id", RequireScope(token.ScopeFilesWrite, handler.RenameFolder))
+	router.CoreRouter.HandlerFunc("PUT", "/api/folder/move/:id", RequireScope(token.ScopeFilesWrite, handler.MoveFolder))
+	router.CoreRouter.HandlerFunc("POST", "/api/folder/copy/:id", RequireScope(token.ScopeFilesWrite, handler.CopyFolder))
+	router.CoreRouter.HandlerFunc("DELETE", "/api/folder/delete/:id", RequireScope(token.ScopeFilesWrite, handler.DeleteFolder))
+}
+
+// registerFolderSettingsRoutes -> Folder Settings (share links of folders)
+func (router *AppRouter) registerFolderSettingsRoutes(handler *handlers.Handler) {
+	router.CoreRouter.HandlerFunc("POST", "/api/folder/settings/create/:id", RequireScope(token.ScopeSharesManage, handler.CreateFolderSettings))
+	router.CoreRouter.HandlerFunc("GET", "/api/folder/settings/get", RequireScope(token.ScopeSharesManage, handler.GetFoldersSettings))
+	router.CoreRouter.HandlerFunc("PUT", "/api/folder/settings/update/:id", RequireScope(token.ScopeSharesManage, handler.UpdateFolderSettings))
+	router.CoreRouter.HandlerFunc("DELETE", "/api/folder/settings/delete/:id", RequireScope(token.ScopeSharesManage, handler.DeleteFolderSettings))
+
+	// GET method (for password-less links)
+	router.CoreRouter.HandlerFunc("GET", "/api/folder/shared/get/:id", RequireScope(token.ScopeFilesRead, handler.GetSharedFolder))
+	router.CoreRouter.HandlerFunc("GET", "/api/folder/shared/download/:id/:file_id", RequireScope(token.ScopeFilesRead, handler.DownloadSharedFile))
+	router.CoreRouter.HandlerFunc("GET", "/api/folder/shared/preview/:id/:file_id", RequireScope(token.ScopeFilesRead, handler.PreviewSharedFile))
+	// POST method (for password requirable links)
+	router.CoreRouter.HandlerFunc("POST", "/api/folder/shared/get/:id", RequireScope(token.ScopeFilesRead, handler.GetSharedFolder))
+	router.CoreRouter.HandlerFunc("POST", "/api/folder/shared/download/:id/:file_id", RequireScope(token.ScopeFilesRead, handler.DownloadSharedFile))
+	router.CoreRouter.HandlerFunc("POST", "/api/folder/shared/preview/:id/:file_id", RequireScope(token.ScopeFilesRead, handler.PreviewSharedFile))
+}
+
+// registerTrashRoutes -> Trash
+func (router *AppRouter) registerTrashRoutes(handler *handlers.Handler) {
+	router.CoreRouter.HandlerFunc("GET", "/api/trash/get", RequireScope(token.ScopeFilesRead, handler.GetTrash))
+	router.CoreRouter.HandlerFunc("PUT", "/api/trash/file/restore/:id", RequireScope(token.ScopeFilesWrite, handler.RestoreFile))
+	router.CoreRouter.HandlerFunc("DELETE", "/api/trash/file/purge/:id", RequireScope(token.ScopeFilesWrite, handler.PurgeFile))
+	router.CoreRouter.HandlerFunc("PUT", "/api/trash/folder/restore/:id", RequireScope(token.ScopeFilesWrite, handler.RestoreFolder))
+	router.CoreRouter.HandlerFunc("DELETE", "/api/trash/folder/purge/:id", RequireScope(token.ScopeFilesWrite, handler.PurgeFolder))
+	router.CoreRouter.HandlerFunc("DELETE", "/api/trash/empty", RequireScope(token.ScopeFilesWrite, handler.EmptyTrash))
 }
 
 // registerApprovalRoutes -> Approvals
 func (router *AppRouter) registerApprovalRoutes(handler *handlers.Handler) {
-	router.CoreRouter.HandlerFunc("GET", "/api/approval/sent/get", handler.GetSendApprovalsList)
-	router.CoreRouter.HandlerFunc("GET", "/api/approval/received/get", handler.GetReceivedApprovalsList)
-	router.CoreRouter.HandlerFunc("POST", "/api/approval/create", handler.CreateApproval)
-	router.CoreRouter.HandlerFunc("GET", "/api/approval/check/:id", handler.CheckApproval)
-	router.CoreRouter.HandlerFunc("PUT", "/api/approval/update/status", handler.UpdateApproval)
-	router.CoreRouter.HandlerFunc("DELETE", "/api/approval/delete/:id", handler.DeleteApproval)
+	router.CoreRouter.HandlerFunc("GET", "/api/approval/sent/get", RequireScope(token.ScopeSharesManage, handler.GetSendApprovalsList))
+	router.CoreRouter.HandlerFunc("GET", "/api/approval/received/get", RequireScope(token.ScopeSharesManage, handler.GetReceivedApprovalsList))
+	router.CoreRouter.HandlerFunc("POST", "/api/approval/create", RequireScope(token.ScopeSharesManage, handler.CreateApproval))
+	router.CoreRouter.HandlerFunc("GET", "/api/approval/check/:id", RequireScope(token.ScopeSharesManage, handler.CheckApproval))
+	router.CoreRouter.HandlerFunc("PUT", "/api/approval/update/status", RequireScope(token.ScopeSharesManage, handler.UpdateApproval))
+	router.CoreRouter.HandlerFunc("DELETE", "/api/approval/delete/:id", RequireScope(token.ScopeSharesManage, handler.DeleteApproval))
+}
+
+// registerShareRoutes -> Shares with users and teams
+func (router *AppRouter) registerShareRoutes(handler *handlers.Handler) {
+	router.CoreRouter.HandlerFunc("POST", "/api/share/create", RequireScope(token.ScopeSharesManage, handler.CreateShare))
+	router.CoreRouter.HandlerFunc("GET", "/api/share/get", RequireScope(token.ScopeSharesManage, handler.GetShares))
+	router.CoreRouter.HandlerFunc("GET", "/api/share/received", RequireScope(token.ScopeFilesRead, handler.GetReceivedShares))
+	router.CoreRouter.HandlerFunc("PUT", "/api/share/update/:id", RequireScope(token.ScopeSharesManage, handler.UpdateShare))
+	router.CoreRouter.HandlerFunc("DELETE", "/api/share/delete/:id", RequireScope(token.ScopeSharesManage, handler.DeleteShare))
+
+	// access to the shared items (the versions and renaming go through the file and folder routes)
+	router.CoreRouter.HandlerFunc("GET", "/api/share/folder/get/:id", RequireScope(token.ScopeFilesRead, handler.GetSharedWithMeFolder))
+	router.CoreRouter.HandlerFunc("POST", "/api/share/folder/upload/:id", RequireScope(token.ScopeFilesWrite, handler.UploadToSharedFolder))
+	router.CoreRouter.HandlerFunc("GET", "/api/share/file/download/:id", RequireScope(token.ScopeFilesRead, handler.DownloadSharedWithMeFile))
 }
 
 // registerTeamRoutes -> Teams
 func (router *AppRouter) registerTeamRoutes(handler *handlers.Handler) {
-	router.CoreRouter.HandlerFunc("GET", "/api/team/get", handler.GetTeams)
-	router.CoreRouter.HandlerFunc("GET", "/api/team/get/:id", handler.GetTeam)
-	router.CoreRouter.HandlerFunc("POST", "/api/team/create", handler.CreateTeam)
-	router.CoreRouter.HandlerFunc("POST", "/api/team/file/upload/:id", handler.UploadTeamFile)
-	router.CoreRouter.HandlerFunc("DELETE", "/api/team/delete/:id", handler.DeleteTeam)
-	router.CoreRouter.HandlerFunc("POST", "/api/team/user/add/:id", handler.AddUserToTeam)
-	router.CoreRouter.HandlerFunc("PUT", "/api/team/plan/update/:id", handler.UpdateTeamPlan)
-}
-
-func getStaticFilesHandler() http.Handler {
-	dir := http.Dir("./")
-	fileServer := http.FileServer(dir)
-	return http.StripPrefix("/static/", fileServer)
+	router.CoreRouter.HandlerFunc("GET", "/api/team/get", RequireScope(token.ScopeTeamsRead, handler.GetTeams))
+	router.CoreRouter.HandlerFunc("GET", "/api/team/get/:id", RequireScope(token.ScopeTeamsRead, handler.GetTeam))
+	router.CoreRouter.HandlerFunc("POST", "/api/team/create", RequireScope(token.ScopeTeamsAdmin, handler.CreateTeam))
+	router.CoreRouter.HandlerFunc("POST", "/api/team/file/upload/:id", RequireScope(token.ScopeFilesWrite, handler.UploadTeamFile))
+	router.CoreRouter.HandlerFunc("DELETE", "/api/team/delete/:id", RequireScope(token.ScopeTeamsAdmin, handler.DeleteTeam))
+	router.CoreRouter.HandlerFunc("POST", "/api/team/user/add/:id", RequireScope(token.ScopeTeamsAdmin, handler.AddUserToTeam))
+	router.CoreRouter.HandlerFunc("PUT", "/api/team/plan/update/:id", RequireScope(token.ScopeTeamsAdmin, handler.UpdateTeamPlan))
+}
+
+// registerApiTokenRoutes -> Personal access tokens (managed with the session tokens only)
+func (router *AppRouter) registerApiTokenRoutes(handler *handlers.Handler) {
+	router.CoreRouter.HandlerFunc("POST", "/api/token/create", handler.CreateApiToken)
+	router.CoreRouter.HandlerFunc("GET", "/api/token/get", handler.GetApiTokens)
+	router.CoreRouter.HandlerFunc("DELETE", "/api/token/delete/:id", handler.DeleteApiToken)
 }
@@ -21,4 +21,59 @@ axiosInstance.interceptors.request.use(
     (error) => Promise.reject(error)
 );
 
+// the access tokens are short-lived: on a 401 the refresh token is rotated once and the request is retried.
+// Concurrent 401s share the same refresh, a refresh token can only be used once
+let refreshing = null;
+
+function refreshTokens(userStore) {
+    if (!refreshing) {
+        refreshing = axios
+            .post(`${baseURL}/api/auth/refresh`, {
+                refresh_token: userStore.refreshToken,
+            })
+            .then((resp) => {
+                userStore.setTokens({
+                    token: resp.data.token,
+                    refreshToken: resp.data.refresh_token,
+                });
+            })
+            .catch((err) => {
+                userStore.clearUser();
+                throw err;
+            })
+            .finally(() => {
+                refreshing = null;
+            });
+    }
+
+    return refreshing;
+}
+
+axiosInstance.interceptors.response.use(
+    (response) => response,
+    async (error) => {
+        const userStore = useUserStore();
+        const config = error.config;
+
+        if (
+            error.response?.status !== 401 ||
+            !userStore.refreshToken ||
+            !config ||
+            config._retried ||
+            config.url?.startsWith("/api/auth/")
+        ) {
+            return Promise.reject(error);
+        }
+
+        config._retried = true;
+        try {
+            await refreshTokens(userStore);
+        } catch {
+            return Promise.reject(error);
+        }
+
+        return axiosInstance(config);
+    }
+);
+
 export default axiosInstance;
@@ -7,21 +7,28 @@ export const useUserStore = defineStore("user", {
         username: "",
         plan: "",
         token: "",
+        refreshToken: "",
         avatarUrl: "",
     }),
     actions: {
-        setUser({ id, username, plan, token, avatarUrl }) {
+        setUser({ id, username, plan, token, refreshToken, avatarUrl }) {
             this.id = id;
             this.username = username;
             this.plan = plan;
             this.token = token;
+            this.refreshToken = refreshToken;
             this.avatarUrl = avatarUrl;
         },
+        setTokens({ token, refreshToken }) {
+            this.token = token;
+            if (refreshToken) this.refreshToken = refreshToken;
+        },
         clearUser() {
             this.id = null;
             this.username = "";
             this.plan = "";
             this.token = "";
+            this.refreshToken = "";
             this.avatarUrl = "";
         },
     },